@@ -0,0 +1,84 @@
+// Command ws-latency-record converts a raw sample file recorded by the
+// client with -record into CSV or columnar JSON.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"ws-latency-app-golang/pkg/record"
+)
+
+// Command line flags
+var (
+	format  = flag.String("format", "csv", "Output format: 'csv' (one row per sample) or 'columns' (columnar JSON, one array per field)")
+	outFile = flag.String("out", "", "Output file (default: stdout)")
+)
+
+func main() {
+	flag.Usage = printUsage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		printUsage()
+		os.Exit(1)
+	}
+	if *format != "csv" && *format != "columns" {
+		fmt.Printf("Error: Invalid format '%s'. Must be 'csv' or 'columns'\n", *format)
+		printUsage()
+		os.Exit(1)
+	}
+
+	in, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open record file: %v", err)
+	}
+	defer in.Close()
+
+	out := os.Stdout
+	if *outFile != "" {
+		if out, err = os.Create(*outFile); err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+	}
+
+	var n int64
+	if *format == "columns" {
+		n, err = record.WriteColumns(out, in)
+	} else {
+		var r *record.Reader
+		if r, err = record.NewReader(bufio.NewReaderSize(in, 64*1024)); err == nil {
+			n, err = record.WriteCSV(out, r)
+		}
+	}
+	if errors.Is(err, record.ErrTruncated) {
+		// The recording process was killed mid-write; keep what is complete
+		log.Printf("Warning: %v", err)
+		err = nil
+	}
+	if err != nil {
+		log.Fatalf("Conversion failed: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+	log.Printf("Converted %d samples\n", n)
+}
+
+// printUsage prints the usage information.
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  ws-latency-record [-format=csv|columns] [-out=FILE] RECORD_FILE")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  -format         Output format: csv, one row per sample, or columns, a JSON object with one array")
+	fmt.Println("                  per field for columnar tools (default: csv)")
+	fmt.Println("  -out            Output file (default: stdout)")
+	fmt.Println("")
+	fmt.Println("Fields: conn, seq, intended_us, send_us, server_recv_us, server_send_us, recv_us, warmup, late,")
+	fmt.Println("lost, rtt_us and corrected_rtt_us; times are microseconds since the Unix epoch. Lost samples")
+	fmt.Println("have no round-trip times.")
+}
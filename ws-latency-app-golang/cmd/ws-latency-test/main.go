@@ -7,9 +7,13 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"ws-latency-app-golang/pkg/client"
+	"ws-latency-app-golang/pkg/codec"
 	"ws-latency-app-golang/pkg/server"
 )
 
@@ -19,7 +23,14 @@ var (
 	mode = flag.String("mode", "", "Mode to run: 'server' or 'client' (required)")
 
 	// Server flags
-	port = flag.String("port", "8080", "Port for server to listen on")
+	port          = flag.String("port", "8080", "Port for server to listen on")
+	tlsCert       = flag.String("tls-cert", "", "Server certificate PEM file; serves wss:// (with -tls-key)")
+	tlsKey        = flag.String("tls-key", "", "Server private key PEM file (with -tls-cert)")
+	tlsSelfSigned = flag.Bool("tls-self-signed", false, "Serve wss:// with an ephemeral self-signed certificate")
+	pushRate      = flag.Int("push-rate", 0, "Events per second pushed to clients connected to /push, 0 to disable")
+	pushSize      = flag.Int("push-payload-size", 0, "Approximate pushed event size in bytes (0: no padding)")
+	fastEcho      = flag.Bool("fast-echo", false, "Stamp /ws messages in place without parsing them (JSON and binary codecs)")
+	channels      = flag.String("channels", "tickers:10,trades:100,books5:10", "Channels served at /ws/v5/public as comma-separated channel:rate or channel:instId:rate entries, empty to disable")
 
 	// Client flags
 	serverAddr         = flag.String("server", "ws://localhost:8080/ws", "WebSocket server address for client")
@@ -28,6 +39,49 @@ var (
 	prewarmCount       = flag.Int("prewarm-count", 100, "Skip calculating RTT for first N messages (for warm-up)")
 	insecureSkipVerify = flag.Bool("insecure", false, "Skip TLS certificate verification (not recommended for production)")
 	continuous         = flag.Bool("continuous", false, "Run in continuous monitoring mode")
+	histogramPrecision = flag.Int("precision", 3, "Latency histogram precision in significant figures (1-5)")
+	reportInterval     = flag.Int("report-interval", 10, "Seconds between interval latency reports in continuous mode (0 to disable)")
+	responseTimeout    = flag.Int("response-timeout", 5, "Seconds to wait for a response before counting a message as lost")
+	connections        = flag.Int("connections", 1, "Number of concurrent connections for client; -rate is spread across them")
+	rampUp             = flag.Int("ramp-up", 0, "Seconds over which to open client connections (0 opens them all at once)")
+	closedLoop         = flag.Bool("closed-loop", false, "Keep a fixed number of messages in flight per connection instead of sending at -rate")
+	inFlight           = flag.Int("inflight", 1, "Messages in flight per connection in closed-loop mode (1 is ping-pong)")
+	schedule           = flag.String("schedule", "constant", "Send schedule for client: 'constant', 'poisson', 'burst' or 'trace'")
+	burstSize          = flag.Int("burst-size", 10, "Messages sent back-to-back per burst with -schedule=burst")
+	burstInterval      = flag.Int("burst-interval", 100, "Milliseconds between bursts with -schedule=burst")
+	traceFile          = flag.String("trace-file", "", "File of send timestamps in microseconds, one per line, for -schedule=trace")
+	pacerSpin          = flag.Int("pacer-spin", 100, "Microseconds before each send time to spin instead of sleep, for precise high rates (0 only sleeps)")
+	payloadSize        = flag.Int("payload-size", 0, "Approximate message size in bytes from 16 to 1048576, padded up from the ticker message (0 for no padding)")
+	payloadTemplate    = flag.String("payload-template", "", "JSON file sent in place of the ticker, with {{price}}, {{size}}, {{int}} and {{ts}} placeholders (JSON codec only)")
+	sizeSweep          = flag.String("size-sweep", "", "Comma-separated payload sizes, e.g. 16,1k,64k,1m, to run the test at one after another")
+	codecName          = flag.String("codec", "json", "Message codec: 'json', 'msgpack', 'protobuf' or 'binary', negotiated with the server")
+	profileSpec        = flag.String("profile", "", "Load profile as comma-separated name:rate:duration[:c=N][:size=N][:n=N][:warmup] phases")
+	profileFile        = flag.String("profile-file", "", "JSON file with a load profile; overrides -profile")
+	caFile             = flag.String("ca-file", "", "PEM file of CA certificates to trust for wss:// instead of the system roots")
+	certFile           = flag.String("cert-file", "", "Client certificate PEM file for mutual TLS")
+	keyFile            = flag.String("key-file", "", "Client private key PEM file for mutual TLS")
+	sni                = flag.String("sni", "", "TLS server name (SNI) to send and verify, if not the URL host")
+	tlsMinVersion      = flag.String("tls-min-version", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsMaxVersion      = flag.String("tls-max-version", "", "Maximum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsCiphers         = flag.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suite names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	tlsResume          = flag.Bool("tls-resume", true, "Resume TLS sessions across connections; false forces full handshakes")
+	reconnect          = flag.Bool("reconnect", true, "Reconnect failed connections with exponential backoff in continuous mode")
+	reconnectMin       = flag.Int("reconnect-min-backoff", 100, "Milliseconds to wait before the first reconnect attempt")
+	reconnectMax       = flag.Int("reconnect-max-backoff", 30000, "Maximum milliseconds between reconnect attempts")
+	reconnectJitter    = flag.Float64("reconnect-jitter", 0.2, "Random fraction added to or taken from each reconnect backoff (0-1)")
+	metricsPort        = flag.Int("metrics-port", 0, "Port to serve client Prometheus metrics on at /metrics, 0 to disable")
+	clockProbe         = flag.Int("clock-probe-interval", 0, "Milliseconds between clock probes for one-way latency estimation, e.g. 250; 0 disables them")
+	pingInterval       = flag.Int("ping-interval", 0, "Milliseconds between WebSocket pings sent alongside messages to compare ping RTT with message RTT, 0 to disable")
+	pingOnly           = flag.Bool("ping-only", false, "Send WebSocket pings instead of messages and measure the ping→pong RTT")
+	receive            = flag.Bool("receive", false, "Receive events pushed by the server (its /push endpoint) instead of sending messages")
+	subscribe          = flag.String("subscribe", "", "Channels to subscribe to in receive mode as comma-separated channel:instId entries (server /ws/v5/public endpoint)")
+	churn              = flag.Bool("churn", false, "Benchmark connection churn: repeatedly connect, exchange messages and disconnect")
+	churnRate          = flag.Float64("churn-rate", 10, "New connections per second with -churn")
+	churnMessages      = flag.Int("churn-messages", 1, "Messages exchanged per connection with -churn")
+	churnConcurrency   = flag.Int("churn-concurrency", 100, "Maximum connections open at once with -churn")
+	output             = flag.String("output", "", "Write the test result as 'json' or 'csv' after the test (empty for none)")
+	outputFile         = flag.String("output-file", "", "File to write the -output result to instead of stdout")
+	recordFile         = flag.String("record", "", "File to record every sample to in a compact binary format (read with ws-latency-record)")
 )
 
 func init() {
@@ -62,20 +116,97 @@ func main() {
 // printUsage prints the usage information.
 func printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  Server mode: ws-latency-app -mode=server [-port=8080]")
-	fmt.Println("  Client mode: ws-latency-app -mode=client [-server=ws://localhost:8080/ws] [-rate=10] [-duration=30] [-prewarm-count=100] [-insecure] [-continuous]")
+	fmt.Println("  Server mode: ws-latency-app -mode=server [-port=8080] [-tls-cert=FILE -tls-key=FILE | -tls-self-signed] [-push-rate=0] [-push-payload-size=0] [-channels=tickers:10,trades:100,books5:10] [-fast-echo]")
+	fmt.Println("  Client mode: ws-latency-app -mode=client [-server=ws://localhost:8080/ws] [-rate=10] [-duration=30] [-prewarm-count=100] [-insecure] [-continuous] [-precision=3] [-report-interval=10] [-response-timeout=5] [-connections=1] [-ramp-up=0] [-closed-loop] [-inflight=1] [-schedule=constant] [-pacer-spin=100] [-payload-size=0 | -size-sweep=SIZES] [-payload-template=FILE] [-codec=json] [-profile=SPEC | -profile-file=FILE] [-reconnect=true] [-clock-probe-interval=0] [-ping-interval=0 | -ping-only] [-ca-file=FILE] [-cert-file=FILE -key-file=FILE] [-sni=NAME] [-tls-resume=true] [-metrics-port=0] [-output=json|csv] [-output-file=FILE] [-record=FILE]")
+	fmt.Println("  Receive mode: ws-latency-app -mode=client -receive [-server=ws://localhost:8080/push] [-connections=1] [-duration=30] [-prewarm-count=100] [-clock-probe-interval=0]")
+	fmt.Println("  Subscribe mode: ws-latency-app -mode=client -receive -server=ws://localhost:8080/ws/v5/public -subscribe=tickers:BTC-USDT,trades:BTC-USDT [-connections=1] [-duration=30]")
+	fmt.Println("  Churn mode:  ws-latency-app -mode=client -churn [-server=ws://localhost:8080/ws] [-churn-rate=10] [-churn-messages=1] [-churn-concurrency=100] [-codec=json] [-duration=30]")
 	fmt.Println("")
 	fmt.Println("Options:")
+	fmt.Println("  -tls-cert       Server certificate PEM file; the server terminates TLS and serves wss:// (with -tls-key)")
+	fmt.Println("  -tls-key        Server private key PEM file (with -tls-cert)")
+	fmt.Println("  -tls-self-signed Serve wss:// with a self-signed certificate generated at startup (clients need -insecure)")
+	fmt.Println("  -push-rate      Push ticker events at this rate per second to clients connected to /push (default: 0, disabled)")
+	fmt.Println("  -push-payload-size Approximate pushed event size in bytes, also for subscribed channels (default: 0, no padding)")
+	fmt.Println("  -channels       Channels clients can subscribe to at /ws/v5/public, as channel:rate or channel:instId:rate")
+	fmt.Println("                  entries; rates are events per second per instrument (default: tickers:10,trades:100,books5:10)")
+	fmt.Println("  -fast-echo      Stamp /ws messages by overwriting their server timestamps in place instead of decoding and")
+	fmt.Println("                  re-encoding them (JSON and binary codecs; other messages take the full path)")
 	fmt.Println("  -prewarm-count  Skip calculating RTT for first N messages (default: 100)")
 	fmt.Println("  -insecure       Skip TLS certificate verification (not recommended for production)")
+	fmt.Println("  -ca-file        PEM file of CA certificates to trust for wss:// (e.g. a private CA)")
+	fmt.Println("  -cert-file      Client certificate PEM file for mutual TLS (with -key-file)")
+	fmt.Println("  -key-file       Client private key PEM file for mutual TLS (with -cert-file)")
+	fmt.Println("  -sni            TLS server name to send and verify, if not the URL host")
+	fmt.Println("  -tls-min-version Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fmt.Println("  -tls-max-version Maximum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	fmt.Println("  -tls-ciphers    Comma-separated TLS 1.2 cipher suite names (TLS 1.3 suites are not configurable)")
+	fmt.Println("  -tls-resume     Resume TLS sessions across connections; false forces full handshakes (default: true)")
 	fmt.Println("  -continuous     Run in continuous monitoring mode (ignores duration)")
+	fmt.Println("  -precision      Latency histogram precision in significant figures, 1-5 (default: 3)")
+	fmt.Println("  -report-interval  Seconds between interval and cumulative latency reports in continuous mode, 0 to disable (default: 10)")
+	fmt.Println("  -response-timeout Seconds to wait for a response before counting a message as lost (default: 5)")
+	fmt.Println("  -connections    Number of concurrent connections; -rate is the aggregate rate across them (default: 1)")
+	fmt.Println("  -ramp-up        Seconds over which to open connections, evenly spaced (default: 0)")
+	fmt.Println("  -closed-loop    Send the next message as soon as a response arrives instead of at -rate")
+	fmt.Println("  -inflight       Messages in flight per connection in closed-loop mode, 1 is ping-pong (default: 1)")
+	fmt.Println("  -schedule       Send schedule: constant, poisson, burst or trace (default: constant)")
+	fmt.Println("  -burst-size     Messages per burst with -schedule=burst (default: 10)")
+	fmt.Println("  -burst-interval Milliseconds between bursts with -schedule=burst (default: 100)")
+	fmt.Println("  -trace-file     Send timestamps in microseconds, one per line, for -schedule=trace")
+	fmt.Println("  -pacer-spin     Microseconds before each send time to spin instead of sleep; timers alone wake up")
+	fmt.Println("                  late at high rates, spinning costs CPU. 0 only sleeps (default: 100)")
+	fmt.Println("  -payload-size   Approximate message size in bytes, 16 to 1048576, padded up from the ticker message; smaller")
+	fmt.Println("                  sizes send the test fields alone (default: 0)")
+	fmt.Println("  -payload-template JSON object file sent in place of the ticker, with {{price}}, {{size}}, {{int}} and {{ts}}")
+	fmt.Println("                  placeholders replaced by random values; JSON codec only")
+	fmt.Println("  -size-sweep     Comma-separated payload sizes with optional k or m suffix, e.g. 16,1k,64k,1m; runs a")
+	fmt.Println("                  -duration phase per size and reports latency against size")
+	fmt.Println("  -codec          Message codec: json, msgpack, protobuf or binary (ticker omitted), negotiated with the")
+	fmt.Println("                  server through the WebSocket subprotocol (default: json)")
+	fmt.Println("  -profile        Load profile of comma-separated name:rate:duration[:c=N][:size=N][:n=N][:warmup] phases,")
+	fmt.Println("                  e.g. warmup:100/s:30s:warmup,step1:1000/s:60s,step2:5000/s:60s,soak:10000/s:1h")
+	fmt.Println("  -reconnect      Reconnect failed connections in continuous mode and report outages (default: true)")
+	fmt.Println("  -reconnect-min-backoff Milliseconds before the first reconnect attempt, doubling per attempt (default: 100)")
+	fmt.Println("  -reconnect-max-backoff Maximum milliseconds between reconnect attempts (default: 30000)")
+	fmt.Println("  -reconnect-jitter Random fraction added to or taken from each backoff, 0-1 (default: 0.2)")
+	fmt.Println("  -metrics-port   Serve client Prometheus metrics at /metrics on this port, 0 to disable (default: 0)")
+	fmt.Println("  -clock-probe-interval Milliseconds between clock probes for one-way latency estimation, e.g. 250; probes share the")
+	fmt.Println("                  connection with the measured messages, so they are off by default (default: 0)")
+	fmt.Println("  -ping-interval  Milliseconds between WebSocket pings sent on each connection alongside the messages;")
+	fmt.Println("                  reports ping→pong RTT next to message RTT, 0 to disable (default: 0)")
+	fmt.Println("  -ping-only      Send WebSocket pings at the message schedule instead of messages; RTT is the ping→pong")
+	fmt.Println("                  round trip, without any message encoding or handling or clock probes")
+	fmt.Println("  -receive        Receive events pushed by the server at /push and measure their latency and sequence gaps")
+	fmt.Println("  -subscribe      With -receive, subscribe to these channel:instId entries, e.g. tickers:BTC-USDT,trades:ETH-USDT;")
+	fmt.Println("                  measures subscribe and unsubscribe acknowledgement latency and latency per channel")
+	fmt.Println("  -churn          Repeatedly connect, exchange messages and disconnect; reports handshake latency and failures")
+	fmt.Println("  -churn-rate     New connections per second with -churn (default: 10)")
+	fmt.Println("  -churn-messages Messages exchanged one at a time per connection with -churn (default: 1)")
+	fmt.Println("  -churn-concurrency Maximum connections open at once with -churn (default: 100)")
+	fmt.Println("  -output         Write the test result as json or csv after the test: configuration, environment, delivery")
+	fmt.Println("                  counts, latency percentiles and histogram buckets, handshake timings (default: none)")
+	fmt.Println("  -output-file    File to write the -output result to (default: stdout; logs go to stderr)")
+	fmt.Println("  -record         Record every sample (sequence, send, server and receive timestamps, connection) to this")
+	fmt.Println("                  file in a compact binary format; convert it with ws-latency-record")
+	fmt.Println("  -profile-file   JSON load profile: {\"phases\": [{\"name\", \"rate\", \"duration\", \"connections\", \"payload_size\", \"messages\", \"warmup\"}]}")
 }
 
 // runServer starts the WebSocket server.
 func runServer() {
 	// Create server configuration
 	config := server.Config{
-		Port: *port,
+		Port:            *port,
+		TLSCertFile:     *tlsCert,
+		TLSKeyFile:      *tlsKey,
+		TLSSelfSigned:   *tlsSelfSigned,
+		PushRate:        *pushRate,
+		PushPayloadSize: *pushSize,
+		FastEcho:        *fastEcho,
+	}
+	var err error
+	if config.Channels, err = server.ParseChannels(*channels); err != nil {
+		log.Fatalf("Invalid channels: %v", err)
 	}
 
 	// Create and start server
@@ -87,16 +218,145 @@ func runServer() {
 func runClient() {
 	// Create client configuration
 	config := client.Config{
-		ServerURL:          *serverAddr,
-		MessageRate:        *messageRate,
-		TestDuration:       *testDuration,
-		PrewarmCount:       *prewarmCount,
-		InsecureSkipVerify: *insecureSkipVerify,
-		Continuous:         *continuous,
+		ServerURL:            *serverAddr,
+		MessageRate:          *messageRate,
+		TestDuration:         *testDuration,
+		PrewarmCount:         *prewarmCount,
+		InsecureSkipVerify:   *insecureSkipVerify,
+		Continuous:           *continuous,
+		HistogramPrecision:   *histogramPrecision,
+		ReportInterval:       time.Duration(*reportInterval) * time.Second,
+		ResponseTimeout:      time.Duration(*responseTimeout) * time.Second,
+		Connections:          *connections,
+		RampUp:               time.Duration(*rampUp) * time.Second,
+		ClosedLoop:           *closedLoop,
+		InFlight:             *inFlight,
+		Schedule:             *schedule,
+		BurstSize:            *burstSize,
+		BurstInterval:        time.Duration(*burstInterval) * time.Millisecond,
+		TraceFile:            *traceFile,
+		PayloadSize:          *payloadSize,
+		PayloadTemplate:      *payloadTemplate,
+		PacerSpin:            time.Duration(*pacerSpin) * time.Microsecond,
+		Codec:                *codecName,
+		ChurnRate:            *churnRate,
+		ChurnMessages:        *churnMessages,
+		ChurnConcurrency:     *churnConcurrency,
+		Reconnect:            *reconnect,
+		ReconnectMinBackoff:  time.Duration(*reconnectMin) * time.Millisecond,
+		ReconnectMaxBackoff:  time.Duration(*reconnectMax) * time.Millisecond,
+		ReconnectJitter:      *reconnectJitter,
+		ClockProbeInterval:   time.Duration(*clockProbe) * time.Millisecond,
+		PingInterval:         time.Duration(*pingInterval) * time.Millisecond,
+		PingOnly:             *pingOnly,
+		MetricsPort:          *metricsPort,
+		RecordFile:           *recordFile,
+		TLSCAFile:            *caFile,
+		TLSCertFile:          *certFile,
+		TLSKeyFile:           *keyFile,
+		TLSServerName:        *sni,
+		TLSMinVersion:        *tlsMinVersion,
+		TLSMaxVersion:        *tlsMaxVersion,
+		DisableTLSResumption: !*tlsResume,
+	}
+	if *tlsCiphers != "" {
+		config.TLSCipherSuites = strings.Split(*tlsCiphers, ",")
+	}
+
+	// Load the profile; its phases replace -duration and -prewarm-count
+	var err error
+	if config.Subscriptions, err = client.ParseSubscriptions(*subscribe); err != nil {
+		log.Fatalf("Invalid subscriptions: %v", err)
+	}
+	if len(config.Subscriptions) > 0 && !*receive {
+		log.Fatal("-subscribe requires -receive")
+	}
+	switch *output {
+	case "", client.OutputJSON, client.OutputCSV:
+	default:
+		log.Fatalf("Invalid output format %q: want json or csv", *output)
+	}
+	if *output != "" && (*receive || *churn) {
+		log.Fatal("-output is only supported in test mode, not with -receive or -churn")
+	}
+	if *recordFile != "" && (*receive || *churn) {
+		log.Fatal("-record is only supported in test mode, not with -receive or -churn")
+	}
+	if *pacerSpin < 0 {
+		log.Fatal("-pacer-spin must not be negative")
+	}
+	if _, err := codec.ByName(*codecName); err != nil {
+		log.Fatalf("Invalid codec: %v", err)
+	}
+	if *codecName != codec.JSON.Name() && *receive {
+		log.Fatal("-codec is only supported in test and churn mode, not with -receive")
+	}
+	if *outputFile != "" && *output == "" {
+		log.Fatal("-output-file requires -output")
+	}
+	if *payloadTemplate != "" && (*receive || *churn) {
+		log.Fatal("-payload-template is only supported in test mode, not with -receive or -churn")
+	}
+	if *payloadTemplate != "" && *codecName != codec.JSON.Name() {
+		log.Fatal("-payload-template requires -codec=json")
+	}
+	if *sizeSweep != "" {
+		if *profileSpec != "" || *profileFile != "" || *receive || *churn {
+			log.Fatal("-size-sweep is only supported in test mode without a load profile")
+		}
+		if config.SizeSweep, err = client.ParseSizeSweep(*sizeSweep); err != nil {
+			log.Fatalf("Invalid size sweep: %v", err)
+		}
+	}
+	if *pingInterval < 0 {
+		log.Fatal("-ping-interval must not be negative")
+	}
+	if (*pingInterval > 0 || *pingOnly) && (*receive || *churn) {
+		log.Fatal("-ping-interval and -ping-only are only supported in test mode, not with -receive or -churn")
+	}
+	if *pingOnly && (*pingInterval > 0 || *payloadSize > 0 || *sizeSweep != "" || *payloadTemplate != "" || *clockProbe > 0) {
+		log.Fatal("-ping-only sends fixed pings; it cannot be combined with -ping-interval, -payload-size, -size-sweep, -payload-template or -clock-probe-interval")
+	}
+	if *profileFile != "" {
+		config.Profile, err = client.LoadProfile(*profileFile)
+	} else if *profileSpec != "" {
+		config.Profile, err = client.ParseProfile(*profileSpec)
+	}
+	if err != nil {
+		log.Fatalf("Invalid load profile: %v", err)
+	}
+
+	// Churn connections are too short-lived for a warm-up phase
+	if *churn {
+		config.PrewarmCount = 0
 	}
 
 	// Create client
 	c := client.NewClient(config)
+	if *metricsPort > 0 {
+		if err := c.StartMetrics(); err != nil {
+			log.Fatalf("Metrics failed: %v", err)
+		}
+	}
+
+	// Stop the test on interrupt so final results are still printed
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("Interrupt received, stopping test...")
+		c.Stop()
+		<-sigCh
+		os.Exit(1)
+	}()
+
+	// Churn mode opens its own short-lived connections
+	if *churn {
+		if err := c.RunChurn(); err != nil {
+			log.Fatalf("Churn test failed: %v", err)
+		}
+		return
+	}
 
 	// Connect to server
 	if err := c.Connect(); err != nil {
@@ -104,8 +364,42 @@ func runClient() {
 	}
 	defer c.Close()
 
+	// Receive mode only listens to pushed events
+	if *receive {
+		if err := c.RunReceive(); err != nil {
+			log.Fatalf("Receive test failed: %v", err)
+		}
+		return
+	}
+
 	// Run test
-	if err := c.RunTest(); err != nil {
+	result, err := c.RunTest()
+	if err != nil {
 		log.Fatalf("Test failed: %v", err)
 	}
+	if *output != "" {
+		if err := writeResult(result, *output, *outputFile); err != nil {
+			log.Fatalf("Failed to write result: %v", err)
+		}
+	}
+}
+
+// writeResult writes result in format to path, or to stdout if path is empty.
+func writeResult(result *client.Result, format, path string) error {
+	if path == "" {
+		return result.Write(os.Stdout, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := result.Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Result written to %s\n", path)
+	return nil
 }
@@ -0,0 +1,355 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// ChurnResult summarizes a connection churn test.
+type ChurnResult struct {
+	Attempts           int64
+	Succeeded          int64            // Connections that completed all message exchanges
+	Failures           map[string]int64 // Failed attempts by error class
+	Duration           time.Duration
+	Handshake          *stats.LatencyStats // Dial start to usable WebSocket connection
+	TimeToFirstMessage *stats.LatencyStats // Dial start to the first response
+	RTT                *stats.LatencyStats // Round-trip time of every exchanged message
+}
+
+// churnRun holds the shared state of a running churn test.
+type churnRun struct {
+	mu         sync.Mutex
+	attempts   int64
+	succeeded  int64
+	failures   map[string]int64
+	handshakes *handshakeStats
+	firstMsg   *stats.LatencyStats
+	rtt        *stats.LatencyStats
+}
+
+// RunChurn runs the connection churn benchmark: at ChurnRate connections per
+// second it connects, exchanges ChurnMessages messages one at a time and
+// disconnects, until TestDuration has elapsed or the test is stopped. At most
+// ChurnConcurrency connections are open at once; when that limit is reached,
+// new attempts wait and the achieved rate falls below the target.
+func (c *Client) RunChurn() error {
+	if c.config.ChurnRate <= 0 {
+		return fmt.Errorf("churn rate must be positive, got %v", c.config.ChurnRate)
+	}
+	concurrency := c.config.ChurnConcurrency
+	if concurrency <= 0 {
+		concurrency = 100
+	}
+
+	mc, err := codec.ByName(c.config.Codec)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(c.config)
+	if err != nil {
+		return err
+	}
+	dialer := newDialer(tlsConfig, mc)
+	dialer.HandshakeTimeout = c.config.ResponseTimeout
+
+	run := &churnRun{
+		failures:   make(map[string]int64),
+		handshakes: newHandshakeStats(c.config.HistogramPrecision),
+		firstMsg:   stats.NewLatencyStats("Time to First Message", c.config.HistogramPrecision),
+		rtt:        stats.NewLatencyStats("Churn RTT", c.config.HistogramPrecision),
+	}
+
+	testStart := time.Now()
+	testEnd := testStart.Add(100 * 365 * 24 * time.Hour) // ~100 years
+	if c.config.Continuous {
+		log.Printf("Starting continuous churn test to %s: %.1f conn/s, %d messages each, up to %d concurrent\n",
+			c.config.ServerURL, c.config.ChurnRate, c.config.ChurnMessages, concurrency)
+	} else {
+		log.Printf("Starting churn test to %s for %d seconds: %.1f conn/s, %d messages each, up to %d concurrent\n",
+			c.config.ServerURL, c.config.TestDuration, c.config.ChurnRate, c.config.ChurnMessages, concurrency)
+		testEnd = testStart.Add(time.Duration(c.config.TestDuration) * time.Second)
+	}
+
+	reporterDone := make(chan struct{})
+	if c.config.ReportInterval > 0 {
+		go c.runChurnReporter(run, testStart, reporterDone)
+	}
+
+	// Start attempts at a constant rate, bounded by the concurrency limit
+	slots := newSlots(concurrency)
+	interval := time.Duration(float64(time.Second) / c.config.ChurnRate)
+	var wg sync.WaitGroup
+	for i := 0; ; i++ {
+		intended := testStart.Add(time.Duration(i) * interval)
+		if !intended.Before(testEnd) {
+			break
+		}
+		if wait := time.Until(intended); wait > 0 {
+			select {
+			case <-c.stop:
+			case <-time.After(wait):
+			}
+		}
+		select {
+		case <-c.stop:
+		case <-slots:
+		}
+		if c.stopped() {
+			break
+		}
+
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			defer func() { slots <- struct{}{} }()
+			c.churnOnce(dialer, mc, run, id)
+		}(i)
+	}
+	wg.Wait()
+	close(reporterDone)
+
+	c.churnResult = run.result(time.Since(testStart))
+	printChurnResults(c.churnResult, run.handshakes)
+	return nil
+}
+
+// churnOnce makes one connection attempt, exchanges messages and
+// disconnects, recording the outcome in run. Messages are encoded with mc.
+func (c *Client) churnOnce(dialer *websocket.Dialer, mc codec.Codec, run *churnRun, id int) {
+	start := time.Now()
+	conn, resp, timing, err := dialWithTrace(dialer, c.config.ServerURL)
+	if err != nil {
+		run.fail(classifyDialError(err, resp, timing))
+		return
+	}
+	defer conn.Close()
+	if err := checkCodec(conn, mc); err != nil {
+		run.fail("codec not supported")
+		return
+	}
+	run.connected(timing)
+
+	msg := codec.Message{Ticker: codec.DefaultTicker()}
+	var buf []byte
+	for seq := 0; seq < c.config.ChurnMessages; seq++ {
+		sendUs := time.Now().UnixNano() / 1000
+		msg.Sequence = uint64(seq)
+		msg.ClientIntendedUs = sendUs
+		msg.ClientSendUs = sendUs
+		message, err := mc.Append(buf[:0], &msg)
+		if err != nil {
+			log.Println("Encode error:", err)
+			return
+		}
+		buf = message
+
+		conn.SetWriteDeadline(time.Now().Add(c.config.ResponseTimeout))
+		if err := conn.WriteMessage(mc.MessageType(), message); err != nil {
+			run.fail(classifyMessageError("write", err))
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(c.config.ResponseTimeout))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			run.fail(classifyMessageError("read", err))
+			return
+		}
+
+		recvUs := time.Now().UnixNano() / 1000
+		run.rtt.AddSample(recvUs - sendUs)
+		if seq == 0 {
+			run.firstMsg.AddSample(time.Since(start).Microseconds())
+		}
+	}
+
+	// Close cleanly so the server sees a normal disconnect
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+
+	run.mu.Lock()
+	run.succeeded++
+	run.mu.Unlock()
+}
+
+// connected records a successful handshake.
+func (r *churnRun) connected(t HandshakeTiming) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	r.handshakes.add(t)
+}
+
+// fail records a failed attempt. Failures after the handshake were already
+// counted as attempts.
+func (r *churnRun) fail(class string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !isMessageError(class) {
+		r.attempts++
+	}
+	r.failures[class]++
+}
+
+// snapshot returns the attempt, success and failure counts so far.
+func (r *churnRun) snapshot() (attempts, succeeded, failed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, n := range r.failures {
+		failed += n
+	}
+	return r.attempts, r.succeeded, failed
+}
+
+// result returns the final results of the run.
+func (r *churnRun) result(duration time.Duration) *ChurnResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failures := make(map[string]int64, len(r.failures))
+	for class, n := range r.failures {
+		failures[class] = n
+	}
+	handshake := r.handshakes.total.Snapshot(false)
+	handshake.Name = "Handshake"
+	return &ChurnResult{
+		Attempts:           r.attempts,
+		Succeeded:          r.succeeded,
+		Failures:           failures,
+		Duration:           duration,
+		Handshake:          handshake,
+		TimeToFirstMessage: r.firstMsg.Snapshot(false),
+		RTT:                r.rtt.Snapshot(false),
+	}
+}
+
+// classifyDialError maps a failed dial to an error class, using the trace
+// timing to tell which step of the connection setup failed.
+func classifyDialError(err error, resp *http.Response, timing HandshakeTiming) string {
+	if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Sprintf("upgrade status %d", resp.StatusCode)
+	}
+
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns error"
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthErr), errors.As(err, &hostnameErr):
+		return "tls certificate error"
+	case errors.As(err, &alertErr), errors.As(err, &recordErr):
+		return "tls error"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		if timing.TCPConnect == 0 {
+			return "dial timeout"
+		}
+		if timing.TLSHandshake > 0 && timing.Upgrade == 0 {
+			// The upgrade request is only sent after a successful TLS handshake
+			return "tls timeout"
+		}
+		return "handshake timeout"
+	case errors.Is(err, websocket.ErrBadHandshake):
+		return "bad handshake"
+	default:
+		return "other dial error"
+	}
+}
+
+// messagePrefix marks error classes of failures after the handshake.
+const messagePrefix = "message "
+
+// classifyMessageError maps a failed message exchange to an error class.
+func classifyMessageError(op string, err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return messagePrefix + op + " timeout"
+	case websocket.IsUnexpectedCloseError(err) || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return messagePrefix + op + " closed by server"
+	default:
+		return messagePrefix + op + " error"
+	}
+}
+
+// isMessageError reports whether class is a failure after the handshake.
+func isMessageError(class string) bool {
+	return strings.HasPrefix(class, messagePrefix)
+}
+
+// runChurnReporter prints a one-line churn summary every ReportInterval until
+// done is closed.
+func (c *Client) runChurnReporter(run *churnRun, testStart time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(c.config.ReportInterval)
+	defer ticker.Stop()
+
+	lastReport := testStart
+	var lastAttempts, lastSucceeded, lastFailed int64
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			attempts, succeeded, failed := run.snapshot()
+			period := now.Sub(lastReport)
+			total := run.handshakes.total.Snapshot(false)
+			first := run.firstMsg.Snapshot(false)
+			log.Printf("[Churn %s] attempts=%d (%.1f/s) ok=%d failed=%d | Handshake p50=%d p99=%d max=%d | First msg p50=%d p99=%d (µs, cumulative)\n",
+				period.Round(time.Second), attempts-lastAttempts, float64(attempts-lastAttempts)/period.Seconds(),
+				succeeded-lastSucceeded, failed-lastFailed,
+				total.P50, total.P99, total.Max, first.P50, first.P99)
+			lastReport, lastAttempts, lastSucceeded, lastFailed = now, attempts, succeeded, failed
+		}
+	}
+}
+
+// printChurnResults prints the final results of a churn test.
+func printChurnResults(r *ChurnResult, handshakes *handshakeStats) {
+	var failed int64
+	classes := make([]string, 0, len(r.Failures))
+	for class, n := range r.Failures {
+		failed += n
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	log.Println("===== Connection Churn =====")
+	log.Printf("Attempts:     %d (%.2f conn/s)\n", r.Attempts, float64(r.Attempts)/r.Duration.Seconds())
+	log.Printf("Succeeded:    %d\n", r.Succeeded)
+	log.Printf("Failed:       %d (%.3f%%)\n", failed, lossPercent(failed, r.Attempts))
+	for _, class := range classes {
+		log.Printf("  %-24s %d\n", class+":", r.Failures[class])
+	}
+
+	log.Println("===== Connection Setup (µs) =====")
+	handshakes.print()
+	r.Handshake.PrintResults()
+	r.TimeToFirstMessage.PrintResults()
+	r.RTT.PrintResults()
+}
+
+// GetChurnResult returns the results of the last churn test
+func (c *Client) GetChurnResult() *ChurnResult {
+	return c.churnResult
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRunChurn(t *testing.T) {
+	srv := newEchoServer(t)
+	c := NewClient(Config{
+		ServerURL:        "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		TestDuration:     1,
+		ChurnRate:        50,
+		ChurnMessages:    3,
+		ChurnConcurrency: 10,
+	})
+	if err := c.RunChurn(); err != nil {
+		t.Fatalf("RunChurn: %v", err)
+	}
+
+	r := c.GetChurnResult()
+	if r.Attempts < 45 || r.Attempts > 51 {
+		t.Errorf("attempts = %d, want about 50", r.Attempts)
+	}
+	if r.Succeeded != r.Attempts || len(r.Failures) != 0 {
+		t.Errorf("succeeded = %d of %d, failures %v", r.Succeeded, r.Attempts, r.Failures)
+	}
+	if r.Handshake.Count != r.Attempts || r.TimeToFirstMessage.Count != r.Succeeded || r.RTT.Count != 3*r.Succeeded {
+		t.Errorf("sample counts: handshake %d, first message %d, RTT %d", r.Handshake.Count, r.TimeToFirstMessage.Count, r.RTT.Count)
+	}
+	if r.TimeToFirstMessage.Min < r.Handshake.Min {
+		t.Errorf("first message after %dµs, before the fastest handshake of %dµs", r.TimeToFirstMessage.Min, r.Handshake.Min)
+	}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	srv := newEchoServer(t)
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// A listener that is closed again leaves a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "ws://" + ln.Addr().String() + "/ws"
+	ln.Close()
+
+	// A listener that accepts but never answers makes the handshake time out
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{base + "/missing", "upgrade status 404"},
+		{refused, "connection refused"},
+		{"ws://" + silent.Addr().String() + "/ws", "handshake timeout"},
+	}
+	dialer := &websocket.Dialer{HandshakeTimeout: 200 * time.Millisecond}
+	for _, tt := range tests {
+		conn, resp, timing, err := dialWithTrace(dialer, tt.url)
+		if err == nil {
+			conn.Close()
+			t.Errorf("%s: dial succeeded", tt.url)
+			continue
+		}
+		if got := classifyDialError(err, resp, timing); got != tt.want {
+			t.Errorf("%s: class = %q, want %q (%v)", tt.url, got, tt.want, err)
+		}
+	}
+}
@@ -2,109 +2,234 @@
 package client
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
-	"math/rand"
 	"net"
+	"sync"
 	"time"
 
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/record"
 	"ws-latency-app-golang/pkg/stats"
 
 	"github.com/gorilla/websocket"
 )
 
-// Config holds the configuration for the WebSocket client
+// Config holds the configuration for the WebSocket client. In JSON,
+// durations are Go duration strings such as "10s".
 type Config struct {
-	ServerURL          string
-	MessageRate        int
-	TestDuration       int
-	PrewarmCount       int
-	InsecureSkipVerify bool
-	Continuous         bool
+	ServerURL          string        `json:"server_url"`
+	MessageRate        int           `json:"message_rate"`
+	TestDuration       int           `json:"test_duration_s"`
+	PrewarmCount       int           `json:"prewarm_count"`
+	InsecureSkipVerify bool          `json:"insecure_skip_verify"`
+	Continuous         bool          `json:"continuous"`
+	HistogramPrecision int           `json:"histogram_precision"`
+	ReportInterval     time.Duration `json:"report_interval"` // Between interval reports in continuous mode; 0 disables them
+	ResponseTimeout    time.Duration `json:"response_timeout"`
+	Connections        int           `json:"connections"`
+	RampUp             time.Duration `json:"ramp_up"`
+	ClosedLoop         bool          `json:"closed_loop"`
+	InFlight           int           `json:"inflight"`
+	Schedule           string        `json:"schedule"`
+	BurstSize          int           `json:"burst_size"`
+	BurstInterval      time.Duration `json:"burst_interval"`
+	TraceFile          string        `json:"trace_file"`
+	PayloadSize        int           `json:"payload_size"`         // Approximate encoded message size in bytes; 0 leaves messages unpadded
+	PayloadTemplate    string        `json:"payload_template"`     // JSON file sent in place of the ticker, with {{placeholders}}; JSON codec only
+	SizeSweep          []int         `json:"size_sweep,omitempty"` // Payload sizes to run the test at, one phase each, without a profile
+	Codec              string        `json:"codec"`                // Message codec, negotiated with the server; empty uses JSON
+	PacerSpin          time.Duration `json:"pacer_spin"`           // Time before each intended send time spent spinning instead of sleeping; 0 only sleeps
+	Profile            []Phase       `json:"profile,omitempty"`    // Load-profile phases; empty runs a single phase from the fields above
+	ChurnRate          float64       `json:"churn_rate"`           // Connection attempts per second in churn mode
+	ChurnMessages      int           `json:"churn_messages"`       // Messages exchanged per connection in churn mode
+	ChurnConcurrency   int           `json:"churn_concurrency"`    // Maximum connections open at once in churn mode
+
+	// Channels to subscribe to in receive mode; empty receives whatever the
+	// server pushes, as on its /push endpoint
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+
+	// Port to serve Prometheus metrics on with StartMetrics
+	MetricsPort int `json:"metrics_port"`
+
+	// File to record every sample to in the binary format of package
+	// record; empty disables recording
+	RecordFile string `json:"record_file,omitempty"`
+
+	// Interval between clock probes used to estimate one-way latency; 0
+	// disables them
+	ClockProbeInterval time.Duration `json:"clock_probe_interval"`
+
+	// Interval between WebSocket pings sent alongside the test messages to
+	// compare ping→pong RTT with message RTT; 0 disables them
+	PingInterval time.Duration `json:"ping_interval"`
+
+	// Send WebSocket pings in place of the test messages, so RTT is the
+	// ping→pong round trip of the protocol stack alone. Disables clock
+	// probes.
+	PingOnly bool `json:"ping_only"`
+
+	// Reconnect after connection failures in continuous mode, waiting an
+	// exponentially growing backoff between attempts
+	Reconnect           bool          `json:"reconnect"`
+	ReconnectMinBackoff time.Duration `json:"reconnect_min_backoff"`
+	ReconnectMaxBackoff time.Duration `json:"reconnect_max_backoff"`
+	ReconnectJitter     float64       `json:"reconnect_jitter"` // Random fraction added to or taken from each backoff
+
+	// TLS settings for wss:// URLs
+	TLSCAFile            string   `json:"tls_ca_file"`                 // PEM bundle of CAs to trust instead of the system roots
+	TLSCertFile          string   `json:"tls_cert_file"`               // Client certificate for mutual TLS
+	TLSKeyFile           string   `json:"tls_key_file"`                // Client private key for mutual TLS
+	TLSServerName        string   `json:"tls_server_name"`             // SNI and verification name, if not the URL host
+	TLSMinVersion        string   `json:"tls_min_version"`             // "1.0" to "1.3"; empty uses the crypto/tls default
+	TLSMaxVersion        string   `json:"tls_max_version"`             // "1.0" to "1.3"; empty uses the crypto/tls default
+	TLSCipherSuites      []string `json:"tls_cipher_suites,omitempty"` // IANA names; applies to TLS 1.2 and below
+	DisableTLSResumption bool     `json:"disable_tls_resumption"`      // Force a full handshake on every connection
 }
 
-// Client represents a WebSocket client for latency testing
+// Client represents a WebSocket client for latency testing. It drives one or
+// more connections and merges their statistics.
 type Client struct {
-	config            Config
-	conn              *websocket.Conn
-	stats             *stats.LatencyStats
-	baseMsg           map[string]interface{}
-	done              chan struct{}
-	expectedResponses int
-	receivedResponses int
+	config         Config
+	phases         []Phase
+	conns          []*connection
+	stats          *stats.LatencyStats
+	correctedStats *stats.LatencyStats
+	dwellStats     *stats.LatencyStats
+	pathStats      *stats.LatencyStats
+	upStats        *stats.LatencyStats
+	downStats      *stats.LatencyStats
+	pingStats      *stats.LatencyStats
+	clockEstimates []ClockEstimate
+	phaseResults   []PhaseResult
+	handshakes     []HandshakeTiming
+	churnResult    *ChurnResult
+	receiveResult  *ReceiveResult
+	metrics        *clientMetrics
+	stop           chan struct{}
+	stopOnce       sync.Once
 }
 
 // NewClient creates a new WebSocket client with the given configuration
 func NewClient(config Config) *Client {
-	// Calculate expected responses, accounting for prewarm messages
-	expectedResponses := config.MessageRate * config.TestDuration
-	if config.Continuous {
-		// For continuous mode, set a high number
-		expectedResponses = 1000000000
+	// Fall back to the default response timeout and a single connection
+	if config.ResponseTimeout <= 0 {
+		config.ResponseTimeout = 5 * time.Second
+	}
+	if config.Connections <= 0 {
+		config.Connections = 1
+	}
+	if config.InFlight <= 0 {
+		config.InFlight = 1
+	}
+	if config.Codec == "" {
+		config.Codec = codec.JSON.Name()
+	}
+	if config.ReconnectMinBackoff <= 0 {
+		config.ReconnectMinBackoff = DefaultReconnectMinBackoff
+	}
+	if config.ReconnectMaxBackoff < config.ReconnectMinBackoff {
+		config.ReconnectMaxBackoff = DefaultReconnectMaxBackoff
+		if config.ReconnectMaxBackoff < config.ReconnectMinBackoff {
+			config.ReconnectMaxBackoff = config.ReconnectMinBackoff
+		}
+	}
+	// Clock probes are messages, and would share the connection with the
+	// pings that replace them
+	if config.PingOnly {
+		config.ClockProbeInterval = 0
 	}
 
 	// Log prewarm information if enabled
-	if config.PrewarmCount > 0 {
-		log.Printf("Will skip first %d messages for warm-up phase", config.PrewarmCount)
-	}
-
-	// No metrics server initialization
-
-	// Create base message template
-	baseMsg := map[string]interface{}{
-		"arg": map[string]interface{}{
-			"channel": "tickers",
-			"instId":  "BTC-USDC",
-		},
-		"data": []map[string]interface{}{
-			{
-				"instType":  "SPOT",
-				"instId":    "BTC-USDC",
-				"last":      "105926",
-				"lastSz":    "0.00016398",
-				"askPx":     "105926.1",
-				"askSz":     "0.34547131",
-				"bidPx":     "105926",
-				"bidSz":     "0.04848602",
-				"open24h":   "103124.1",
-				"high24h":   "106892.7",
-				"low24h":    "102100.6",
-				"sodUtc0":   "105619.9",
-				"sodUtc8":   "104822.1",
-				"volCcy24h": "78820585.423264371",
-				"vol24h":    "755.56112024",
-				"ts":        "1747721466604",
-			},
-		},
-		"_test": map[string]interface{}{
-			"client_send_ts_us": 0,
-			"server_ts_us":      0,
-			"client_recv_ts_us": 0,
-		},
-	}
-
-	return &Client{
-		config:            config,
-		stats:             stats.NewLatencyStats(expectedResponses),
-		baseMsg:           baseMsg,
-		done:              make(chan struct{}),
-		expectedResponses: expectedResponses,
-		receivedResponses: 0,
+	phases := buildPhases(config)
+	if len(config.Profile) == 0 && config.PrewarmCount > 0 {
+		log.Printf("Will skip first %d messages per connection for warm-up phase", config.PrewarmCount)
+	}
+
+	// Open enough connections for the largest phase; smaller phases use the
+	// first ones
+	c := &Client{
+		config:         config,
+		phases:         phases,
+		conns:          make([]*connection, maxConnections(phases)),
+		stats:          stats.NewLatencyStats("RTT", config.HistogramPrecision),
+		correctedStats: stats.NewLatencyStats("Corrected RTT", config.HistogramPrecision),
+		stop:           make(chan struct{}),
 	}
+	c.metrics = newClientMetrics(c)
+	for i := range c.conns {
+		c.conns[i] = newConnection(i, config)
+		c.conns[i].metrics = c.metrics
+	}
+	return c
 }
 
-// Connect connects to the WebSocket server
+// Connect connects to the WebSocket server. With more than one connection
+// and a ramp-up period, connections are opened evenly spaced over the period.
 func (c *Client) Connect() error {
-	// No metrics updates
+	mc, err := codec.ByName(c.config.Codec)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(c.config)
+	if err != nil {
+		return err
+	}
+	dialer := newDialer(tlsConfig, mc)
 
-	// Set up WebSocket dialer with custom options for lower latency
-	dialer := websocket.DefaultDialer
-	dialer.NetDial = func(network, addr string) (net.Conn, error) {
+	// Connect to WebSocket server
+	if len(c.conns) == 1 {
+		log.Printf("Connecting to %s...\n", c.config.ServerURL)
+	} else {
+		log.Printf("Opening %d connections to %s over %v...\n", len(c.conns), c.config.ServerURL, c.config.RampUp)
+	}
+	rampStep := c.config.RampUp / time.Duration(len(c.conns))
+	rampStart := time.Now()
+	c.handshakes = make([]HandshakeTiming, 0, len(c.conns))
+	for i, cn := range c.conns {
+		if wait := time.Until(rampStart.Add(time.Duration(i) * rampStep)); wait > 0 {
+			time.Sleep(wait)
+		}
+		conn, _, timing, err := dialWithTrace(dialer, c.config.ServerURL)
+		if err != nil {
+			return fmt.Errorf("connection %d dial error: %w", i, err)
+		}
+		if err := checkCodec(conn, mc); err != nil {
+			conn.Close()
+			return err
+		}
+		cn.conn = conn
+		cn.dialer = dialer
+		c.handshakes = append(c.handshakes, timing)
+	}
+	if len(c.conns) == 1 {
+		t := c.handshakes[0]
+		log.Printf("Connected to server at %s in %v (DNS %v, TCP %v, TLS %v, upgrade %v)%s\n",
+			t.RemoteAddr, t.Total, t.DNS, t.TCPConnect, t.TLSHandshake, t.Upgrade, t.tlsSummary())
+	} else {
+		log.Printf("Connected to server (%d connections)\n", len(c.conns))
+	}
+	return nil
+}
+
+// newDialer sets up a WebSocket dialer with custom options for lower
+// latency and the given TLS configuration for wss:// URLs. The context-aware
+// dial lets request tracing time DNS and TCP connect. Each call returns a copy
+// of the default dialer, so reconnects may dial concurrently. Codecs other
+// than JSON are requested as a subprotocol.
+func newDialer(tlsConfig *tls.Config, c codec.Codec) *websocket.Dialer {
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = tlsConfig
+	if c != codec.JSON {
+		dialer.Subprotocols = []string{codec.Subprotocol(c)}
+	}
+	dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		netDialer := &net.Dialer{
 			Timeout: 5 * time.Second,
 		}
-		conn, err := netDialer.Dial(network, addr)
+		conn, err := netDialer.DialContext(ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
@@ -115,180 +240,525 @@ func (c *Client) Connect() error {
 		}
 		return conn, nil
 	}
+	return &dialer
+}
 
-	// Connect to WebSocket server
-	log.Printf("Connecting to %s...\n", c.config.ServerURL)
-	conn, _, err := dialer.Dial(c.config.ServerURL, nil)
-	if err != nil {
-		return fmt.Errorf("dial error: %w", err)
+// checkCodec returns an error unless the server of conn agreed to use c.
+// Servers that do not know codecs agree to no subprotocol, which means JSON.
+func checkCodec(conn *websocket.Conn, c codec.Codec) error {
+	if codec.BySubprotocol(conn.Subprotocol()) != c {
+		return fmt.Errorf("server does not support the %s codec", c.Name())
 	}
-	c.conn = conn
-	log.Println("Connected to server")
 	return nil
 }
 
-// Close closes the WebSocket connection
+// Close closes all WebSocket connections
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	var firstErr error
+	for _, cn := range c.conns {
+		if err := cn.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
 }
 
-// randomizeMessage updates the client's message with random values
-func (c *Client) randomizeMessage() {
-	msg := c.baseMsg
-	data := msg["data"].([]map[string]interface{})
-	item := data[0]
-
-	// Randomize numeric values
-	item["last"] = fmt.Sprintf("%d", 100000+rand.Intn(10000))
-	item["lastSz"] = fmt.Sprintf("0.%08d", rand.Intn(100000000))
-	item["askPx"] = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
-	item["askSz"] = fmt.Sprintf("0.%08d", rand.Intn(100000000))
-	item["bidPx"] = fmt.Sprintf("%d", 100000+rand.Intn(10000))
-	item["bidSz"] = fmt.Sprintf("0.%08d", rand.Intn(100000000))
-	item["open24h"] = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
-	item["high24h"] = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
-	item["low24h"] = fmt.Sprintf("%d.%d", 90000+rand.Intn(10000), rand.Intn(10))
-	item["sodUtc0"] = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
-	item["sodUtc8"] = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
-	item["volCcy24h"] = fmt.Sprintf("%d.%09d", 70000000+rand.Intn(20000000), rand.Intn(1000000000))
-	item["vol24h"] = fmt.Sprintf("%d.%08d", 700+rand.Intn(100), rand.Intn(100000000))
-	item["ts"] = fmt.Sprintf("%d", time.Now().UnixNano()/1000000)
+// Stop ends a running test early. RunTest stops sending, collects outstanding
+// responses and prints the final results as if the test had run to completion.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
 }
 
-// RunTest runs the latency test
-func (c *Client) RunTest() error {
-	if c.conn == nil {
-		return fmt.Errorf("not connected to server")
+// stopped reports whether Stop has been called
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
 	}
+}
 
-	// Use the client's base message
+// RunTest runs the latency test, one load-profile phase after another, and
+// returns its result
+func (c *Client) RunTest() (*Result, error) {
+	for _, cn := range c.conns {
+		if cn.currentConn() == nil {
+			return nil, fmt.Errorf("not connected to server")
+		}
+	}
+	if err := validatePhases(c.phases, c.config.Continuous); err != nil {
+		return nil, err
+	}
 
-	// Set up rate limiter
-	interval := time.Second / time.Duration(c.config.MessageRate)
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	var trace []time.Duration
+	if !c.config.ClosedLoop && c.config.Schedule == ScheduleTrace {
+		var err error
+		if trace, err = loadTrace(c.config.TraceFile); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded %d send timestamps from %s\n", len(trace), c.config.TraceFile)
+	}
+	var template *payloadTemplate
+	if c.config.PayloadTemplate != "" {
+		if c.config.Codec != codec.JSON.Name() {
+			return nil, fmt.Errorf("payload templates require the json codec, not %s", c.config.Codec)
+		}
+		var err error
+		if template, err = loadPayloadTemplate(c.config.PayloadTemplate); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded payload template from %s\n", c.config.PayloadTemplate)
+	}
+	var recorder *record.Recorder
+	if c.config.RecordFile != "" {
+		var err error
+		if recorder, err = record.Create(c.config.RecordFile); err != nil {
+			return nil, err
+		}
+	}
 
-	// Reset the client's state
-	c.receivedResponses = 0
-	c.done = make(chan struct{})
+	// Reset the connections' state and set up response handlers
+	sendingDone := make(chan struct{})
+	for _, cn := range c.conns {
+		cn.sendingDone = sendingDone
+		cn.outages = nil
+		cn.tracker = newInflightTracker()
+		cn.sequence = 0
+		cn.template = template
+		cn.done = make(chan struct{})
+		cn.slots = nil
+		if c.config.ClosedLoop {
+			cn.slots = newSlots(c.config.InFlight)
+		}
+		cn.phaseStats = make([]*phaseStats, len(c.phases))
+		for i, phase := range c.phases {
+			cn.phaseStats[i] = newPhaseStats(phase, c.config.HistogramPrecision)
+		}
+		cn.clock.reset()
+		cn.pingsSent.Store(0)
+		cn.pongsReceived.Store(0)
+		cn.recorder = nil
+		if recorder != nil {
+			cn.recorder = recorder.Stream()
+		}
+		go cn.readResponses(cn.currentConn())
+		if c.config.ClockProbeInterval > 0 {
+			go cn.runClockProbes(sendingDone)
+		}
+		if c.config.PingInterval > 0 {
+			go cn.runPings(sendingDone)
+		}
+	}
+	if c.config.PingOnly {
+		log.Println("Ping-only mode: sending WebSocket pings in place of messages; RTT is the ping→pong round trip")
+	}
 
-	// Set up response handler
-	messageCount := 0
-	go func() {
-		defer close(c.done)
-		for {
-			_, message, err := c.conn.ReadMessage()
-			if err != nil {
-				log.Println("Read error:", err)
-				return
-			}
+	testStart := time.Now()
+	if c.phased() {
+		log.Printf("Running load profile with %d phases:\n", len(c.phases))
+		for i, phase := range c.phases {
+			log.Printf("  %d. %v\n", i+1, phase)
+		}
+	}
 
-			// Record receive time
-			recvTime := time.Now().UnixNano() / 1000
+	// Start periodic reporting in continuous mode, whose results would
+	// otherwise only be seen when it is stopped, and response timeout
+	// tracking
+	reporterDone := make(chan struct{})
+	if c.config.Continuous && c.config.ReportInterval > 0 {
+		go c.runReporter(testStart, reporterDone)
+	}
+	go c.runTimeoutSweeper(reporterDone)
+	defer close(reporterDone)
 
-			// Parse response
-			var data map[string]interface{}
-			if err := json.Unmarshal(message, &data); err != nil {
-				log.Println("JSON parse error:", err)
-				continue
-			}
+	for i, phase := range c.phases {
+		if c.stopped() {
+			break
+		}
+		last := i == len(c.phases)-1
+		if err := c.runPhase(i, phase, trace, last && c.config.Continuous); err != nil {
+			close(sendingDone)
+			c.closeRecorder(recorder)
+			return nil, err
+		}
+	}
+	// Connections still down give up reconnecting
+	close(sendingDone)
 
-			// Extract timestamps
-			if test, ok := data["_test"].(map[string]interface{}); ok {
-				sendTime := int64(test["client_send_ts_us"].(float64))
-				test["client_recv_ts_us"] = recvTime
+	counts := c.deliveryCounts()
+	actualDuration := time.Since(testStart)
+	log.Printf("Test completed. Sent %d messages in %.2f seconds (%.2f msg/s)\n",
+		counts.Sent, actualDuration.Seconds(), float64(counts.Sent)/actualDuration.Seconds())
+	sendDuration := actualDuration
+
+	// Wait for outstanding responses, then count whatever is left as lost
+	log.Printf("Waiting up to %v for %d outstanding responses...\n", c.config.ResponseTimeout, c.outstanding())
+	c.waitForResponses(c.config.ResponseTimeout)
+	nowUs := time.Now().UnixNano() / 1000
+	for _, cn := range c.conns {
+		cn.tracker.expireAll(nowUs)
+		cn.tracker.forgetTimedOut(cn.recordLost())
+	}
+	c.closeRecorder(recorder)
+
+	// Merge and display statistics
+	c.stats.Reset()
+	c.correctedStats.Reset()
+	for _, cn := range c.conns {
+		cn.stats.Calculate()
+		cn.correctedStats.Calculate()
+		c.stats.Merge(cn.stats)
+		c.correctedStats.Merge(cn.correctedStats)
+	}
+	c.stats.Calculate()
+	c.correctedStats.Calculate()
+	c.dwellStats = c.mergeStats("Server Dwell", func(cn *connection) *stats.LatencyStats { return cn.dwellStats }, false)
+	c.pathStats = c.mergeStats("Network + Client", func(cn *connection) *stats.LatencyStats { return cn.pathStats }, false)
+	c.upStats = c.mergeStats("Client→Server", func(cn *connection) *stats.LatencyStats { return cn.upStats }, false)
+	c.downStats = c.mergeStats("Server→Client", func(cn *connection) *stats.LatencyStats { return cn.downStats }, false)
+	c.pingStats = c.mergeStats("Ping RTT", func(cn *connection) *stats.LatencyStats { return cn.pingStats }, false)
+	c.clockEstimates = c.clockEstimates[:0]
+	for _, cn := range c.conns {
+		estimate, _ := cn.clock.estimate(cn.id)
+		c.clockEstimates = append(c.clockEstimates, estimate)
+	}
+	c.collectPhaseResults()
 
-				// Calculate RTT
-				rtt := recvTime - sendTime
+	printHandshakeResults(c.handshakes, c.config.HistogramPrecision)
+	if len(c.conns) > 1 {
+		printConnectionResults(c.conns)
+	}
+	if c.phased() {
+		printPhaseResults(c.phaseResults, !c.config.ClosedLoop)
+	}
+	if len(c.config.Profile) == 0 && len(c.config.SizeSweep) > 0 {
+		printSizeSweep(c.phaseResults, !c.config.ClosedLoop)
+	}
+	printDeliveryResults(c.deliveryCounts())
+	printThroughput(c.deliveryCounts(), sendDuration, len(c.conns))
+	if outages := c.GetOutages(); len(outages) > 0 || c.reconnecting() {
+		printOutageResults(outages, len(c.conns), sendDuration)
+	}
+	for _, result := range c.phaseResults {
+		if result.Phase.Warmup {
+			log.Printf("Note: responses to the %d messages of warm-up phase %q are excluded from the results below", result.Sent, result.Phase.Name)
+		}
+	}
+	c.stats.PrintResults()
+	if !c.config.ClosedLoop {
+		// Closed-loop sends have no schedule, so corrected RTT equals RTT
+		c.correctedStats.PrintResults()
+	}
+	if c.dwellStats.Count > 0 {
+		// RTT split into time in the server and time on the path
+		c.dwellStats.PrintResults()
+		c.pathStats.PrintResults()
+	}
+	if c.config.ClockProbeInterval > 0 {
+		printClockResults(c.clockEstimates)
+		if c.upStats.Count > 0 {
+			// Estimated from the clock offset, so only as good as its error bound
+			c.upStats.PrintResults()
+			c.downStats.PrintResults()
+		}
+	}
+	if c.config.PingInterval > 0 {
+		sent, received := c.pingCounts()
+		c.pingStats.PrintResults()
+		printPingResults(c.stats, c.pingStats, sent, received)
+	}
 
-				// Increment message count
-				messageCount++
+	return c.buildResult(testStart, time.Now(), sendDuration), nil
+}
 
-				// Only add to statistics if we're past the warm-up phase
-				if messageCount > c.config.PrewarmCount {
-					c.stats.AddSample(rtt)
+// closeRecorder writes the samples still buffered by recorder, if any, and
+// closes its file. Responses arriving later are not recorded.
+func (c *Client) closeRecorder(recorder *record.Recorder) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("Recording failed: %v", err)
+	}
+	log.Printf("Recorded %d samples to %s (%d dropped)\n", recorder.Written(), c.config.RecordFile, recorder.Dropped())
+}
 
-					c.receivedResponses++
-					if c.receivedResponses >= c.expectedResponses {
-						return
-					}
-				} else if messageCount == c.config.PrewarmCount {
-					log.Printf("Warm-up phase complete. Skipped first %d messages.\n", c.config.PrewarmCount)
-				}
+// runPhase sends the load of one phase on its connections and returns when
+// the phase is over. If endless is set, the phase runs until the test is
+// stopped.
+func (c *Client) runPhase(index int, phase Phase, trace []time.Duration, endless bool) error {
+	conns := c.conns[:phase.Connections]
+
+	// Spread the phase's load across its connections, one schedule each
+	schedules := make([]Schedule, len(conns))
+	phaseConfig := c.config
+	phaseConfig.MessageRate = phase.Rate
+	if !c.config.ClosedLoop {
+		for i := range schedules {
+			schedule, err := newSchedule(phaseConfig, trace, i, len(conns))
+			if err != nil {
+				return fmt.Errorf("phase %q: %w", phase.Name, err)
 			}
+			schedules[i] = schedule
 		}
-	}()
+	}
 
-	// Run test for specified duration or continuously
-	sentMessages := 0
-	testStart := time.Now()
-	var testEnd time.Time
+	// Render the phase's messages before its clock starts. Pings carry a
+	// fixed payload instead.
+	for _, cn := range conns {
+		if c.config.PingOnly {
+			cn.phaseStats[index].messageBytes = pingPayloadSize
+			continue
+		}
+		if err := cn.setPayloadSize(phase.PayloadSize); err != nil {
+			return fmt.Errorf("phase %q: %w", phase.Name, err)
+		}
+		cn.phaseStats[index].messageBytes = cn.payloads.size()
+	}
+	if size := conns[0].phaseStats[index].messageBytes; phase.PayloadSize > 0 && size > phase.PayloadSize+payloadSizeSlack {
+		log.Printf("Phase %q: messages are %d B, the smallest the %s codec sends, above the payload size of %d B\n",
+			phase.Name, size, conns[0].codec.Name(), phase.PayloadSize)
+	}
 
-	if c.config.Continuous {
-		log.Printf("Starting continuous test with rate %d msg/s\n", c.config.MessageRate)
-		// Set testEnd to a far future time
-		testEnd = testStart.Add(100 * 365 * 24 * time.Hour) // ~100 years
-	} else {
-		log.Printf("Starting test with rate %d msg/s for %d seconds\n", c.config.MessageRate, c.config.TestDuration)
-		log.Printf("Will send approximately %d messages\n", c.config.MessageRate*c.config.TestDuration)
-		testEnd = testStart.Add(time.Duration(c.config.TestDuration) * time.Second)
+	load := describeSchedule(phaseConfig)
+	if c.config.ClosedLoop {
+		load = fmt.Sprintf("closed loop, %d in flight per connection", c.config.InFlight)
+	}
+	phaseStart := time.Now()
+	var phaseEnd time.Time
+	switch {
+	case endless:
+		log.Printf("Starting continuous phase %q with %s over %d connections\n", phase.Name, load, len(conns))
+		// Set phaseEnd to a far future time
+		phaseEnd = phaseStart.Add(100 * 365 * 24 * time.Hour) // ~100 years
+	case phase.Duration > 0:
+		log.Printf("Starting phase %q with %s over %d connections for %v\n", phase.Name, load, len(conns), phase.Duration)
+		if !c.config.ClosedLoop && (c.config.Schedule == "" || c.config.Schedule == ScheduleConstant || c.config.Schedule == SchedulePoisson) {
+			expected := int(float64(phase.Rate) * phase.Duration.Seconds())
+			if phase.Messages > 0 && phase.Messages*len(conns) < expected {
+				expected = phase.Messages * len(conns)
+			}
+			log.Printf("Will send approximately %d messages\n", expected)
+		}
+		phaseEnd = phaseStart.Add(phase.Duration)
+	default:
+		log.Printf("Starting phase %q with %s over %d connections, %d messages per connection\n", phase.Name, load, len(conns), phase.Messages)
+		phaseEnd = phaseStart.Add(100 * 365 * 24 * time.Hour)
 	}
 
-	for time.Now().Before(testEnd) {
-		<-ticker.C
+	if c.config.ClosedLoop {
+		c.metrics.setRate(0)
+	} else {
+		c.metrics.setRate(phase.Rate)
+	}
+	var wg sync.WaitGroup
+	for i, cn := range conns {
+		cn.phase = index
+		cn.warmup.Store(phase.Warmup)
+		wg.Add(1)
+		go func(cn *connection, schedule Schedule) {
+			defer wg.Done()
+			if c.config.ClosedLoop {
+				cn.closedLoopSendLoop(phaseEnd, phase.Messages, c.stop)
+			} else {
+				cn.sendLoop(phaseStart, phaseEnd, schedule, phase.Messages, c.stop)
+			}
+		}(cn, schedules[i])
+	}
+	wg.Wait()
 
-		// Generate random values while keeping same format
-		c.randomizeMessage()
+	var sent int64
+	for _, cn := range conns {
+		sent += cn.phaseStats[index].sent.Load()
+	}
+	log.Printf("Phase %q complete. Sent %d messages in %.2f seconds\n", phase.Name, sent, time.Since(phaseStart).Seconds())
+	return nil
+}
 
-		// Add client timestamp
-		test := c.baseMsg["_test"].(map[string]interface{})
-		test["client_send_ts_us"] = time.Now().UnixNano() / 1000
+// phased reports whether the test runs phases of its own, from a load
+// profile or a size sweep, rather than a warm-up and a main phase.
+func (c *Client) phased() bool {
+	return len(c.config.Profile) > 0 || len(c.config.SizeSweep) > 0
+}
 
-		// Send message
-		message, err := json.Marshal(c.baseMsg)
-		if err != nil {
-			log.Println("JSON marshal error:", err)
-			continue
+// collectPhaseResults merges each phase's statistics over all connections.
+func (c *Client) collectPhaseResults() {
+	c.phaseResults = make([]PhaseResult, len(c.phases))
+	for i, phase := range c.phases {
+		result := PhaseResult{
+			Phase:          phase,
+			Stats:          stats.NewLatencyStats(phase.Name+" RTT", c.config.HistogramPrecision),
+			CorrectedStats: stats.NewLatencyStats(phase.Name+" Corrected RTT", c.config.HistogramPrecision),
 		}
-
-		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			log.Println("Write error:", err)
-			break
+		for _, cn := range c.conns {
+			ps := cn.phaseStats[i]
+			result.MessageBytes = max(result.MessageBytes, ps.messageBytes)
+			result.Sent += ps.sent.Load()
+			result.Stats.Merge(ps.stats)
+			result.CorrectedStats.Merge(ps.correctedStats)
 		}
-		sentMessages++
+		result.Stats.Calculate()
+		result.CorrectedStats.Calculate()
+		c.phaseResults[i] = result
 	}
+}
 
-	actualDuration := time.Since(testStart)
-	log.Printf("Test completed. Sent %d messages in %.2f seconds (%.2f msg/s)\n",
-		sentMessages, actualDuration.Seconds(), float64(sentMessages)/actualDuration.Seconds())
+// runTimeoutSweeper periodically marks in-flight messages older than the
+// response timeout as timed out until done is closed.
+func (c *Client) runTimeoutSweeper(done <-chan struct{}) {
+	period := c.config.ResponseTimeout / 4
+	if period < 10*time.Millisecond {
+		period = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
 
-	// Wait for all responses with a timeout
-	log.Println("Waiting for all responses...")
-	timeout := time.NewTimer(5 * time.Second)
-	select {
-	case <-c.done:
-		log.Printf("Received all %d responses\n", c.receivedResponses)
-	case <-timeout.C:
-		log.Printf("Timeout waiting for responses. Received %d/%d\n", c.receivedResponses, sentMessages)
+	timeoutUs := c.config.ResponseTimeout.Microseconds()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, cn := range c.conns {
+				cn.releaseSlots(cn.tracker.expire(now.UnixNano()/1000, timeoutUs, cn.recordLost()))
+			}
+		}
 	}
+}
 
-	// Calculate and display statistics
-	c.stats.Calculate()
+// outstanding returns the number of messages in flight on all connections.
+func (c *Client) outstanding() int {
+	total := 0
+	for _, cn := range c.conns {
+		total += cn.tracker.outstanding()
+	}
+	return total
+}
+
+// waitForResponses waits until no messages are in flight or the timeout
+// expires. Connections whose reader has exited are not waited for. It returns
+// true if all responses arrived.
+func (c *Client) waitForResponses(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(10 * time.Millisecond)
+	defer poll.Stop()
+
+	for {
+		waiting := false
+		for _, cn := range c.conns {
+			select {
+			case <-cn.done:
+			default:
+				if cn.tracker.outstanding() > 0 {
+					waiting = true
+				}
+			}
+		}
+		if !waiting {
+			return c.outstanding() == 0
+		}
 
-	// Add information about warm-up phase if enabled
-	if c.config.PrewarmCount > 0 {
-		log.Printf("Note: First %d messages were skipped for warm-up phase", c.config.PrewarmCount)
+		select {
+		case <-deadline.C:
+			return false
+		case <-poll.C:
+		}
 	}
+}
 
-	c.stats.PrintResults()
+// deliveryCounts returns the delivery counts summed over all connections.
+func (c *Client) deliveryCounts() DeliveryCounts {
+	var total DeliveryCounts
+	for _, cn := range c.conns {
+		total = total.add(cn.tracker.snapshot())
+	}
+	return total
+}
 
-	return nil
+// pingCounts returns the interleaved pings sent and pongs received, summed
+// over all connections.
+func (c *Client) pingCounts() (sent, received int64) {
+	for _, cn := range c.conns {
+		sent += cn.pingsSent.Load()
+		received += cn.pongsReceived.Load()
+	}
+	return sent, received
 }
 
-// GetStats returns the latency statistics
+// GetStats returns the latency statistics merged over all connections
 func (c *Client) GetStats() *stats.LatencyStats {
 	return c.stats
 }
+
+// GetDeliveryCounts returns the message delivery counts of the last test
+func (c *Client) GetDeliveryCounts() DeliveryCounts {
+	return c.deliveryCounts()
+}
+
+// reconnecting reports whether failed connections are reconnected
+func (c *Client) reconnecting() bool {
+	return c.config.Reconnect && c.config.Continuous
+}
+
+// GetOutages returns the connection outages of the last test, grouped by
+// connection in the order they happened
+func (c *Client) GetOutages() []Outage {
+	var outages []Outage
+	for _, cn := range c.conns {
+		o, _ := cn.outageSnapshot()
+		outages = append(outages, o...)
+	}
+	return outages
+}
+
+// GetHandshakeTimings returns the connection setup timing of each connection,
+// in the order the connections were opened
+func (c *Client) GetHandshakeTimings() []HandshakeTiming {
+	return c.handshakes
+}
+
+// GetPhaseResults returns the per-phase results of the last test, in the
+// order the phases ran
+func (c *Client) GetPhaseResults() []PhaseResult {
+	return c.phaseResults
+}
+
+// GetDwellStats returns the time messages spent in the server, merged over
+// all connections. It is empty if the server does not stamp its send time.
+func (c *Client) GetDwellStats() *stats.LatencyStats {
+	return c.dwellStats
+}
+
+// GetPathStats returns RTT minus server dwell time, the time spent in the
+// network and the client, merged over all connections
+func (c *Client) GetPathStats() *stats.LatencyStats {
+	return c.pathStats
+}
+
+// GetOneWayStats returns the estimated client→server and server→client
+// latency, merged over all connections. They are empty without clock probes.
+func (c *Client) GetOneWayStats() (up, down *stats.LatencyStats) {
+	return c.upStats, c.downStats
+}
+
+// GetPingStats returns the ping→pong RTT of interleaved pings, merged over
+// all connections. It is empty unless PingInterval is set.
+func (c *Client) GetPingStats() *stats.LatencyStats {
+	return c.pingStats
+}
+
+// GetClockEstimates returns the clock offset estimate of each connection at
+// the end of the last test
+func (c *Client) GetClockEstimates() []ClockEstimate {
+	return c.clockEstimates
+}
+
+// GetCorrectedStats returns the latency statistics measured from each
+// message's intended send time, merged over all connections
+func (c *Client) GetCorrectedStats() *stats.LatencyStats {
+	return c.correctedStats
+}
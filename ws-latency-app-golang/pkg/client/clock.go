@@ -0,0 +1,276 @@
+package client
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clock offset estimation
+const (
+	// clockWindow is the number of probes per filter window. Only the probe
+	// with the lowest round-trip delay in each window is used, as it was the
+	// least affected by queueing on either path.
+	clockWindow = 4
+
+	// clockMaxPoints is the number of filtered probes the offset and drift
+	// are fitted over. Older ones are dropped, so slowly changing drift is
+	// followed.
+	clockMaxPoints = 300
+
+	// clockMinDriftPoints is the number of filtered probes needed before drift
+	// is fitted. With fewer, probe noise dominates the slope, so the offset of
+	// the lowest-delay probe is used with zero drift.
+	clockMinDriftPoints = 8
+
+	// clockDelaySlackUs sets, with twice the lowest delay, how much more
+	// delay than the best probe a filtered probe may have to be fitted over.
+	// Windows in which every probe was queued would otherwise bias the fit.
+	clockDelaySlackUs = 100
+)
+
+// ClockEstimate is the estimated offset of the server clock from the client
+// clock on one connection: server time = client time + offset.
+type ClockEstimate struct {
+	Conn     int     `json:"conn"`
+	Offset   float64 `json:"offset_us"` // Microseconds, at the time of the latest filtered probe
+	Error    float64 `json:"error_us"`  // Microseconds; half the delay of the best probe bounds the offset error
+	DriftPPM float64 `json:"drift_ppm"` // Rate at which the offset changes, in microseconds per second
+	Probes   int64   `json:"probes"`    // Probe responses received
+	Points   int     `json:"points"`    // Filtered probes with a delay close enough to the lowest to be fitted over
+}
+
+// clockPoint is one filtered probe exchange.
+type clockPoint struct {
+	clientUs float64 // Midpoint of the exchange on the client clock
+	offsetUs float64 // Server clock minus client clock at clientUs
+	delayUs  int64   // Round-trip delay excluding the time spent in the server
+}
+
+// clockEstimator estimates a server's clock offset and drift NTP-style from
+// probe exchanges, each giving the client send (t0), server receive (t1),
+// server send (t2) and client receive (t3) times. For each exchange
+//
+//	offset = ((t1 - t0) + (t2 - t3)) / 2
+//	delay  = (t3 - t0) - (t2 - t1)
+//
+// assuming symmetric paths for that probe. The minimum-delay probe of each
+// window is kept, and a least-squares line through the kept offsets gives the
+// offset at any time and the drift.
+type clockEstimator struct {
+	mu      sync.Mutex
+	probes  int64
+	window  []clockPoint
+	points  []clockPoint
+	used    int // Points close enough to the lowest delay to be fitted over
+	ready   bool
+	refUs   float64 // Client time the fitted offset refers to
+	offset  float64
+	drift   float64 // Microseconds of offset per microsecond
+	errorUs float64
+}
+
+// newClockEstimator creates an estimator without any probes.
+func newClockEstimator() *clockEstimator {
+	return &clockEstimator{}
+}
+
+// reset forgets all probes, for when the connection may now reach a different
+// server.
+func (e *clockEstimator) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.probes = 0
+	e.window, e.points = nil, nil
+	e.ready = false
+}
+
+// addProbe records one probe exchange. Exchanges with a negative delay, where
+// the server apparently took longer than the whole round trip, are dropped.
+func (e *clockEstimator) addProbe(t0, t1, t2, t3 int64) {
+	delay := (t3 - t0) - (t2 - t1)
+	if delay < 0 || t2 < t1 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.probes++
+	e.window = append(e.window, clockPoint{
+		clientUs: float64(t0) + float64(t3-t0)/2,
+		offsetUs: (float64(t1-t0) + float64(t2-t3)) / 2,
+		delayUs:  delay,
+	})
+	if len(e.window) < clockWindow {
+		return
+	}
+
+	best := e.window[0]
+	for _, p := range e.window[1:] {
+		if p.delayUs < best.delayUs {
+			best = p
+		}
+	}
+	e.window = e.window[:0]
+	e.points = append(e.points, best)
+	if len(e.points) > clockMaxPoints {
+		e.points = e.points[len(e.points)-clockMaxPoints:]
+	}
+	e.fit()
+}
+
+// fit fits offset = e.offset + e.drift * (t - e.refUs) through the filtered
+// points whose delay is close to the lowest. Until there are
+// clockMinDriftPoints of them, or if they are all at one instant, the drift is
+// zero. The caller must hold e.mu.
+func (e *clockEstimator) fit() {
+	best := e.points[0]
+	for _, p := range e.points {
+		if p.delayUs < best.delayUs {
+			best = p
+		}
+	}
+	e.errorUs = float64(best.delayUs) / 2
+	e.ready = true
+
+	limit := max(2*best.delayUs, best.delayUs+clockDelaySlackUs)
+	var n, meanX, meanY float64
+	for _, p := range e.points {
+		if p.delayUs <= limit {
+			n++
+			meanX += p.clientUs
+			meanY += p.offsetUs
+		}
+	}
+	meanX /= n
+	meanY /= n
+	var sxx, sxy float64
+	for _, p := range e.points {
+		if p.delayUs <= limit {
+			dx := p.clientUs - meanX
+			sxx += dx * dx
+			sxy += dx * (p.offsetUs - meanY)
+		}
+	}
+	e.used = int(n)
+	if e.used < clockMinDriftPoints || sxx == 0 {
+		e.refUs, e.offset, e.drift = best.clientUs, best.offsetUs, 0
+		return
+	}
+	e.refUs, e.offset, e.drift = meanX, meanY, sxy/sxx
+}
+
+// offsetAt returns the estimated offset at client time clientUs, or false
+// before the first filter window is complete.
+func (e *clockEstimator) offsetAt(clientUs int64) (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ready {
+		return 0, false
+	}
+	return e.offset + e.drift*(float64(clientUs)-e.refUs), true
+}
+
+// estimate returns the current estimate for connection id, or false if there
+// is none yet.
+func (e *clockEstimator) estimate(id int) (ClockEstimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ready {
+		return ClockEstimate{Conn: id, Probes: e.probes}, false
+	}
+	latest := e.points[len(e.points)-1].clientUs
+	return ClockEstimate{
+		Conn:     id,
+		Offset:   e.offset + e.drift*(latest-e.refUs),
+		Error:    e.errorUs,
+		DriftPPM: e.drift * 1e6,
+		Probes:   e.probes,
+		Points:   e.used,
+	}, true
+}
+
+// oneWay splits a round trip into estimated client→server and server→client
+// latency using the server's receive (t1) and send (t2) timestamps. Estimates
+// below zero, which only an offset error can cause, are clamped to zero.
+func (e *clockEstimator) oneWay(t0, t1, t2, t3 int64) (up, down int64, ok bool) {
+	offset, ok := e.offsetAt(t0)
+	if !ok {
+		return 0, 0, false
+	}
+	up = int64(float64(t1) - offset - float64(t0))
+	down = int64(float64(t3) - (float64(t2) - offset))
+	return max(up, 0), max(down, 0), true
+}
+
+// runClockProbes sends a clock probe every ClockProbeInterval until done is
+// closed. Probes are skipped while the connection is down.
+func (cn *connection) runClockProbes(done <-chan struct{}) {
+	ticker := time.NewTicker(cn.config.ClockProbeInterval)
+	defer ticker.Stop()
+	probe := codec.Message{Probe: true}
+	var buf []byte
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		conn := cn.currentConn()
+		if conn == nil {
+			continue
+		}
+
+		probe.ClientSendUs = time.Now().UnixNano() / 1000
+		message, err := cn.codec.Append(buf[:0], &probe)
+		if err != nil {
+			log.Println("Encode error:", err)
+			return
+		}
+		buf = message
+		if err := cn.write(conn, message); err != nil {
+			if !cn.handleFailure(conn, err) {
+				return
+			}
+		}
+	}
+}
+
+// handleProbe feeds a probe response into the clock estimator.
+func (cn *connection) handleProbe(resp *codec.Message, recvUs int64) {
+	if resp.ClientSendUs == 0 || resp.ServerRecvUs == 0 {
+		return
+	}
+	// Without a send timestamp the server is assumed to respond instantly
+	serverSendUs := resp.ServerSendUs
+	if serverSendUs <= 0 {
+		serverSendUs = resp.ServerRecvUs
+	}
+	cn.clock.addProbe(resp.ClientSendUs, resp.ServerRecvUs, serverSendUs, recvUs)
+}
+
+// write sends one message of the connection's codec. Writes are serialized
+// because clock probes and pings are sent from their own goroutines;
+// sendMessage and ping take the same lock before they take the send time.
+func (cn *connection) write(conn *websocket.Conn, message []byte) error {
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	return conn.WriteMessage(cn.codec.MessageType(), message)
+}
+
+// printClockResults prints the clock estimate of every connection.
+func printClockResults(estimates []ClockEstimate) {
+	log.Println("===== Clock Offset (server - client) =====")
+	for _, e := range estimates {
+		if e.Points == 0 {
+			log.Printf("Conn %3d: no estimate (%d probes, need %d)\n", e.Conn, e.Probes, clockWindow)
+			continue
+		}
+		log.Printf("Conn %3d: offset=%.1fµs ±%.1fµs drift=%.2fppm (%d probes, %d filtered)\n",
+			e.Conn, e.Offset, e.Error, e.DriftPPM, e.Probes, e.Points)
+	}
+}
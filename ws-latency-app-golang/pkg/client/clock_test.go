@@ -0,0 +1,133 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverClock returns the server time for client time t with the given
+// offset (µs) and drift (ppm).
+func serverClock(t int64, offset, driftPPM float64) int64 {
+	return t + int64(offset+driftPPM*float64(t)/1e6)
+}
+
+func TestClockEstimatorOffsetAndDrift(t *testing.T) {
+	const offset, drift = 5000.0, 20.0
+	rng := rand.New(rand.NewSource(1))
+	e := newClockEstimator()
+
+	if _, ok := e.offsetAt(0); ok {
+		t.Fatal("estimate before any probes")
+	}
+
+	// 200 probes 100ms apart with a 100µs symmetric base delay; most are
+	// delayed further by queueing on one path or the other
+	for i := int64(0); i < 200; i++ {
+		t0 := i * 100_000
+		up, down := int64(50), int64(50)
+		if rng.Intn(4) != 0 {
+			up += rng.Int63n(2000)
+			down += rng.Int63n(2000)
+		}
+		t1 := serverClock(t0+up, offset, drift)
+		t2 := t1 + 10
+		t3 := t0 + up + 10 + down
+		e.addProbe(t0, t1, t2, t3)
+	}
+
+	est, ok := e.estimate(0)
+	if !ok || est.Probes != 200 || est.Points < clockMinDriftPoints {
+		t.Fatalf("estimate = %+v, %v", est, ok)
+	}
+	if math.Abs(est.DriftPPM-drift) > 5 {
+		t.Errorf("drift = %.2fppm, want %.0fppm", est.DriftPPM, drift)
+	}
+	for _, at := range []int64{0, 10_000_000, 19_900_000} {
+		got, _ := e.offsetAt(at)
+		want := offset + drift*float64(at)/1e6
+		if math.Abs(got-want) > 100 {
+			t.Errorf("offset at %dµs = %.1f, want %.1f", at, got, want)
+		}
+	}
+
+	// An asymmetric message is split using the estimated offset
+	t0 := int64(15_000_000)
+	t1 := serverClock(t0+400, offset, drift)
+	up, down, ok := e.oneWay(t0, t1, t1+20, t0+400+20+100)
+	if !ok || math.Abs(float64(up-400)) > 100 || math.Abs(float64(down-100)) > 100 {
+		t.Errorf("oneWay = %d, %d, %v, want about 400, 100", up, down, ok)
+	}
+
+	e.reset()
+	if _, ok := e.offsetAt(t0); ok {
+		t.Error("estimate after reset")
+	}
+}
+
+func TestClockEstimatorWithoutDrift(t *testing.T) {
+	// Too few filtered probes to fit drift: the lowest-delay probe is used
+	e := newClockEstimator()
+	for i, delay := range []int64{900, 100, 500, 700} {
+		t0 := int64(i) * 1000
+		e.addProbe(t0, t0+delay/2+300, t0+delay/2+300, t0+delay)
+	}
+	est, ok := e.estimate(0)
+	if !ok || est.Offset != 300 || est.DriftPPM != 0 || est.Error != 50 {
+		t.Errorf("estimate = %+v, %v", est, ok)
+	}
+
+	// Negative delays are dropped
+	e.addProbe(0, 500, 2000, 1000)
+	if est, _ := e.estimate(0); est.Probes != 4 {
+		t.Errorf("probes = %d after an invalid probe, want 4", est.Probes)
+	}
+}
+
+func TestOneWayLatency(t *testing.T) {
+	// The server clock runs one second ahead of the client's
+	const skew = time.Second
+	srv := newTestServer(t, func(conn *websocket.Conn) {
+		echo(conn, 0, func(message []byte) []byte {
+			return stampTest(message, func(test map[string]interface{}) {
+				now := time.Now().Add(skew).UnixNano() / 1000
+				test["server_ts_us"], test["server_send_ts_us"] = now, now
+			})
+		})
+	})
+
+	c := NewClient(Config{
+		ServerURL:          "ws" + strings.TrimPrefix(srv.URL, "http"),
+		MessageRate:        100,
+		TestDuration:       1,
+		ClockProbeInterval: 10 * time.Millisecond,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	estimates := c.GetClockEstimates()
+	if len(estimates) != 1 || estimates[0].Points == 0 {
+		t.Fatalf("estimates = %+v", estimates)
+	}
+	if e := estimates[0]; math.Abs(e.Offset-float64(skew.Microseconds())) > e.Error+1000 {
+		t.Errorf("offset = %.1f±%.1fµs, want about %dµs", e.Offset, e.Error, skew.Microseconds())
+	}
+
+	// Without the offset, one-way latencies would be about a second
+	up, down := c.GetOneWayStats()
+	if up.Count == 0 || down.Count == 0 {
+		t.Fatalf("one-way counts = %d, %d", up.Count, down.Count)
+	}
+	if up.P50 > 10_000 || down.P50 > 10_000 {
+		t.Errorf("one-way p50 = %dµs, %dµs, want loopback latencies", up.P50, down.P50)
+	}
+}
@@ -0,0 +1,432 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/record"
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// connection is a single WebSocket connection of the client with its own
+// message sequence, in-flight table and latency statistics.
+type connection struct {
+	id                int
+	config            Config
+	conn              *websocket.Conn
+	stats             *stats.LatencyStats
+	correctedStats    *stats.LatencyStats
+	intervalStats     *stats.LatencyStats
+	intervalCorrected *stats.LatencyStats
+	dwellStats        *stats.LatencyStats // Time spent in the server, from its receive and send timestamps
+	pathStats         *stats.LatencyStats // RTT minus server dwell: network plus client time
+	intervalDwell     *stats.LatencyStats
+	intervalPath      *stats.LatencyStats
+	upStats           *stats.LatencyStats // Estimated client→server latency
+	downStats         *stats.LatencyStats // Estimated server→client latency
+	intervalUp        *stats.LatencyStats
+	intervalDown      *stats.LatencyStats
+	pingStats         *stats.LatencyStats // Ping→pong RTT of interleaved pings
+	intervalPing      *stats.LatencyStats
+	pingsSent         atomic.Int64
+	pongsReceived     atomic.Int64
+	clock             *clockEstimator
+	writeMu           sync.Mutex
+	codec             codec.Codec
+	msg               codec.Message    // Template of the messages sent, owned by the sender
+	payloads          *payloadRing     // Messages rendered from msg, owned by the sender
+	template          *payloadTemplate // Sent in place of the ticker if set
+	bare              bool             // Send the test fields alone, for payload sizes below the message size
+	done              chan struct{}
+	tracker           *inflightTracker
+	sequence          uint64
+	slots             chan struct{}
+	phase             int           // Index of the phase being sent, owned by the sender
+	warmup            atomic.Bool   // Whether the phase being sent is a warm-up phase
+	phaseStats        []*phaseStats // Statistics per phase, indexed by the phase a message was sent in
+	metrics           *clientMetrics
+	recorder          *record.Stream // Raw samples, nil unless recording
+
+	// Reconnect state. conn is nil and up is open while the connection is down.
+	dialer      *websocket.Dialer
+	mu          sync.Mutex
+	up          chan struct{}
+	sendingDone <-chan struct{}
+	outages     []Outage
+	downSince   time.Time
+}
+
+// newConnection creates an unconnected connection with the given id.
+func newConnection(id int, config Config) *connection {
+	up := make(chan struct{})
+	close(up)
+	// An unknown codec is reported by Connect
+	c, err := codec.ByName(config.Codec)
+	if err != nil {
+		c = codec.JSON
+	}
+	return &connection{
+		up:                up,
+		id:                id,
+		config:            config,
+		stats:             stats.NewLatencyStats("RTT", config.HistogramPrecision),
+		correctedStats:    stats.NewLatencyStats("Corrected RTT", config.HistogramPrecision),
+		intervalStats:     stats.NewLatencyStats("Interval RTT", config.HistogramPrecision),
+		intervalCorrected: stats.NewLatencyStats("Interval Corrected RTT", config.HistogramPrecision),
+		dwellStats:        stats.NewLatencyStats("Server Dwell", config.HistogramPrecision),
+		pathStats:         stats.NewLatencyStats("Network + Client", config.HistogramPrecision),
+		intervalDwell:     stats.NewLatencyStats("Interval Server Dwell", config.HistogramPrecision),
+		intervalPath:      stats.NewLatencyStats("Interval Network + Client", config.HistogramPrecision),
+		upStats:           stats.NewLatencyStats("Client→Server", config.HistogramPrecision),
+		downStats:         stats.NewLatencyStats("Server→Client", config.HistogramPrecision),
+		intervalUp:        stats.NewLatencyStats("Interval Client→Server", config.HistogramPrecision),
+		intervalDown:      stats.NewLatencyStats("Interval Server→Client", config.HistogramPrecision),
+		pingStats:         stats.NewLatencyStats("Ping RTT", config.HistogramPrecision),
+		intervalPing:      stats.NewLatencyStats("Interval Ping RTT", config.HistogramPrecision),
+		clock:             newClockEstimator(),
+		codec:             c,
+		msg:               codec.Message{Ticker: codec.DefaultTicker()},
+		done:              make(chan struct{}),
+		tracker:           newInflightTracker(),
+	}
+}
+
+// close closes the WebSocket connection
+func (cn *connection) close() error {
+	if conn := cn.currentConn(); conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// randomizeMessage updates the connection's message with random values
+func (cn *connection) randomizeMessage() {
+	t := &cn.msg.Ticker
+
+	// Randomize numeric values
+	t.Last = fmt.Sprintf("%d", 100000+rand.Intn(10000))
+	t.LastSz = fmt.Sprintf("0.%08d", rand.Intn(100000000))
+	t.AskPx = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
+	t.AskSz = fmt.Sprintf("0.%08d", rand.Intn(100000000))
+	t.BidPx = fmt.Sprintf("%d", 100000+rand.Intn(10000))
+	t.BidSz = fmt.Sprintf("0.%08d", rand.Intn(100000000))
+	t.Open24h = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
+	t.High24h = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
+	t.Low24h = fmt.Sprintf("%d.%d", 90000+rand.Intn(10000), rand.Intn(10))
+	t.SodUtc0 = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
+	t.SodUtc8 = fmt.Sprintf("%d.%d", 100000+rand.Intn(10000), rand.Intn(10))
+	t.VolCcy24h = fmt.Sprintf("%d.%09d", 70000000+rand.Intn(20000000), rand.Intn(1000000000))
+	t.Vol24h = fmt.Sprintf("%d.%08d", 700+rand.Intn(100), rand.Intn(100000000))
+	t.Ts = fmt.Sprintf("%d", time.Now().UnixNano()/1000000)
+}
+
+// sendLoop sends messages at the intended send times produced by schedule,
+// relative to start, until testEnd, until maxMessages have been sent (if
+// positive) or until stop is closed. If sending falls behind, the backlog is
+// sent immediately rather than skipped, so stalls show up in the corrected
+// RTT.
+func (cn *connection) sendLoop(start, testEnd time.Time, schedule Schedule, maxMessages int, stop <-chan struct{}) {
+	pacer := newPacer(cn.config.PacerSpin)
+	for sent := 0; maxMessages <= 0 || sent < maxMessages; sent++ {
+		offset, ok := schedule.Next()
+		if !ok {
+			return
+		}
+		intendedTime := start.Add(offset)
+		if !intendedTime.Before(testEnd) {
+			return
+		}
+
+		if !pacer.wait(intendedTime, stop) {
+			return
+		}
+		if err := cn.sendMessage(intendedTime.UnixNano() / 1000); err != nil {
+			log.Printf("Connection %d write error: %v", cn.id, err)
+			return
+		}
+	}
+}
+
+// newSlots creates the in-flight slots of a closed-loop connection, all of
+// them initially free.
+func newSlots(inFlight int) chan struct{} {
+	slots := make(chan struct{}, inFlight)
+	for i := 0; i < inFlight; i++ {
+		slots <- struct{}{}
+	}
+	return slots
+}
+
+// closedLoopSendLoop keeps a fixed number of messages outstanding until
+// testEnd, until maxMessages have been sent (if positive) or until stop is
+// closed, sending the next message as soon as a response arrives or an
+// in-flight message times out.
+func (cn *connection) closedLoopSendLoop(testEnd time.Time, maxMessages int, stop <-chan struct{}) {
+	deadline := time.NewTimer(time.Until(testEnd))
+	defer deadline.Stop()
+	for sent := 0; maxMessages <= 0 || sent < maxMessages; sent++ {
+		// While reconnecting there is nothing to keep in flight
+		select {
+		case <-stop:
+			return
+		case <-deadline.C:
+			return
+		case <-cn.upChan():
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-deadline.C:
+			return
+		case <-cn.slots:
+		}
+
+		// There is no schedule in closed-loop mode, so the intended send
+		// time is the actual send time
+		if err := cn.sendMessage(0); err != nil {
+			log.Printf("Connection %d write error: %v", cn.id, err)
+			return
+		}
+	}
+}
+
+// releaseSlots returns n in-flight slots to a closed-loop sender. It never
+// blocks; in open-loop mode it does nothing.
+func (cn *connection) releaseSlots(n int) {
+	if cn.slots == nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case cn.slots <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// sendMessage stamps and sends the next message of the payload ring, or in
+// ping-only mode a ping. If intendedUs is 0, the actual send time is used as
+// the intended send time. While the connection is down, or if the write
+// fails and a reconnect is started, the message is counted as unsent
+// instead.
+func (cn *connection) sendMessage(intendedUs int64) error {
+	conn := cn.currentConn()
+	if conn == nil {
+		cn.tracker.addUnsent()
+		cn.releaseSlots(1)
+		return nil
+	}
+
+	seq := cn.sequence
+	cn.sequence++
+	var message []byte
+	var slots codec.Slots
+	if !cn.config.PingOnly {
+		message, slots = cn.payloads.take()
+		slots.SetSequence(message, seq)
+	}
+
+	// Register the message, which must come before the write, and take the
+	// write lock before the send time, then only update the send time in
+	// the in-flight table. Without a schedule the message is intended to be
+	// sent when it is.
+	unscheduled := intendedUs == 0
+	registerUs := time.Now().UnixNano() / 1000
+	if unscheduled {
+		intendedUs = registerUs
+	}
+	cn.tracker.add(seq, cn.phase, intendedUs, registerUs)
+	var err error
+	if cn.config.PingOnly {
+		err = cn.ping(conn, seq, func() int64 { return cn.sendTime(seq, unscheduled) })
+	} else {
+		cn.writeMu.Lock()
+		sendUs := cn.sendTime(seq, unscheduled)
+		if unscheduled {
+			intendedUs = sendUs
+		}
+		slots.SetIntended(message, intendedUs)
+		slots.SetSend(message, sendUs)
+		err = conn.WriteMessage(cn.codec.MessageType(), message)
+		cn.writeMu.Unlock()
+	}
+	if err != nil {
+		cn.tracker.remove(seq)
+		if cn.handleFailure(conn, err) {
+			cn.tracker.addUnsent()
+			cn.releaseSlots(1)
+			return nil
+		}
+		return err
+	}
+	cn.phaseStats[cn.phase].sent.Add(1)
+	return nil
+}
+
+// sendTime takes the send time of message seq and sets it in the in-flight
+// table, as the intended send time too if the message is unscheduled.
+func (cn *connection) sendTime(seq uint64, unscheduled bool) int64 {
+	sendUs := time.Now().UnixNano() / 1000
+	cn.tracker.setSent(seq, sendUs, unscheduled)
+	return sendUs
+}
+
+// readResponses reads responses from conn until it fails or is closed,
+// matching each one to its in-flight message by sequence number, and handles
+// the pongs read along with them. If the failure starts a reconnect, the next
+// reader takes over on the new connection; otherwise the connection is
+// finished.
+func (cn *connection) readResponses(conn *websocket.Conn) {
+	conn.SetPongHandler(cn.handlePong)
+	var resp codec.Message
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if cn.handleFailure(conn, err) {
+				return
+			}
+			log.Printf("Connection %d read error: %v", cn.id, err)
+			close(cn.done)
+			return
+		}
+
+		// Record receive time
+		recvTime := time.Now().UnixNano() / 1000
+
+		// Parse the response's test fields
+		if err := codec.DecodeTest(cn.codec, message, &resp); err != nil {
+			log.Println("Decode error:", err)
+			continue
+		}
+		if resp.Probe {
+			cn.handleProbe(&resp, recvTime)
+			continue
+		}
+		cn.handleResponse(&resp, recvTime)
+	}
+}
+
+// handleResponse matches the response to a test message, received at
+// recvUs, to its in-flight message and records its latencies.
+func (cn *connection) handleResponse(resp *codec.Message, recvUs int64) {
+	seq := resp.Sequence
+
+	// Look up the in-flight message; duplicates and unknown sequences are
+	// counted by the tracker but have no meaningful RTT
+	sent, status := cn.tracker.receive(seq)
+	if status == receiveDuplicate || status == receiveUnknown {
+		return
+	}
+	if status == receiveOnTime {
+		// Late responses already released their slot when they timed out
+		cn.releaseSlots(1)
+	}
+	// Calculate RTT from the actual send time, and corrected RTT
+	// from the intended send time
+	rtt := recvUs - sent.sendUs
+	correctedRtt := recvUs - sent.intendedUs
+
+	// Record in the phase the message was sent in, and in the overall
+	// statistics unless that was a warm-up phase. Late responses are
+	// included: excluding them would hide the tail.
+	phase := cn.phaseStats[sent.phase]
+	if cn.recorder != nil {
+		cn.record(seq, sent, resp, recvUs, status == receiveLate, phase.warmup)
+	}
+	phase.stats.AddSample(rtt)
+	phase.correctedStats.AddSample(correctedRtt)
+	if !phase.warmup {
+		cn.stats.AddSample(rtt)
+		cn.correctedStats.AddSample(correctedRtt)
+		cn.metrics.observeRTT(rtt)
+		cn.intervalStats.AddSample(rtt)
+		cn.intervalCorrected.AddSample(correctedRtt)
+		if dwell, ok := serverDwell(resp); ok {
+			cn.dwellStats.AddSample(dwell)
+			cn.intervalDwell.AddSample(dwell)
+			path := max(rtt-dwell, 0)
+			cn.pathStats.AddSample(path)
+			cn.intervalPath.AddSample(path)
+		}
+		if up, down, ok := cn.oneWay(resp, sent.sendUs, recvUs); ok {
+			cn.upStats.AddSample(up)
+			cn.intervalUp.AddSample(up)
+			cn.downStats.AddSample(down)
+			cn.intervalDown.AddSample(down)
+		}
+	}
+}
+
+// record adds the raw sample of a response to the record file.
+func (cn *connection) record(seq uint64, sent inflightMessage, resp *codec.Message, recvUs int64, late, warmup bool) {
+	var flags uint32
+	if warmup {
+		flags |= record.FlagWarmup
+	}
+	if late {
+		flags |= record.FlagLate
+	}
+	cn.recorder.Add(record.Sample{
+		Seq:          seq,
+		IntendedUs:   sent.intendedUs,
+		SendUs:       sent.sendUs,
+		ServerRecvUs: resp.ServerRecvUs,
+		ServerSendUs: resp.ServerSendUs,
+		RecvUs:       recvUs,
+		Conn:         uint32(cn.id),
+		Flags:        flags,
+	})
+}
+
+// recordLost returns the function adding the raw samples of lost messages to
+// the record file, or nil unless recording. Lost samples have no receive
+// time or server timestamps.
+func (cn *connection) recordLost() lostFunc {
+	if cn.recorder == nil {
+		return nil
+	}
+	return func(seq uint64, sent inflightMessage) {
+		flags := record.FlagLost
+		if cn.phaseStats[sent.phase].warmup {
+			flags |= record.FlagWarmup
+		}
+		cn.recorder.Add(record.Sample{
+			Seq:        seq,
+			IntendedUs: sent.intendedUs,
+			SendUs:     sent.sendUs,
+			Conn:       uint32(cn.id),
+			Flags:      flags,
+		})
+	}
+}
+
+// oneWay estimates the one-way latencies of a response from its server
+// timestamps. Without a server send timestamp, the server→client latency
+// includes the time spent in the server.
+func (cn *connection) oneWay(resp *codec.Message, sendUs, recvUs int64) (up, down int64, ok bool) {
+	if resp.ServerRecvUs <= 0 {
+		return 0, 0, false
+	}
+	serverSendUs := max(resp.ServerSendUs, resp.ServerRecvUs)
+	return cn.clock.oneWay(sendUs, resp.ServerRecvUs, serverSendUs, recvUs)
+}
+
+// serverDwell returns the time a message spent in the server, from the
+// server's receive and send timestamps. Both come from the server clock, so
+// no clock synchronization is needed. Servers that only stamp their receive
+// time report no dwell time.
+func serverDwell(resp *codec.Message) (int64, bool) {
+	if resp.ServerRecvUs <= 0 || resp.ServerSendUs < resp.ServerRecvUs {
+		return 0, false
+	}
+	return resp.ServerSendUs - resp.ServerRecvUs, true
+}
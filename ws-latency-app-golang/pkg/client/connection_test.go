@@ -0,0 +1,377 @@
+package client
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/record"
+	"ws-latency-app-golang/pkg/server"
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServerDwell(t *testing.T) {
+	tests := []struct {
+		resp   codec.Message
+		want   int64
+		wantOK bool
+	}{
+		{codec.Message{ServerRecvUs: 1000, ServerSendUs: 1250}, 250, true},
+		{codec.Message{ServerRecvUs: 1000, ServerSendUs: 1000}, 0, true},
+		{codec.Message{ServerRecvUs: 1000}, 0, false},
+		{codec.Message{}, 0, false},
+		{codec.Message{ServerRecvUs: 1000, ServerSendUs: 900}, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := serverDwell(&tt.resp); got != tt.want || ok != tt.wantOK {
+			t.Errorf("serverDwell(%+v) = %d, %v, want %d, %v", tt.resp, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDwellTimeBreakdown(t *testing.T) {
+	// The server holds every message for 5ms between its two timestamps
+	const hold = 5 * time.Millisecond
+	srv := newTestServer(t, func(conn *websocket.Conn) {
+		echo(conn, 0, func(message []byte) []byte {
+			return stampTest(message, func(test map[string]interface{}) {
+				test["server_ts_us"] = time.Now().UnixNano() / 1000
+				time.Sleep(hold)
+				test["server_send_ts_us"] = time.Now().UnixNano() / 1000
+			})
+		})
+	})
+
+	c := NewClient(Config{
+		ServerURL:    "ws" + strings.TrimPrefix(srv.URL, "http"),
+		MessageRate:  50,
+		TestDuration: 1,
+		ClosedLoop:   true,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	rtt, dwell, path := c.GetStats(), c.GetDwellStats(), c.GetPathStats()
+	if dwell.Count == 0 || dwell.Count != rtt.Count || path.Count != rtt.Count {
+		t.Fatalf("counts: RTT %d, dwell %d, path %d", rtt.Count, dwell.Count, path.Count)
+	}
+	if dwell.Min < hold.Microseconds() {
+		t.Errorf("dwell min = %dµs, want at least %dµs", dwell.Min, hold.Microseconds())
+	}
+	if path.P50 >= rtt.P50 || path.P50 > rtt.P50-hold.Microseconds() {
+		t.Errorf("path p50 = %dµs with RTT p50 %dµs and %v dwell", path.P50, rtt.P50, hold)
+	}
+}
+
+func TestRecordFile(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{}).Serve(listener)
+
+	path := filepath.Join(t.TempDir(), "samples.rec")
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/ws",
+		MessageRate:        200,
+		TestDuration:       1,
+		PrewarmCount:       20,
+		HistogramPrecision: 3,
+		Connections:        2,
+		RecordFile:         path,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := record.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s record.Sample
+	var samples, warmup, lost int64
+	conns := make(map[uint32]bool)
+	for {
+		err := r.Next(&s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples++
+		conns[s.Conn] = true
+		if s.Flags&record.FlagWarmup != 0 {
+			warmup++
+		}
+		if s.Lost() {
+			lost++
+			continue
+		}
+		if s.SendUs < s.IntendedUs || s.ServerRecvUs < s.SendUs-1000 || s.ServerSendUs < s.ServerRecvUs || s.RecvUs < s.SendUs {
+			t.Fatalf("implausible sample %+v", s)
+		}
+	}
+
+	// Every response and lost message is recorded, the warm-up ones flagged
+	counts := c.GetDeliveryCounts()
+	if samples != counts.Received+lost || lost != counts.Lost() {
+		t.Errorf("recorded %d samples with %d lost, want %+v", samples, lost, counts)
+	}
+	if warmup != 40 || samples-warmup != c.GetStats().Count {
+		t.Errorf("recorded %d warm-up and %d other samples, want 40 and %d", warmup, samples-warmup, c.GetStats().Count)
+	}
+	if len(conns) != 2 {
+		t.Errorf("samples from %d connections, want 2", len(conns))
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.NewServer(server.Config{}).Serve(listener)
+
+	for _, mc := range codec.Codecs {
+		t.Run(mc.Name(), func(t *testing.T) {
+			t.Parallel()
+			c := NewClient(Config{
+				ServerURL:          "ws://" + listener.Addr().String() + "/ws",
+				MessageRate:        200,
+				TestDuration:       1,
+				HistogramPrecision: 3,
+				PayloadSize:        512,
+				Codec:              mc.Name(),
+				ClockProbeInterval: 20 * time.Millisecond,
+			})
+			if err := c.Connect(); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			defer c.Close()
+			result, err := c.RunTest()
+			if err != nil {
+				t.Fatalf("RunTest: %v", err)
+			}
+			if d := result.Delivery; d.Sent < 150 || d.Received != d.Sent || d.Unknown != 0 {
+				t.Errorf("delivery %+v", d)
+			}
+			if result.ServerDwell == nil || result.ServerDwell.Count != result.RTT.Count {
+				t.Error("server dwell not measured for every response")
+			}
+			if len(result.ClockEstimates) != 1 || result.ClockEstimates[0].Probes == 0 {
+				t.Errorf("clock probes not answered: %+v", result.ClockEstimates)
+			}
+		})
+	}
+}
+
+func TestCodecNotSupported(t *testing.T) {
+	// A server that predates codecs agrees to no subprotocol
+	srv := newTestServer(t, func(conn *websocket.Conn) {})
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	c := NewClient(Config{ServerURL: url, Codec: "binary"})
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "does not support the binary codec") {
+		t.Errorf("Connect = %v, want a codec error", err)
+	}
+	c = NewClient(Config{ServerURL: url, Codec: "xml"})
+	if err := c.Connect(); err == nil || !strings.Contains(err.Error(), "unknown codec") {
+		t.Errorf("Connect = %v, want an unknown codec error", err)
+	}
+}
+
+// startConnection connects a connection to url and reads its responses, set
+// up as RunTest does for a single phase.
+func startConnection(t testing.TB, url string, config Config) *connection {
+	t.Helper()
+	cn := newConnection(0, config)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	cn.conn = conn
+	cn.phaseStats = []*phaseStats{newPhaseStats(Phase{Name: "test"}, config.HistogramPrecision)}
+	if err := cn.setPayloadSize(0); err != nil {
+		t.Fatal(err)
+	}
+	go cn.readResponses(conn)
+	return cn
+}
+
+// waitForResponses waits until cn has no messages in flight.
+func waitForResponses(t *testing.T, cn *connection) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for cn.tracker.outstanding() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d responses outstanding", cn.tracker.outstanding())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCorrectedRTTStalledSender(t *testing.T) {
+	// The sender starts 100ms behind its schedule, as after a stall, and
+	// catches up by sending the backlog at once
+	const stall = 100 * time.Millisecond
+	srv := newEchoServer(t)
+	cn := startConnection(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", Config{})
+	start := time.Now().Add(-stall)
+	schedule := &constantSchedule{interval: time.Millisecond}
+	cn.sendLoop(start, start.Add(stall+20*time.Millisecond), schedule, 0, nil)
+	waitForResponses(t, cn)
+
+	rtt, corrected := cn.stats.Snapshot(false), cn.correctedStats.Snapshot(false)
+	if rtt.Count < 100 || corrected.Count != rtt.Count {
+		t.Fatalf("counts: RTT %d, corrected %d", rtt.Count, corrected.Count)
+	}
+	// The first overdue message was intended to go out a full stall before
+	// it was sent, but each message's own round trip is short
+	if corrected.Max < stall.Microseconds() {
+		t.Errorf("corrected RTT max = %dµs, want at least %dµs", corrected.Max, stall.Microseconds())
+	}
+	if rtt.P99 > stall.Microseconds()/4 {
+		t.Errorf("RTT p99 = %dµs, want well below the %v stall", rtt.P99, stall)
+	}
+	if corrected.P50 <= rtt.P50 {
+		t.Errorf("corrected p50 = %dµs, not above RTT p50 %dµs", corrected.P50, rtt.P50)
+	}
+}
+
+func TestMergedStats(t *testing.T) {
+	// Merging the connections' statistics gives the statistics of all their
+	// samples together
+	c := NewClient(Config{Connections: 3, HistogramPrecision: 3})
+	all := stats.NewLatencyStats("All", 3)
+	for i, cn := range c.conns {
+		for v := int64(1); v <= 1000; v++ {
+			sample := v*int64(i+1) + 50
+			cn.stats.AddSample(sample)
+			all.AddSample(sample)
+		}
+	}
+	all.Calculate()
+	merged := c.mergeStats("RTT", func(cn *connection) *stats.LatencyStats { return cn.stats }, false)
+	if merged.Count != all.Count || merged.Min != all.Min || merged.Max != all.Max || merged.Mean != all.Mean ||
+		merged.P50 != all.P50 || merged.P90 != all.P90 || merged.P99 != all.P99 || merged.P999 != all.P999 {
+		t.Errorf("merged = %+v, want %+v", merged, all)
+	}
+
+	// After a test over several connections, the merged counts are the sums
+	// of the connections' counts
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{}).Serve(listener)
+	c = NewClient(Config{
+		ServerURL:    "ws://" + listener.Addr().String() + "/ws",
+		MessageRate:  300,
+		TestDuration: 1,
+		Connections:  3,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+	var count, corrected, received int64
+	for _, cn := range c.conns {
+		if cn.stats.Count == 0 {
+			t.Errorf("connection %d has no samples", cn.id)
+		}
+		count += cn.stats.Count
+		corrected += cn.correctedStats.Count
+		received += cn.tracker.snapshot().Received
+	}
+	if c.GetStats().Count != count || c.GetCorrectedStats().Count != corrected || c.GetDeliveryCounts().Received != received {
+		t.Errorf("merged counts %d, %d, %d received, want %d, %d, %d", c.GetStats().Count, c.GetCorrectedStats().Count,
+			c.GetDeliveryCounts().Received, count, corrected, received)
+	}
+	if count != received {
+		t.Errorf("%d samples for %d responses", count, received)
+	}
+}
+
+func TestClosedLoopInFlight(t *testing.T) {
+	// The server holds every message for 5ms before echoing it, so a client
+	// sending more than its in-flight limit would have more held at once
+	const inFlight = 4
+	var mu sync.Mutex
+	held, maxHeld := 0, 0
+	srv := newTestServer(t, func(conn *websocket.Conn) {
+		var writeMu sync.Mutex
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			held++
+			maxHeld = max(maxHeld, held)
+			mu.Unlock()
+			time.AfterFunc(5*time.Millisecond, func() {
+				mu.Lock()
+				held--
+				mu.Unlock()
+				writeMu.Lock()
+				defer writeMu.Unlock()
+				conn.WriteMessage(messageType, message)
+			})
+		}
+	})
+
+	c := NewClient(Config{
+		ServerURL:    "ws" + strings.TrimPrefix(srv.URL, "http"),
+		TestDuration: 1,
+		ClosedLoop:   true,
+		InFlight:     inFlight,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxHeld != inFlight {
+		t.Errorf("server held up to %d messages at once, want %d", maxHeld, inFlight)
+	}
+	// Each slot allows about one message per 5ms hold
+	counts := c.GetDeliveryCounts()
+	if counts.Received != counts.Sent || counts.Sent > inFlight*200+inFlight || counts.Sent < inFlight*100 {
+		t.Errorf("delivery = %+v, want about %d messages, all answered", counts, inFlight*200)
+	}
+}
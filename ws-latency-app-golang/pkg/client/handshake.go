@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// HandshakeTiming breaks down the time taken to establish one WebSocket
+// connection. Steps that did not happen, such as DNS resolution for an IP
+// address or the TLS handshake for ws:// URLs, are zero.
+type HandshakeTiming struct {
+	RemoteAddr   string        // Address the TCP connection was made to
+	DNS          time.Duration // DNS resolution
+	TCPConnect   time.Duration // TCP three-way handshake
+	TLSHandshake time.Duration // TLS handshake, for wss:// URLs
+	Upgrade      time.Duration // From sending the HTTP upgrade request to reading the 101 response
+	Total        time.Duration // From starting the dial to a usable WebSocket connection
+
+	TLSVersion     string // Negotiated TLS version, empty for ws:// URLs
+	TLSCipherSuite string // Negotiated cipher suite
+	TLSResumed     bool   // Whether the TLS session was resumed rather than fully negotiated
+}
+
+// dialWithTrace dials url with the given dialer and times each step of the
+// connection setup using HTTP request tracing. The HTTP response is returned
+// when the server answered the upgrade request, even if the dial failed. A
+// failed dial still returns the timing of the steps it started.
+func dialWithTrace(dialer *websocket.Dialer, url string) (*websocket.Conn, *http.Response, HandshakeTiming, error) {
+	var timing HandshakeTiming
+	var dnsStart, connectStart, tlsStart, upgradeStart time.Time
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				timing.DNS = time.Since(dnsStart)
+			}
+		},
+		ConnectStart: func(network, addr string) { connectStart = time.Now() },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil && !connectStart.IsZero() {
+				timing.TCPConnect = time.Since(connectStart)
+				timing.RemoteAddr = addr
+			}
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			// For ws:// the upgrade request follows the TCP connection directly
+			upgradeStart = time.Now()
+		},
+		TLSHandshakeStart: func() {
+			// For wss:// the upgrade request follows the TLS handshake
+			tlsStart = time.Now()
+			upgradeStart = time.Time{}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			timing.TLSHandshake = time.Since(tlsStart)
+			if err == nil {
+				upgradeStart = time.Now()
+				timing.TLSVersion = tls.VersionName(state.Version)
+				timing.TLSCipherSuite = tls.CipherSuiteName(state.CipherSuite)
+				timing.TLSResumed = state.DidResume
+			}
+		},
+	}
+
+	start := time.Now()
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	conn, resp, err := dialer.DialContext(ctx, url, nil)
+
+	// On failure, the steps reached so far are still timed up to the error
+	end := time.Now()
+	timing.Total = end.Sub(start)
+	if !upgradeStart.IsZero() {
+		timing.Upgrade = end.Sub(upgradeStart)
+	}
+	if err != nil {
+		return nil, resp, timing, err
+	}
+	if timing.RemoteAddr == "" {
+		timing.RemoteAddr = conn.RemoteAddr().String()
+	}
+	return conn, resp, timing, nil
+}
+
+// tlsSummary describes the negotiated TLS session, or returns an empty string
+// for ws:// connections.
+func (t HandshakeTiming) tlsSummary() string {
+	if t.TLSVersion == "" {
+		return ""
+	}
+	mode := "full handshake"
+	if t.TLSResumed {
+		mode = "resumed"
+	}
+	return fmt.Sprintf(" (%s %s, %s)", t.TLSVersion, t.TLSCipherSuite, mode)
+}
+
+// handshakeStats accumulates connection setup timing per step. Full and
+// resumed TLS handshakes are kept apart, as their cost differs widely.
+type handshakeStats struct {
+	dns, tcp, tlsFull, tlsResumed, upgrade, total *stats.LatencyStats
+}
+
+// newHandshakeStats creates empty connection setup statistics.
+func newHandshakeStats(precision int) *handshakeStats {
+	return &handshakeStats{
+		dns:        stats.NewLatencyStats("DNS", precision),
+		tcp:        stats.NewLatencyStats("TCP", precision),
+		tlsFull:    stats.NewLatencyStats("TLS full", precision),
+		tlsResumed: stats.NewLatencyStats("TLS resumed", precision),
+		upgrade:    stats.NewLatencyStats("Upgrade", precision),
+		total:      stats.NewLatencyStats("Total", precision),
+	}
+}
+
+// add records the timing of one connection.
+func (h *handshakeStats) add(t HandshakeTiming) {
+	h.dns.AddSample(t.DNS.Microseconds())
+	h.tcp.AddSample(t.TCPConnect.Microseconds())
+	if t.TLSResumed {
+		h.tlsResumed.AddSample(t.TLSHandshake.Microseconds())
+	} else if t.TLSVersion != "" {
+		h.tlsFull.AddSample(t.TLSHandshake.Microseconds())
+	}
+	h.upgrade.AddSample(t.Upgrade.Microseconds())
+	h.total.AddSample(t.Total.Microseconds())
+}
+
+// print prints one summary line per step. TLS lines are left out when no
+// handshake of that kind happened.
+func (h *handshakeStats) print() {
+	for _, s := range []*stats.LatencyStats{h.dns, h.tcp, h.tlsFull, h.tlsResumed, h.upgrade, h.total} {
+		s.Calculate()
+		if s.Count == 0 && (s == h.tlsFull || s == h.tlsResumed) {
+			continue
+		}
+		log.Printf("%-12s count=%d min=%d p50=%d p90=%d p99=%d max=%d mean=%.1f\n",
+			s.Name, s.Count, s.Min, s.P50, s.P90, s.P99, s.Max, s.Mean)
+	}
+}
+
+// printHandshakeResults prints the connection setup timing. A single
+// connection is printed as one line; several are summarized per step.
+func printHandshakeResults(timings []HandshakeTiming, precision int) {
+	if len(timings) == 0 {
+		return
+	}
+	log.Println("===== Connection Setup (µs) =====")
+	if len(timings) == 1 {
+		t := timings[0]
+		log.Printf("Remote %s: DNS=%d TCP=%d TLS=%d Upgrade=%d Total=%d%s\n", t.RemoteAddr,
+			t.DNS.Microseconds(), t.TCPConnect.Microseconds(), t.TLSHandshake.Microseconds(),
+			t.Upgrade.Microseconds(), t.Total.Microseconds(), t.tlsSummary())
+		return
+	}
+
+	h := newHandshakeStats(precision)
+	for _, t := range timings {
+		h.add(t)
+	}
+	h.print()
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDialWithTrace(t *testing.T) {
+	handler := testHandler(func(conn *websocket.Conn) {})
+
+	for _, secure := range []bool{false, true} {
+		var srv *httptest.Server
+		if secure {
+			srv = httptest.NewTLSServer(handler)
+		} else {
+			srv = httptest.NewServer(handler)
+		}
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+
+		conn, _, timing, err := dialWithTrace(dialer, url)
+		if err != nil {
+			srv.Close()
+			t.Fatalf("dial %s: %v", url, err)
+		}
+		conn.Close()
+		addr := srv.Listener.Addr().String()
+		srv.Close()
+
+		if timing.DNS != 0 {
+			t.Errorf("%s: DNS = %v for an IP address, want 0", url, timing.DNS)
+		}
+		if timing.TCPConnect <= 0 || timing.Upgrade <= 0 {
+			t.Errorf("%s: TCP = %v, upgrade = %v, want both positive", url, timing.TCPConnect, timing.Upgrade)
+		}
+		if secure != (timing.TLSHandshake > 0) {
+			t.Errorf("%s: TLS handshake = %v", url, timing.TLSHandshake)
+		}
+		if sum := timing.DNS + timing.TCPConnect + timing.TLSHandshake + timing.Upgrade; sum > timing.Total {
+			t.Errorf("%s: steps sum to %v, more than total %v", url, sum, timing.Total)
+		}
+		if timing.RemoteAddr != addr {
+			t.Errorf("%s: remote address %q", url, timing.RemoteAddr)
+		}
+	}
+}
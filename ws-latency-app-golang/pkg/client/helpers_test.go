@@ -0,0 +1,72 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newEchoServer starts a WebSocket server that echoes every message at /ws.
+// Other paths are not found.
+func newEchoServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", testHandler(func(conn *websocket.Conn) {
+		echo(conn, 0, nil)
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestServer starts a WebSocket server that runs handle on every
+// connection, at any path.
+func newTestServer(t testing.TB, handle func(conn *websocket.Conn)) *httptest.Server {
+	srv := httptest.NewServer(testHandler(handle))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testHandler upgrades every request to a WebSocket connection, runs handle
+// on it and closes it when handle returns.
+func testHandler(handle func(conn *websocket.Conn)) http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	})
+}
+
+// echo sends every message read from conn back, changed by rewrite if it is
+// set, until conn fails or, if limit is positive, limit messages have been
+// echoed.
+func echo(conn *websocket.Conn, limit int, rewrite func(message []byte) []byte) {
+	for n := 0; limit <= 0 || n < limit; n++ {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if rewrite != nil {
+			message = rewrite(message)
+		}
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return
+		}
+	}
+}
+
+// stampTest returns a JSON message with its _test object changed by stamp,
+// as a server stamping its times would.
+func stampTest(message []byte, stamp func(test map[string]interface{})) []byte {
+	var data map[string]interface{}
+	json.Unmarshal(message, &data)
+	stamp(data["_test"].(map[string]interface{}))
+	response, _ := json.Marshal(data)
+	return response
+}
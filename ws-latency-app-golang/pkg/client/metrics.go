@@ -0,0 +1,180 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// clientMetrics are the Prometheus metrics served with Config.MetricsPort.
+// Names follow the Java implementation's MetricsManager (ws_latency_seconds,
+// ws_events_sent_total, ws_events_received_total, ws_errors_total and the
+// ws_latency_* summary gauges in microseconds), so dashboards work for either.
+// Methods do nothing on a nil receiver, for connections created outside a
+// Client.
+type clientMetrics struct {
+	registry   *prometheus.Registry
+	rtt        prometheus.Observer
+	push       prometheus.Observer
+	pushEvents atomic.Int64 // Pushed events received in receive mode
+	errors     prometheus.Counter
+	reconnects prometheus.Counter
+	rate       prometheus.Gauge
+
+	// Latency summary of the last report interval, in microseconds
+	min, max, p50, p90, p99, mean prometheus.Gauge
+}
+
+// newClientMetrics creates the metrics of c in a registry of their own.
+// Delivery counts are read from c's connections when scraped.
+func newClientMetrics(c *Client) *clientMetrics {
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ws_latency_seconds",
+		Help:    "WebSocket latency: round_trip for responses, server_to_client for pushed events",
+		Buckets: prometheus.ExponentialBuckets(10e-6, 2, 18), // 10µs to about 1.3s
+	}, []string{"type"})
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: help})
+	}
+	m := &clientMetrics{
+		registry: prometheus.NewRegistry(),
+		rtt:      latency.WithLabelValues("round_trip"),
+		push:     latency.WithLabelValues("server_to_client"),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ws_errors_total",
+			Help: "Connection failures: read or write errors that took a connection down",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ws_reconnects_total",
+			Help: "Successful reconnects after connection failures",
+		}),
+		rate: gauge("ws_send_rate", "Target send rate of the current phase in messages per second; 0 in closed-loop and receive mode"),
+		min:  gauge("ws_latency_min", "Minimum latency of the last report interval in microseconds"),
+		max:  gauge("ws_latency_max", "Maximum latency of the last report interval in microseconds"),
+		p50:  gauge("ws_latency_p50", "P50 (median) latency of the last report interval in microseconds"),
+		p90:  gauge("ws_latency_p90", "P90 latency of the last report interval in microseconds"),
+		p99:  gauge("ws_latency_p99", "P99 latency of the last report interval in microseconds"),
+		mean: gauge("ws_latency_mean", "Mean latency of the last report interval in microseconds"),
+	}
+	m.registry.MustRegister(latency, m.errors, m.reconnects, m.rate,
+		m.min, m.max, m.p50, m.p90, m.p99, m.mean,
+		&deliveryCollector{client: c},
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// observeRTT records a round-trip time in microseconds.
+func (m *clientMetrics) observeRTT(us int64) {
+	if m != nil {
+		m.rtt.Observe(float64(us) / 1e6)
+	}
+}
+
+// countPush counts a pushed event.
+func (m *clientMetrics) countPush() {
+	if m != nil {
+		m.pushEvents.Add(1)
+	}
+}
+
+// observePush records the latency of a pushed event in microseconds.
+func (m *clientMetrics) observePush(us int64) {
+	if m != nil {
+		m.push.Observe(float64(us) / 1e6)
+	}
+}
+
+// failed counts a connection failure.
+func (m *clientMetrics) failed() {
+	if m != nil {
+		m.errors.Inc()
+	}
+}
+
+// reconnected counts a successful reconnect.
+func (m *clientMetrics) reconnected() {
+	if m != nil {
+		m.reconnects.Inc()
+	}
+}
+
+// setRate sets the target send rate.
+func (m *clientMetrics) setRate(rate int) {
+	if m != nil {
+		m.rate.Set(float64(rate))
+	}
+}
+
+// update sets the latency summary gauges from the statistics of a report
+// interval, which must have been calculated. Intervals without samples leave
+// them unchanged.
+func (m *clientMetrics) update(s *stats.LatencyStats) {
+	if m == nil || s.Count == 0 {
+		return
+	}
+	m.min.Set(float64(s.Min))
+	m.max.Set(float64(s.Max))
+	m.p50.Set(float64(s.P50))
+	m.p90.Set(float64(s.P90))
+	m.p99.Set(float64(s.P99))
+	m.mean.Set(s.Mean)
+}
+
+// deliveryCollector reports the client's delivery counts when scraped, so the
+// counters match the printed results.
+type deliveryCollector struct {
+	client *Client
+}
+
+var (
+	sentDesc     = prometheus.NewDesc("ws_events_sent_total", "Messages sent", nil, nil)
+	receivedDesc = prometheus.NewDesc("ws_events_received_total", "Responses received, and pushed events in receive mode", nil, nil)
+	timedOutDesc = prometheus.NewDesc("ws_events_timed_out_total", "Messages that got no response within the response timeout, including ones answered late", nil, nil)
+	lateDesc     = prometheus.NewDesc("ws_events_late_total", "Responses that arrived after their message had timed out", nil, nil)
+)
+
+// Describe implements prometheus.Collector.
+func (d *deliveryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sentDesc
+	ch <- receivedDesc
+	ch <- timedOutDesc
+	ch <- lateDesc
+}
+
+// Collect implements prometheus.Collector. The loss count of the results
+// goes down when a late response arrives, so it is not a counter; it is
+// timed out minus late.
+func (d *deliveryCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := d.client.deliveryCounts()
+	received := counts.Received + d.client.metrics.pushEvents.Load()
+	ch <- prometheus.MustNewConstMetric(sentDesc, prometheus.CounterValue, float64(counts.Sent))
+	ch <- prometheus.MustNewConstMetric(receivedDesc, prometheus.CounterValue, float64(received))
+	ch <- prometheus.MustNewConstMetric(timedOutDesc, prometheus.CounterValue, float64(counts.TimedOut))
+	ch <- prometheus.MustNewConstMetric(lateDesc, prometheus.CounterValue, float64(counts.Late))
+}
+
+// StartMetrics starts serving Prometheus metrics at /metrics on MetricsPort
+// in the background. It returns once the port is open.
+func (c *Client) StartMetrics() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", c.config.MetricsPort))
+	if err != nil {
+		return fmt.Errorf("failed to start metrics listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(c.metrics.registry, promhttp.HandlerOpts{}))
+	log.Printf("Metrics available at: http://localhost:%d/metrics\n", listener.Addr().(*net.TCPAddr).Port)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Println("Metrics server error:", err)
+		}
+	}()
+	return nil
+}
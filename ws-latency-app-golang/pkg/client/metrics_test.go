@@ -0,0 +1,84 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ws-latency-app-golang/pkg/server"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestClientMetrics(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{}).Serve(listener)
+
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/ws",
+		MessageRate:        200,
+		TestDuration:       1,
+		PrewarmCount:       20,
+		HistogramPrecision: 3,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	promhttp.HandlerFor(c.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	metrics := recorder.Body.String()
+
+	counts := c.GetDeliveryCounts()
+	for _, want := range []string{
+		fmt.Sprintf("ws_events_sent_total %d", counts.Sent),
+		fmt.Sprintf("ws_events_received_total %d", counts.Received),
+		"ws_events_timed_out_total 0",
+		"ws_events_late_total 0",
+		fmt.Sprintf(`ws_latency_seconds_count{type="round_trip"} %d`, c.GetStats().Count),
+		"ws_send_rate 200",
+		"ws_reconnects_total 0",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %s:\n%s", want, metrics)
+		}
+	}
+	if counts.Sent < 150 {
+		t.Errorf("sent = %d, want about 200", counts.Sent)
+	}
+}
+
+func TestLossCounters(t *testing.T) {
+	c := NewClient(Config{})
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		promhttp.HandlerFor(c.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		return recorder.Body.String()
+	}
+
+	tracker := c.conns[0].tracker
+	tracker.add(1, 0, 1000, 1000)
+	tracker.expire(10_000, 1000, nil)
+	if metrics := scrape(); !strings.Contains(metrics, "ws_events_timed_out_total 1") || !strings.Contains(metrics, "ws_events_late_total 0") {
+		t.Fatalf("counters after a timeout:\n%s", metrics)
+	}
+	// The late response takes the message off the loss count, which the
+	// counters give as timed out minus late
+	tracker.receive(1)
+	if c.GetDeliveryCounts().Lost() != 0 {
+		t.Fatalf("delivery counts %+v", c.GetDeliveryCounts())
+	}
+	if metrics := scrape(); !strings.Contains(metrics, "ws_events_timed_out_total 1") || !strings.Contains(metrics, "ws_events_late_total 1") {
+		t.Errorf("counters after the late response:\n%s", metrics)
+	}
+}
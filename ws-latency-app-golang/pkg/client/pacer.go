@@ -0,0 +1,49 @@
+package client
+
+import (
+	"runtime"
+	"time"
+)
+
+// pacer waits for the intended send times of a connection. A timer alone
+// wakes up tens of microseconds late or more, which is most of the interval
+// at high rates, so the pacer sleeps until spin before each send time and
+// spins for the rest, yielding to other goroutines while it does.
+type pacer struct {
+	spin  time.Duration
+	timer *time.Timer
+}
+
+// newPacer creates a pacer that spins for the last spin of every wait; 0
+// only sleeps.
+func newPacer(spin time.Duration) *pacer {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	return &pacer{spin: spin, timer: timer}
+}
+
+// wait blocks until t, returning immediately if t has passed. It returns
+// false if stop is closed first.
+func (p *pacer) wait(t time.Time, stop <-chan struct{}) bool {
+	if sleep := time.Until(t) - p.spin; sleep > 0 {
+		p.timer.Reset(sleep)
+		select {
+		case <-stop:
+			if !p.timer.Stop() {
+				<-p.timer.C
+			}
+			return false
+		case <-p.timer.C:
+		}
+	} else {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+	}
+	for time.Now().Before(t) {
+		runtime.Gosched()
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPacer(t *testing.T) {
+	p := newPacer(100 * time.Microsecond)
+	stop := make(chan struct{})
+	for _, d := range []time.Duration{-time.Millisecond, 50 * time.Microsecond, 2 * time.Millisecond} {
+		at := time.Now().Add(d)
+		if !p.wait(at, stop) {
+			t.Fatal("wait stopped")
+		}
+		if time.Now().Before(at) {
+			t.Errorf("wait for %v returned early", d)
+		}
+	}
+
+	close(stop)
+	start := time.Now()
+	if p.wait(start.Add(time.Hour), stop) {
+		t.Error("wait did not stop")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("stopped wait took too long")
+	}
+	// A stopped timer is reusable
+	if !p.wait(time.Now().Add(time.Millisecond), make(chan struct{})) {
+		t.Error("wait after stop failed")
+	}
+}
+
+// BenchmarkPacer reports how late the pacer wakes up for constant rates,
+// sleeping only and with spinning. Each iteration is one send time.
+func BenchmarkPacer(b *testing.B) {
+	for _, rate := range []int{10_000, 50_000, 100_000} {
+		for _, spin := range []time.Duration{0, 100 * time.Microsecond} {
+			b.Run(fmt.Sprintf("rate=%d/spin=%v", rate, spin), func(b *testing.B) {
+				interval := time.Second / time.Duration(rate)
+				p := newPacer(spin)
+				stop := make(chan struct{})
+				lateness := make([]time.Duration, b.N)
+				start := time.Now()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					at := start.Add(time.Duration(i+1) * interval)
+					p.wait(at, stop)
+					lateness[i] = time.Since(at)
+				}
+				b.StopTimer()
+				sort.Slice(lateness, func(i, j int) bool { return lateness[i] < lateness[j] })
+				b.ReportMetric(float64(lateness[len(lateness)/2].Nanoseconds())/1000, "p50-late-µs")
+				b.ReportMetric(float64(lateness[len(lateness)*99/100].Nanoseconds())/1000, "p99-late-µs")
+			})
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+
+	"ws-latency-app-golang/pkg/codec"
+)
+
+// Payload ring limits
+const (
+	payloadRingFrames = 64      // Pre-rendered messages per connection
+	payloadRingBytes  = 4 << 20 // Upper bound of their total size
+)
+
+// payloadRing holds pre-rendered messages, each with its own random ticker
+// or template values. They are sent in turn with only the sequence number and client
+// timestamps written in place, so sending neither formats nor allocates.
+type payloadRing struct {
+	frames [][]byte
+	slots  []codec.Slots
+	next   int
+}
+
+// take returns the next message of the ring and where to stamp it. The
+// message may be written to until the next call.
+func (r *payloadRing) take() ([]byte, codec.Slots) {
+	i := r.next
+	r.next++
+	if r.next == len(r.frames) {
+		r.next = 0
+	}
+	return r.frames[i], r.slots[i]
+}
+
+// size returns the average length of the ring's messages.
+func (r *payloadRing) size() int {
+	total := 0
+	for _, frame := range r.frames {
+		total += len(frame)
+	}
+	return total / len(r.frames)
+}
+
+// setPayloadSize pads the message so it encodes to about size bytes and
+// renders the connection's payload ring from it. Sizes below the message
+// size drop the ticker or template, and sizes below what the codec encodes
+// for the test fields alone send them unpadded.
+func (cn *connection) setPayloadSize(size int) error {
+	cn.msg.Pad = nil
+	cn.bare = false
+	cn.randomize()
+	if size > 0 {
+		message, err := cn.codec.Append(nil, &cn.msg)
+		if err != nil {
+			return err
+		}
+		if len(message) > size {
+			cn.bare = true
+			cn.randomize()
+		}
+	}
+	// The second pass corrects for the pad's own framing, such as a length
+	// prefix that grows with it
+	for i := 0; i < 2 && size > 0; i++ {
+		message, err := cn.codec.Append(nil, &cn.msg)
+		if err != nil {
+			return err
+		}
+		n := size - len(message) + len(cn.msg.Pad)
+		if n <= 0 && len(cn.msg.Pad) == 0 {
+			break
+		}
+		cn.msg.Pad = bytes.Repeat([]byte("x"), max(n, 0))
+	}
+	return cn.renderPayloads()
+}
+
+// randomize gives the message new content: random ticker values, a new
+// rendering of the payload template, or nothing for bare messages.
+func (cn *connection) randomize() {
+	switch {
+	case cn.bare:
+		cn.msg.Ticker = codec.Ticker{}
+		cn.msg.Body = nil
+	case cn.template != nil:
+		cn.msg.Ticker = codec.Ticker{}
+		cn.msg.Body = cn.template.render(cn.msg.Body[:0])
+	default:
+		cn.msg.Ticker = codec.DefaultTicker()
+		cn.randomizeMessage()
+	}
+}
+
+// renderPayloads fills the payload ring with messages of random content, as
+// many as fit in payloadRingBytes.
+func (cn *connection) renderPayloads() error {
+	ring := &payloadRing{}
+	for i := 0; i < payloadRingFrames; i++ {
+		cn.randomize()
+		frame, slots, err := cn.codec.AppendTemplate(nil, &cn.msg)
+		if err != nil {
+			return err
+		}
+		if i > 0 && (i+1)*len(frame) > payloadRingBytes {
+			break
+		}
+		ring.frames = append(ring.frames, frame)
+		ring.slots = append(ring.slots, slots)
+	}
+	cn.payloads = ring
+	return nil
+}
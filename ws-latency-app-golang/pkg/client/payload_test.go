@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSetPayloadSize(t *testing.T) {
+	for _, c := range codec.Codecs {
+		cn := newConnection(0, Config{Codec: c.Name()})
+		for _, size := range []int{1024, 4096} {
+			if err := cn.setPayloadSize(size); err != nil {
+				t.Fatal(err)
+			}
+			message, err := c.Append(nil, &cn.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := len(message) - size; diff < -16 || diff > 16 {
+				t.Errorf("%s: payload size %d: message is %d bytes", c.Name(), size, len(message))
+			}
+			if len(cn.payloads.frames) != payloadRingFrames {
+				t.Errorf("%s: payload size %d: ring of %d messages", c.Name(), size, len(cn.payloads.frames))
+			}
+		}
+		cn.setPayloadSize(0)
+		if len(cn.msg.Pad) != 0 {
+			t.Errorf("%s: padding not removed", c.Name())
+		}
+	}
+
+	// Sizes below the ticker message send the test fields alone, as small
+	// as the codec allows
+	for _, c := range codec.Codecs {
+		cn := newConnection(0, Config{Codec: c.Name()})
+		cn.setPayloadSize(MinPayloadSize)
+		bare, _ := c.Append(nil, &codec.Message{})
+		if cn.payloads.size() != len(bare) {
+			t.Errorf("%s: payload size %d: %d B messages, want %d B", c.Name(), MinPayloadSize, cn.payloads.size(), len(bare))
+		}
+		cn.setPayloadSize(len(bare) + 64)
+		if cn.payloads.size() != len(bare)+64 {
+			t.Errorf("%s: payload size %d: %d B messages", c.Name(), len(bare)+64, cn.payloads.size())
+		}
+		// The ticker comes back for larger sizes
+		cn.setPayloadSize(4096)
+		if cn.bare || (c != codec.Binary && cn.msg.Ticker.InstID == "") {
+			t.Errorf("%s: ticker not restored", c.Name())
+		}
+	}
+
+	// Large payloads shrink the ring to its byte limit
+	cn := newConnection(0, Config{})
+	cn.setPayloadSize(payloadRingBytes / 3)
+	if n := len(cn.payloads.frames); n != 3 {
+		t.Errorf("ring of %d messages of %d bytes, want 3", n, payloadRingBytes/3)
+	}
+}
+
+func TestPayloadRing(t *testing.T) {
+	for _, c := range codec.Codecs {
+		cn := newConnection(0, Config{Codec: c.Name()})
+		if err := cn.setPayloadSize(1024); err != nil {
+			t.Fatal(err)
+		}
+
+		// Stamping the next message of the ring does not allocate
+		allocs := testing.AllocsPerRun(100, func() {
+			message, slots := cn.payloads.take()
+			slots.SetSequence(message, 7)
+			slots.SetIntended(message, 1_747_721_466_604_000)
+			slots.SetSend(message, 1_747_721_466_604_012)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: stamping a message allocates %.0f times", c.Name(), allocs)
+		}
+
+		message, slots := cn.payloads.take()
+		slots.SetSequence(message, 7)
+		slots.SetIntended(message, 1_747_721_466_604_000)
+		slots.SetSend(message, 1_747_721_466_604_012)
+		var got codec.Message
+		if err := c.Decode(message, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Sequence != 7 || got.ClientIntendedUs != 1_747_721_466_604_000 || got.ClientSendUs != 1_747_721_466_604_012 {
+			t.Errorf("%s: stamped message decoded as %+v", c.Name(), got)
+		}
+
+		// The messages of the ring carry different ticker values
+		if c != codec.Binary && bytes.Equal(cn.payloads.frames[0], cn.payloads.frames[1]) {
+			t.Errorf("%s: ring messages are identical", c.Name())
+		}
+	}
+}
+
+// BenchmarkSendPath compares encoding every message, as the sender used to,
+// with sending pre-rendered ones through sendMessage to a server that
+// discards them.
+func BenchmarkSendPath(b *testing.B) {
+	srv := newTestServer(b, func(conn *websocket.Conn) {
+		for {
+			_, r, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, r)
+		}
+	})
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for _, c := range codec.Codecs {
+		cn := newConnection(0, Config{Codec: c.Name()})
+		cn.setPayloadSize(0)
+		b.Run(c.Name()+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			var buf []byte
+			for i := 0; i < b.N; i++ {
+				cn.randomizeMessage()
+				cn.msg.Sequence = uint64(i)
+				cn.msg.ClientSendUs = time.Now().UnixNano() / 1000
+				cn.msg.ClientIntendedUs = cn.msg.ClientSendUs
+				buf, _ = c.Append(buf[:0], &cn.msg)
+			}
+		})
+		b.Run(c.Name()+"/send", func(b *testing.B) {
+			cn := startConnection(b, url, Config{Codec: c.Name()})
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := cn.sendMessage(0); err != nil {
+					b.Fatal(err)
+				}
+				// No responses come back, so keep the in-flight table from
+				// growing without bound
+				cn.tracker.remove(uint64(i))
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingPayloadSize is the size of a ping's application data: the sequence
+// number and the client send time, both 8-byte little-endian integers. Pongs
+// echo it unchanged.
+const pingPayloadSize = 16
+
+// runPings sends a WebSocket ping every PingInterval until done is closed,
+// interleaved with the test messages. Pings are skipped while the connection
+// is down.
+func (cn *connection) runPings(done <-chan struct{}) {
+	ticker := time.NewTicker(cn.config.PingInterval)
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		conn := cn.currentConn()
+		if conn == nil {
+			continue
+		}
+
+		if err := cn.ping(conn, seq, func() int64 { return time.Now().UnixNano() / 1000 }); err != nil {
+			if !cn.handleFailure(conn, err) {
+				return
+			}
+			continue
+		}
+		seq++
+		cn.pingsSent.Add(1)
+	}
+}
+
+// ping sends a ping carrying seq and the send time returned by sendTime. The
+// send time is taken once the write lock is held, as for messages, so a ping
+// does not count waiting for a message being written in its round trip.
+func (cn *connection) ping(conn *websocket.Conn, seq uint64, sendTime func() int64) error {
+	cn.writeMu.Lock()
+	defer cn.writeMu.Unlock()
+	var payload [pingPayloadSize]byte
+	binary.LittleEndian.PutUint64(payload[0:], seq)
+	binary.LittleEndian.PutUint64(payload[8:], uint64(sendTime()))
+	return conn.WriteControl(websocket.PingMessage, payload[:], time.Now().Add(cn.config.ResponseTimeout))
+}
+
+// handlePong records the round trip of a pong answering one of our pings. It
+// runs on the reader goroutine. In ping-only mode pongs are the responses to
+// the test messages; otherwise they go into the ping statistics, unless sent
+// in a warm-up phase. Pongs with other payloads, such as unsolicited ones,
+// are ignored.
+func (cn *connection) handlePong(appData string) error {
+	recvUs := time.Now().UnixNano() / 1000
+	if len(appData) != pingPayloadSize {
+		return nil
+	}
+	seq := leUint64(appData[0:])
+	sendUs := int64(leUint64(appData[8:]))
+
+	if cn.config.PingOnly {
+		cn.handleResponse(&codec.Message{Sequence: seq, ClientSendUs: sendUs}, recvUs)
+		return nil
+	}
+	cn.pongsReceived.Add(1)
+	if !cn.warmup.Load() {
+		rtt := recvUs - sendUs
+		cn.pingStats.AddSample(rtt)
+		cn.intervalPing.AddSample(rtt)
+	}
+	return nil
+}
+
+// leUint64 decodes a little-endian integer from the first 8 bytes of s
+// without converting it to a byte slice.
+func leUint64(s string) uint64 {
+	var v uint64
+	for i := 7; i >= 0; i-- {
+		v = v<<8 | uint64(s[i])
+	}
+	return v
+}
+
+// printPingResults compares application-level RTT with ping RTT. The
+// difference is the cost of encoding, decoding and handling messages in the
+// client and server over that of the protocol stack alone.
+func printPingResults(rtt, ping *stats.LatencyStats, sent, received int64) {
+	log.Println("===== Message vs Ping RTT (µs) =====")
+	log.Printf("%-10s %8s %8s %8s %8s %8s %8s %8s\n", "", "Count", "P50", "P90", "P99", "P99.9", "Max", "Mean")
+	log.Printf("%-10s %8d %8d %8d %8d %8d %8d %8.1f\n", "Message", rtt.Count, rtt.P50, rtt.P90, rtt.P99, rtt.P999, rtt.Max, rtt.Mean)
+	log.Printf("%-10s %8d %8d %8d %8d %8d %8d %8.1f\n", "Ping", ping.Count, ping.P50, ping.P90, ping.P99, ping.P999, ping.Max, ping.Mean)
+	log.Printf("%-10s %8s %8d %8d %8d %8d %8d %8.1f\n", "Difference", "",
+		rtt.P50-ping.P50, rtt.P90-ping.P90, rtt.P99-ping.P99, rtt.P999-ping.P999, rtt.Max-ping.Max, rtt.Mean-ping.Mean)
+	log.Printf("Pings sent: %d, pongs received: %d\n", sent, received)
+}
+
+// printPingReport prints a one-line summary of ping RTT and its difference
+// from message RTT.
+func printPingReport(label string, period time.Duration, rtt, ping *stats.LatencyStats) {
+	log.Printf("[%s %s] Ping RTT count=%d p50=%d p99=%d p99.9=%d max=%d | Message-ping p50=%d p99=%d (µs)\n",
+		label, period.Round(time.Second),
+		ping.Count, ping.P50, ping.P99, ping.P999, ping.Max,
+		rtt.P50-ping.P50, rtt.P99-ping.P99)
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/server"
+)
+
+func TestPings(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{}).Serve(listener)
+
+	for _, pingOnly := range []bool{false, true} {
+		config := Config{
+			ServerURL:       "ws://" + listener.Addr().String() + "/ws",
+			MessageRate:     200,
+			TestDuration:    1,
+			ResponseTimeout: time.Second,
+		}
+		if pingOnly {
+			// Clock probes would be messages, so they are not sent
+			config.PingOnly = true
+			config.ClockProbeInterval = 10 * time.Millisecond
+		} else {
+			config.PingInterval = 10 * time.Millisecond
+		}
+		c := NewClient(config)
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		result, err := c.RunTest()
+		c.Close()
+		if err != nil {
+			t.Fatalf("RunTest: %v", err)
+		}
+
+		counts := c.GetDeliveryCounts()
+		if counts.Sent < 150 || counts.Received != counts.Sent {
+			t.Errorf("ping-only=%v: delivery = %+v, want about 200 sent and all received", pingOnly, counts)
+		}
+		if c.GetStats().Count != counts.Received {
+			t.Errorf("ping-only=%v: RTT count = %d, want %d", pingOnly, c.GetStats().Count, counts.Received)
+		}
+		if pingOnly {
+			// Pings are the messages, so there are no separate ping statistics
+			if result.PingRTT != nil || result.Pings != nil {
+				t.Errorf("ping-only result has ping RTT %+v and counts %+v", result.PingRTT, result.Pings)
+			}
+			if result.ClockEstimates != nil || c.conns[0].clock.probes != 0 {
+				t.Errorf("ping-only run sent clock probes: %+v", result.ClockEstimates)
+			}
+			continue
+		}
+		ping := c.GetPingStats()
+		if result.Pings == nil || result.Pings.Sent < 50 || result.Pings.Received != result.Pings.Sent {
+			t.Errorf("pings = %+v, want about 100 sent and all answered", result.Pings)
+		}
+		if ping.Count == 0 || result.PingRTT == nil || result.PingRTT.Count != ping.Count {
+			t.Errorf("ping RTT count = %d, result %+v", ping.Count, result.PingRTT)
+		}
+		if ping.P50 <= 0 || ping.P50 > 10_000 {
+			t.Errorf("ping RTT p50 = %dµs, want a loopback latency", ping.P50)
+		}
+	}
+}
+
+func TestPingWaitsForWriteLock(t *testing.T) {
+	srv := newEchoServer(t)
+	cn := startConnection(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", Config{ResponseTimeout: time.Second})
+
+	// A message is being written: the send time must be taken after it
+	cn.writeMu.Lock()
+	stamped := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- cn.ping(cn.conn, 0, func() int64 {
+			close(stamped)
+			return time.Now().UnixNano() / 1000
+		})
+	}()
+	select {
+	case <-stamped:
+		t.Fatal("ping took its send time while the write lock was held")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cn.writeMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+}
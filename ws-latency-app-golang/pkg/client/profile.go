@@ -0,0 +1,308 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ws-latency-app-golang/pkg/stats"
+)
+
+// Payload size limits of a phase
+const (
+	MinPayloadSize = 16
+	MaxPayloadSize = 1 << 20
+
+	// Bytes a message may differ from the payload size by, for the framing
+	// of the padding
+	payloadSizeSlack = 16
+)
+
+// Phase is one step of a load profile. Zero-valued rate, payload size and
+// connection count inherit the client configuration.
+type Phase struct {
+	Name        string        `json:"name"`
+	Rate        int           `json:"rate"`
+	Duration    time.Duration `json:"-"`
+	Messages    int           `json:"messages"` // Per connection; 0 limits the phase by duration only
+	PayloadSize int           `json:"payload_size"`
+	Connections int           `json:"connections"`
+	Warmup      bool          `json:"warmup"` // Excluded from the overall results
+}
+
+// UnmarshalJSON decodes a phase, accepting the duration as a Go duration
+// string such as "30s" or "1h".
+func (p *Phase) UnmarshalJSON(data []byte) error {
+	type plainPhase Phase
+	aux := struct {
+		*plainPhase
+		Duration string `json:"duration"`
+	}{plainPhase: (*plainPhase)(p)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if aux.Duration != "" {
+		d, err := time.ParseDuration(aux.Duration)
+		if err != nil {
+			return fmt.Errorf("phase %q: %w", p.Name, err)
+		}
+		p.Duration = d
+	}
+	return nil
+}
+
+// MarshalJSON encodes a phase with the duration as a Go duration string.
+func (p Phase) MarshalJSON() ([]byte, error) {
+	type plainPhase Phase
+	return json.Marshal(struct {
+		plainPhase
+		Duration string `json:"duration"`
+	}{plainPhase: plainPhase(p), Duration: p.Duration.String()})
+}
+
+// String returns a short description of the phase.
+func (p Phase) String() string {
+	var limit string
+	switch {
+	case p.Duration > 0 && p.Messages > 0:
+		limit = fmt.Sprintf("%v or %d msgs/conn", p.Duration, p.Messages)
+	case p.Messages > 0:
+		limit = fmt.Sprintf("%d msgs/conn", p.Messages)
+	case p.Duration > 0:
+		limit = p.Duration.String()
+	default:
+		limit = "until stopped"
+	}
+	return fmt.Sprintf("%s: %d msg/s, %s, %d conns, %d B payload", p.Name, p.Rate, limit, p.Connections, p.PayloadSize)
+}
+
+// ParseProfile parses the compact profile syntax: a comma-separated list of
+// phases, each written as name:rate:duration followed by optional
+// colon-separated key=value options. Rates may carry a "/s" suffix; durations
+// use Go syntax. Options are c (connections), size (payload bytes, see
+// parseSize), n (messages per connection) and warmup. For example:
+//
+//	warmup:100/s:30s:warmup,step1:1000/s:60s,step2:5000/s:60s:c=4,soak:10000/s:1h
+func ParseProfile(spec string) ([]Phase, error) {
+	var phases []Phase
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("profile phase %q: want name:rate:duration[:options]", part)
+		}
+
+		phase := Phase{Name: fields[0]}
+		rate, err := strconv.Atoi(strings.TrimSuffix(fields[1], "/s"))
+		if err != nil {
+			return nil, fmt.Errorf("profile phase %q: invalid rate: %w", part, err)
+		}
+		phase.Rate = rate
+		if fields[2] != "" {
+			if phase.Duration, err = time.ParseDuration(fields[2]); err != nil {
+				return nil, fmt.Errorf("profile phase %q: invalid duration: %w", part, err)
+			}
+		}
+
+		for _, opt := range fields[3:] {
+			key, value, _ := strings.Cut(opt, "=")
+			switch key {
+			case "warmup":
+				phase.Warmup = true
+				continue
+			case "c", "size", "n":
+			default:
+				return nil, fmt.Errorf("profile phase %q: unknown option %q", part, key)
+			}
+			parse := strconv.Atoi
+			if key == "size" {
+				parse = parseSize
+			}
+			n, err := parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("profile phase %q: invalid %s: %w", part, key, err)
+			}
+			switch key {
+			case "c":
+				phase.Connections = n
+			case "size":
+				phase.PayloadSize = n
+			case "n":
+				phase.Messages = n
+			}
+		}
+		phases = append(phases, phase)
+	}
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("profile %q has no phases", spec)
+	}
+	return phases, nil
+}
+
+// ParseSizeSweep parses a comma-separated list of payload sizes, such as
+// 16,256,4k,64k,1m, for Config.SizeSweep.
+func ParseSizeSweep(spec string) ([]int, error) {
+	var sizes []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		size, err := parseSize(part)
+		if err != nil {
+			return nil, fmt.Errorf("size sweep: %w", err)
+		}
+		if size < MinPayloadSize || size > MaxPayloadSize {
+			return nil, fmt.Errorf("size sweep: %s is outside %d B to %d B", part, MinPayloadSize, MaxPayloadSize)
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("size sweep %q has no sizes", spec)
+	}
+	return sizes, nil
+}
+
+// parseSize parses a size in bytes with an optional k or m suffix for KiB
+// or MiB.
+func parseSize(s string) (int, error) {
+	unit := 1
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "m"), strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %w", err)
+	}
+	return n * unit, nil
+}
+
+// LoadProfile reads a load profile from a JSON file of the form
+// {"phases": [{"name": "warmup", "rate": 100, "duration": "30s", "warmup": true}, ...]}.
+func LoadProfile(path string) ([]Phase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profile file: %w", err)
+	}
+	var file struct {
+		Phases []Phase `json:"phases"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse profile file: %w", err)
+	}
+	if len(file.Phases) == 0 {
+		return nil, fmt.Errorf("profile file %s has no phases", path)
+	}
+	return file.Phases, nil
+}
+
+// buildPhases returns the phases the client runs. Without a profile, the
+// test is a single phase built from the rate and duration flags, or one per
+// size of a size sweep, preceded by a warm-up phase of PrewarmCount messages
+// per connection.
+func buildPhases(config Config) []Phase {
+	phases := config.Profile
+	if len(phases) == 0 {
+		duration := time.Duration(config.TestDuration) * time.Second
+		if config.PrewarmCount > 0 {
+			warmup := Phase{Name: "warmup", Messages: config.PrewarmCount, Warmup: true}
+			if len(config.SizeSweep) > 0 {
+				warmup.PayloadSize = config.SizeSweep[0]
+			}
+			phases = append(phases, warmup)
+		}
+		for _, size := range config.SizeSweep {
+			phases = append(phases, Phase{Name: fmt.Sprintf("size-%d", size), Duration: duration, PayloadSize: size})
+		}
+		if len(config.SizeSweep) == 0 {
+			phases = append(phases, Phase{Name: "main", Duration: duration})
+		}
+	}
+
+	resolved := make([]Phase, len(phases))
+	for i, phase := range phases {
+		if phase.Name == "" {
+			phase.Name = fmt.Sprintf("phase%d", i+1)
+		}
+		if phase.Rate <= 0 {
+			phase.Rate = config.MessageRate
+		}
+		if phase.Connections <= 0 {
+			phase.Connections = config.Connections
+		}
+		if phase.PayloadSize <= 0 {
+			phase.PayloadSize = config.PayloadSize
+		}
+		resolved[i] = phase
+	}
+	return resolved
+}
+
+// validatePhases checks that every phase ends and has a payload size
+// within limits. In continuous mode the last phase may run until the test is
+// stopped.
+func validatePhases(phases []Phase, continuous bool) error {
+	for i, phase := range phases {
+		last := i == len(phases)-1
+		if phase.Duration <= 0 && phase.Messages <= 0 && !(last && continuous) {
+			return fmt.Errorf("phase %q needs a duration or a message count", phase.Name)
+		}
+		if phase.PayloadSize != 0 && (phase.PayloadSize < MinPayloadSize || phase.PayloadSize > MaxPayloadSize) {
+			return fmt.Errorf("phase %q: payload size %d B is outside %d B to %d B", phase.Name, phase.PayloadSize, MinPayloadSize, MaxPayloadSize)
+		}
+	}
+	return nil
+}
+
+// maxConnections returns the largest connection count of any phase.
+func maxConnections(phases []Phase) int {
+	n := 1
+	for _, phase := range phases {
+		if phase.Connections > n {
+			n = phase.Connections
+		}
+	}
+	return n
+}
+
+// PhaseResult holds the latency statistics of one phase, merged over its
+// connections. Responses are attributed to the phase their message was sent
+// in, even if they arrive after the phase ended.
+type PhaseResult struct {
+	Phase          Phase
+	MessageBytes   int // Average encoded size of the messages sent
+	Sent           int64
+	Stats          *stats.LatencyStats
+	CorrectedStats *stats.LatencyStats
+}
+
+// phaseStats holds one connection's latency statistics for one phase.
+type phaseStats struct {
+	warmup         bool
+	messageBytes   int
+	sent           atomic.Int64
+	stats          *stats.LatencyStats
+	correctedStats *stats.LatencyStats
+}
+
+// newPhaseStats creates empty statistics for the given phase.
+func newPhaseStats(phase Phase, precision int) *phaseStats {
+	return &phaseStats{
+		warmup:         phase.Warmup,
+		stats:          stats.NewLatencyStats(phase.Name+" RTT", precision),
+		correctedStats: stats.NewLatencyStats(phase.Name+" Corrected RTT", precision),
+	}
+}
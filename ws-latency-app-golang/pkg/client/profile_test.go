@@ -0,0 +1,119 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseProfile(t *testing.T) {
+	phases, err := ParseProfile("warmup:100/s:30s:warmup, step:5000:60s:c=4:size=512, soak:10000/s::n=1000")
+	if err != nil {
+		t.Fatalf("ParseProfile: %v", err)
+	}
+	want := []Phase{
+		{Name: "warmup", Rate: 100, Duration: 30 * time.Second, Warmup: true},
+		{Name: "step", Rate: 5000, Duration: 60 * time.Second, Connections: 4, PayloadSize: 512},
+		{Name: "soak", Rate: 10000, Messages: 1000},
+	}
+	if len(phases) != len(want) {
+		t.Fatalf("got %d phases, want %d", len(phases), len(want))
+	}
+	for i := range want {
+		if phases[i] != want[i] {
+			t.Errorf("phase %d = %+v, want %+v", i, phases[i], want[i])
+		}
+	}
+
+	for _, spec := range []string{"", "a:100", "a:x:1s", "a:100:1x", "a:100:1s:c=x", "a:100:1s:foo=1"} {
+		if _, err := ParseProfile(spec); err == nil {
+			t.Errorf("ParseProfile(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.json")
+	data := `{"phases": [{"name": "ramp", "rate": 1000, "duration": "1m30s", "connections": 2}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	phases, err := LoadProfile(path)
+	if err != nil {
+		t.Fatalf("LoadProfile: %v", err)
+	}
+	want := Phase{Name: "ramp", Rate: 1000, Duration: 90 * time.Second, Connections: 2}
+	if len(phases) != 1 || phases[0] != want {
+		t.Errorf("got %+v, want [%+v]", phases, want)
+	}
+}
+
+func TestBuildPhases(t *testing.T) {
+	config := Config{MessageRate: 50, TestDuration: 10, PrewarmCount: 20, Connections: 2, PayloadSize: 256}
+	phases := buildPhases(config)
+	if len(phases) != 2 {
+		t.Fatalf("got %d phases, want warm-up and main", len(phases))
+	}
+	if p := phases[0]; !p.Warmup || p.Messages != 20 || p.Rate != 50 || p.Connections != 2 {
+		t.Errorf("warm-up phase = %+v", p)
+	}
+	if p := phases[1]; p.Warmup || p.Duration != 10*time.Second || p.PayloadSize != 256 {
+		t.Errorf("main phase = %+v", p)
+	}
+	if err := validatePhases(phases, false); err != nil {
+		t.Errorf("validatePhases: %v", err)
+	}
+
+	// A profile replaces the prewarm count and duration
+	config.Profile = []Phase{{Rate: 10, Duration: time.Second}, {Connections: 5}}
+	phases = buildPhases(config)
+	if len(phases) != 2 || phases[0].Name != "phase1" || maxConnections(phases) != 5 {
+		t.Errorf("profile phases = %+v", phases)
+	}
+	if err := validatePhases(phases, false); err == nil {
+		t.Error("endless last phase accepted outside continuous mode")
+	}
+	if err := validatePhases(phases, true); err != nil {
+		t.Errorf("endless last phase rejected in continuous mode: %v", err)
+	}
+}
+
+func TestSizeSweep(t *testing.T) {
+	sizes, err := ParseSizeSweep("16, 1k,64K,1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{16, 1024, 65536, 1 << 20}; fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("sizes = %v, want %v", sizes, want)
+	}
+	for _, bad := range []string{"", "8", "2m", "1x", "16,,k"} {
+		if _, err := ParseSizeSweep(bad); err == nil {
+			t.Errorf("size sweep %q accepted", bad)
+		}
+	}
+
+	// One phase per size, warmed up at the first
+	phases := buildPhases(Config{MessageRate: 50, TestDuration: 5, PrewarmCount: 20, PayloadSize: 512, SizeSweep: sizes})
+	if len(phases) != 5 || !phases[0].Warmup || phases[0].PayloadSize != 16 {
+		t.Fatalf("sweep phases = %+v", phases)
+	}
+	for i, p := range phases[1:] {
+		if p.Name != fmt.Sprintf("size-%d", sizes[i]) || p.PayloadSize != sizes[i] || p.Duration != 5*time.Second || p.Rate != 50 {
+			t.Errorf("sweep phase %d = %+v", i, p)
+		}
+	}
+	if err := validatePhases(phases, false); err != nil {
+		t.Errorf("validatePhases: %v", err)
+	}
+	if err := validatePhases([]Phase{{Name: "big", Messages: 1, PayloadSize: MaxPayloadSize + 1}}, false); err == nil {
+		t.Error("payload size above the maximum accepted")
+	}
+
+	// Profile sizes take the same suffixes
+	profile, err := ParseProfile("a:10:1s:size=4k")
+	if err != nil || profile[0].PayloadSize != 4096 {
+		t.Errorf("profile = %+v, %v", profile, err)
+	}
+}
@@ -0,0 +1,391 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReceiveResult summarizes a receive test of server-pushed events.
+type ReceiveResult struct {
+	Events     int64 // Events received
+	Gaps       int64 // Jumps in the event sequence
+	Missed     int64 // Events skipped by those jumps
+	OutOfOrder int64 // Events older than one already received
+	Duration   time.Duration
+	Latency    *stats.LatencyStats // Server send to client receive, assuming synchronized clocks
+	Corrected  *stats.LatencyStats // The same, corrected by the estimated clock offset
+
+	// Subscription results, with Config.Subscriptions
+	Channels        []ChannelResult
+	SubscribeAck    *stats.LatencyStats // Subscribe request to acknowledgement
+	UnsubscribeAck  *stats.LatencyStats // Unsubscribe request to acknowledgement
+	SubscribeErrors int64               // Error responses to subscription requests
+}
+
+// receiver holds the receive state of one connection. Sequences are tracked
+// per topic, the channel and instrument of the event.
+type receiver struct {
+	events, gaps, missed, outOfOrder atomic.Int64
+	subscribeErrors                  atomic.Int64
+	precision                        int
+	latency                          *stats.LatencyStats
+	corrected                        *stats.LatencyStats
+	intervalLatency                  *stats.LatencyStats
+	intervalCorrected                *stats.LatencyStats
+	subscribeAcks                    *stats.LatencyStats
+	unsubscribeAcks                  *stats.LatencyStats
+	mu                               sync.Mutex
+	topics                           map[Subscription]*topicState
+	pending                          map[string]int64 // Send time of unacknowledged requests by "op channel:instId"
+}
+
+// newReceiver creates the receive state of one connection.
+func newReceiver(precision int) *receiver {
+	return &receiver{
+		precision:         precision,
+		latency:           stats.NewLatencyStats("Push", precision),
+		corrected:         stats.NewLatencyStats("Clock-Corrected Push", precision),
+		intervalLatency:   stats.NewLatencyStats("Interval Push", precision),
+		intervalCorrected: stats.NewLatencyStats("Interval Clock-Corrected Push", precision),
+		subscribeAcks:     stats.NewLatencyStats("Subscribe Ack", precision),
+		unsubscribeAcks:   stats.NewLatencyStats("Unsubscribe Ack", precision),
+		topics:            make(map[Subscription]*topicState),
+		pending:           make(map[string]int64),
+	}
+}
+
+// RunReceive runs the receive test: instead of sending messages, every
+// connection receives the events the server pushes (see the server's /push
+// endpoint) until TestDuration has elapsed or the test is stopped. It measures
+// the latency from the server send timestamp to the client receive time, and
+// gaps in the event sequence of each topic. The first PrewarmCount events of
+// each connection are counted but left out of the latency statistics.
+//
+// With Subscriptions, every connection subscribes to them at the start and
+// unsubscribes at the end (see the server's /ws/v5/public endpoint), and the
+// acknowledgement latency of both is measured.
+func (c *Client) RunReceive() error {
+	for _, cn := range c.conns {
+		if cn.currentConn() == nil {
+			return fmt.Errorf("not connected to server")
+		}
+	}
+
+	done := make(chan struct{})
+	receivers := make([]*receiver, len(c.conns))
+	var wg sync.WaitGroup
+	for i, cn := range c.conns {
+		receivers[i] = newReceiver(c.config.HistogramPrecision)
+		cn.clock.reset()
+		wg.Add(1)
+		go func(cn *connection, r *receiver) {
+			defer wg.Done()
+			cn.receiveEvents(cn.currentConn(), r, done)
+		}(cn, receivers[i])
+		if c.config.ClockProbeInterval > 0 {
+			go cn.runClockProbes(done)
+		}
+		if len(c.config.Subscriptions) > 0 {
+			if err := cn.sendOp(cn.currentConn(), receivers[i], "subscribe", c.config.Subscriptions); err != nil {
+				close(done)
+				return fmt.Errorf("connection %d: %w", cn.id, err)
+			}
+		}
+	}
+	allClosed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(allClosed)
+	}()
+
+	testStart := time.Now()
+	var testEnd <-chan time.Time
+	if c.config.Continuous {
+		log.Printf("Receiving pushed events from %s on %d connections in continuous mode\n", c.config.ServerURL, len(c.conns))
+	} else {
+		log.Printf("Receiving pushed events from %s on %d connections for %d seconds\n", c.config.ServerURL, len(c.conns), c.config.TestDuration)
+		testEnd = time.After(time.Duration(c.config.TestDuration) * time.Second)
+	}
+	if c.config.ReportInterval > 0 {
+		go c.runReceiveReporter(receivers, testStart, done)
+	}
+
+	select {
+	case <-testEnd:
+	case <-c.stop:
+	case <-allClosed:
+		log.Println("All connections closed by the server")
+	}
+	if len(c.config.Subscriptions) > 0 {
+		c.unsubscribe(receivers)
+	}
+	close(done)
+
+	c.receiveResult = mergeReceivers(receivers, time.Since(testStart), c.config.HistogramPrecision)
+	printHandshakeResults(c.handshakes, c.config.HistogramPrecision)
+	if len(receivers) > 1 {
+		printReceiverResults(receivers)
+	}
+	printReceiveResults(c.receiveResult)
+	if len(c.config.Subscriptions) > 0 {
+		printSubscriptionResults(c.receiveResult)
+	}
+	if c.config.ClockProbeInterval > 0 {
+		c.clockEstimates = c.clockEstimates[:0]
+		for _, cn := range c.conns {
+			estimate, _ := cn.clock.estimate(cn.id)
+			c.clockEstimates = append(c.clockEstimates, estimate)
+		}
+		printClockResults(c.clockEstimates)
+	}
+	c.receiveResult.Latency.PrintResults()
+	if c.receiveResult.Corrected.Count > 0 {
+		c.receiveResult.Corrected.PrintResults()
+	}
+	return nil
+}
+
+// pushedMessage is a message received in receive mode: a pushed event, a
+// subscription response or a clock probe response.
+type pushedMessage struct {
+	opMessage
+	Test *pushedTest `json:"_test"`
+}
+
+// pushedTest is the "_test" field of a pushed event or probe response. Other
+// servers may stamp fractional microseconds, so numbers are read as float64.
+type pushedTest struct {
+	Probe        bool    `json:"probe"`
+	Sequence     float64 `json:"sequence"`
+	ClientSendUs float64 `json:"client_send_ts_us"`
+	ServerRecvUs float64 `json:"server_ts_us"`
+	ServerSendUs float64 `json:"server_send_ts_us"`
+}
+
+// receiveEvents reads pushed events from conn until it fails or is closed,
+// recording latency and sequence gaps in r. Responses to clock probes are
+// passed to the clock estimator. Errors after done is closed are expected, as
+// the connection is closed at the end of the test.
+func (cn *connection) receiveEvents(conn *websocket.Conn, r *receiver, done <-chan struct{}) {
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				log.Printf("Connection %d read error: %v", cn.id, err)
+			}
+			return
+		}
+		recvUs := time.Now().UnixNano() / 1000
+
+		// Decode every kind of message in one pass; fields that are missing
+		// keep their initial values
+		test := pushedTest{Sequence: -1, ServerSendUs: -1}
+		msg := pushedMessage{Test: &test}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Println("JSON parse error:", err)
+			continue
+		}
+		if msg.Event != "" {
+			r.handleOpResponse(msg.opMessage, recvUs)
+			continue
+		}
+		if test.Probe {
+			cn.handleProbe(&codec.Message{
+				ClientSendUs: int64(test.ClientSendUs),
+				ServerRecvUs: int64(test.ServerRecvUs),
+				ServerSendUs: int64(test.ServerSendUs),
+			}, recvUs)
+			continue
+		}
+		if test.Sequence < 0 || test.ServerSendUs < 0 {
+			log.Println("Event without sequence number or server send timestamp")
+			continue
+		}
+		seq, sendUs := uint64(test.Sequence), test.ServerSendUs
+
+		var topic Subscription
+		if msg.Arg != nil {
+			topic = *msg.Arg
+		}
+
+		events := r.events.Add(1)
+		cn.metrics.countPush()
+		t, inOrder := r.sequenced(topic, seq)
+		if !inOrder {
+			continue
+		}
+		if events <= int64(cn.config.PrewarmCount) {
+			continue
+		}
+
+		latency := max(recvUs-int64(sendUs), 0)
+		cn.metrics.observePush(latency)
+		t.latency.AddSample(latency)
+		r.latency.AddSample(latency)
+		r.intervalLatency.AddSample(latency)
+		if offset, ok := cn.clock.offsetAt(recvUs); ok {
+			corrected := max(recvUs-int64(sendUs-offset), 0)
+			r.corrected.AddSample(corrected)
+			r.intervalCorrected.AddSample(corrected)
+		}
+	}
+}
+
+// sequenced checks seq against the last sequence number received for topic,
+// counting the event, gaps and out-of-order events, and returns the state of
+// the topic. It returns false for out-of-order events, whose latency is not
+// recorded. The first event sets the starting point, as subscribers join the
+// feed mid-stream.
+func (r *receiver) sequenced(topic Subscription, seq uint64) (*topicState, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.topics[topic]
+	if !ok {
+		t = &topicState{latency: stats.NewLatencyStats(topic.String(), r.precision)}
+		r.topics[topic] = t
+	}
+	t.events++
+
+	switch {
+	case !t.seen:
+		t.seen = true
+	case seq <= t.lastSeq:
+		t.outOfOrder++
+		r.outOfOrder.Add(1)
+		return t, false
+	case seq > t.lastSeq+1:
+		t.gaps++
+		t.missed += int64(seq - t.lastSeq - 1)
+		r.gaps.Add(1)
+		r.missed.Add(int64(seq - t.lastSeq - 1))
+	}
+	t.lastSeq = seq
+	return t, true
+}
+
+// unsubscribe sends an unsubscribe request on every connection and waits up
+// to ResponseTimeout for the acknowledgements.
+func (c *Client) unsubscribe(receivers []*receiver) {
+	for i, cn := range c.conns {
+		conn := cn.currentConn()
+		if conn == nil {
+			continue
+		}
+		if err := cn.sendOp(conn, receivers[i], "unsubscribe", c.config.Subscriptions); err != nil {
+			log.Printf("Connection %d unsubscribe error: %v", cn.id, err)
+		}
+	}
+	deadline := time.Now().Add(c.config.ResponseTimeout)
+	var unacked int
+	for _, r := range receivers {
+		unacked += r.awaitAcks(deadline)
+	}
+	if unacked > 0 {
+		log.Printf("%d subscription requests were not acknowledged within %s", unacked, c.config.ResponseTimeout)
+	}
+}
+
+// mergeReceivers combines the receive state of all connections.
+func mergeReceivers(receivers []*receiver, duration time.Duration, precision int) *ReceiveResult {
+	result := &ReceiveResult{
+		Duration:       duration,
+		Latency:        stats.NewLatencyStats("Push", precision),
+		Corrected:      stats.NewLatencyStats("Clock-Corrected Push", precision),
+		Channels:       mergeChannels(receivers, precision),
+		SubscribeAck:   stats.NewLatencyStats("Subscribe Ack", precision),
+		UnsubscribeAck: stats.NewLatencyStats("Unsubscribe Ack", precision),
+	}
+	for _, r := range receivers {
+		result.SubscribeErrors += r.subscribeErrors.Load()
+		result.SubscribeAck.Merge(r.subscribeAcks.Snapshot(false))
+		result.UnsubscribeAck.Merge(r.unsubscribeAcks.Snapshot(false))
+		result.Events += r.events.Load()
+		result.Gaps += r.gaps.Load()
+		result.Missed += r.missed.Load()
+		result.OutOfOrder += r.outOfOrder.Load()
+		result.Latency.Merge(r.latency.Snapshot(false))
+		result.Corrected.Merge(r.corrected.Snapshot(false))
+	}
+	result.Latency.Calculate()
+	result.Corrected.Calculate()
+	result.SubscribeAck.Calculate()
+	result.UnsubscribeAck.Calculate()
+	return result
+}
+
+// runReceiveReporter prints a one-line receive summary every ReportInterval
+// until done is closed.
+func (c *Client) runReceiveReporter(receivers []*receiver, testStart time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(c.config.ReportInterval)
+	defer ticker.Stop()
+
+	lastReport := testStart
+	var last ReceiveResult
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			var total ReceiveResult
+			latency := stats.NewLatencyStats("Interval Push", c.config.HistogramPrecision)
+			corrected := stats.NewLatencyStats("Interval Clock-Corrected Push", c.config.HistogramPrecision)
+			for _, r := range receivers {
+				total.Events += r.events.Load()
+				total.Gaps += r.gaps.Load()
+				total.Missed += r.missed.Load()
+				total.OutOfOrder += r.outOfOrder.Load()
+				latency.Merge(r.intervalLatency.Snapshot(true))
+				corrected.Merge(r.intervalCorrected.Snapshot(true))
+			}
+			latency.Calculate()
+			corrected.Calculate()
+			c.metrics.update(latency)
+
+			period := now.Sub(lastReport)
+			events := total.Events - last.Events
+			log.Printf("[Interval %s] events=%d (%.0f/s) gaps=%d missed=%d ooo=%d | Latency p50=%d p99=%d p99.9=%d max=%d | Clock-corrected p50=%d p99=%d max=%d (µs)\n",
+				period.Round(time.Second), events, float64(events)/period.Seconds(),
+				total.Gaps-last.Gaps, total.Missed-last.Missed, total.OutOfOrder-last.OutOfOrder,
+				latency.P50, latency.P99, latency.P999, latency.Max,
+				corrected.P50, corrected.P99, corrected.Max)
+			lastReport, last = now, total
+		}
+	}
+}
+
+// printReceiverResults prints a one-line summary per connection.
+func printReceiverResults(receivers []*receiver) {
+	log.Println("===== Per-Connection Results (µs) =====")
+	for i, r := range receivers {
+		latency := r.latency.Snapshot(false)
+		latency.Calculate()
+		log.Printf("Conn %3d: events=%d gaps=%d missed=%d ooo=%d | Latency p50=%d p99=%d max=%d\n",
+			i, r.events.Load(), r.gaps.Load(), r.missed.Load(), r.outOfOrder.Load(),
+			latency.P50, latency.P99, latency.Max)
+	}
+}
+
+// printReceiveResults prints the final event counts of a receive test.
+func printReceiveResults(r *ReceiveResult) {
+	log.Println("===== Pushed Events =====")
+	log.Printf("Received:     %d (%.2f/s)\n", r.Events, float64(r.Events)/r.Duration.Seconds())
+	log.Printf("Gaps:         %d\n", r.Gaps)
+	log.Printf("Missed:       %d (%.3f%%)\n", r.Missed, lossPercent(r.Missed, r.Events+r.Missed))
+	log.Printf("Out of order: %d\n", r.OutOfOrder)
+}
+
+// GetReceiveResult returns the results of the last receive test
+func (c *Client) GetReceiveResult() *ReceiveResult {
+	return c.receiveResult
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/server"
+)
+
+func TestReceiverSequenced(t *testing.T) {
+	r := newReceiver(3)
+	tickers := Subscription{"tickers", "BTC-USDT"}
+	trades := Subscription{"trades", "BTC-USDT"}
+	for _, seq := range []uint64{10, 11, 14, 12, 15, 15, 20} {
+		r.sequenced(tickers, seq)
+	}
+	// Other topics have sequences of their own
+	for _, seq := range []uint64{1, 2, 3} {
+		r.sequenced(trades, seq)
+	}
+	if r.gaps.Load() != 2 || r.missed.Load() != 6 || r.outOfOrder.Load() != 2 {
+		t.Errorf("gaps=%d missed=%d ooo=%d, want 2, 6, 2", r.gaps.Load(), r.missed.Load(), r.outOfOrder.Load())
+	}
+	if ts := r.topics[tickers]; ts.events != 7 || ts.gaps != 2 || ts.lastSeq != 20 {
+		t.Errorf("tickers events=%d gaps=%d last=%d, want 7, 2, 20", ts.events, ts.gaps, ts.lastSeq)
+	}
+	if ts := r.topics[trades]; ts.events != 3 || ts.gaps != 0 || ts.lastSeq != 3 {
+		t.Errorf("trades events=%d gaps=%d last=%d, want 3, 0, 3", ts.events, ts.gaps, ts.lastSeq)
+	}
+}
+
+func TestReceiverSequencedFromZero(t *testing.T) {
+	r := newReceiver(3)
+	topic := Subscription{"tickers", "BTC-USDT"}
+	for _, seq := range []uint64{0, 0, 2} {
+		r.sequenced(topic, seq)
+	}
+	// The repeated 0 is out of order and 1 is missing
+	if r.outOfOrder.Load() != 1 || r.gaps.Load() != 1 || r.missed.Load() != 1 {
+		t.Errorf("ooo=%d gaps=%d missed=%d, want 1, 1, 1", r.outOfOrder.Load(), r.gaps.Load(), r.missed.Load())
+	}
+}
+
+func TestParseSubscriptions(t *testing.T) {
+	subs, err := ParseSubscriptions("tickers:BTC-USDT, trades:ETH-USDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0] != (Subscription{"tickers", "BTC-USDT"}) || subs[1] != (Subscription{"trades", "ETH-USDT"}) {
+		t.Errorf("subscriptions = %v", subs)
+	}
+	for _, spec := range []string{"tickers", "tickers:", ":BTC-USDT"} {
+		if _, err := ParseSubscriptions(spec); err == nil {
+			t.Errorf("ParseSubscriptions(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRunReceive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{PushRate: 200}).Serve(listener)
+
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/push",
+		TestDuration:       1,
+		Connections:        2,
+		PrewarmCount:       10,
+		ClockProbeInterval: 20 * time.Millisecond,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.RunReceive(); err != nil {
+		t.Fatalf("RunReceive: %v", err)
+	}
+
+	r := c.GetReceiveResult()
+	if r.Events < 2*150 || r.Gaps != 0 || r.OutOfOrder != 0 {
+		t.Errorf("result = %+v, want about 400 events without gaps", r)
+	}
+	if r.Latency.Count != r.Events-2*10 {
+		t.Errorf("latency samples = %d, want %d events less 10 warm-up per connection", r.Latency.Count, r.Events)
+	}
+	if r.Corrected.Count == 0 || r.Latency.P50 > 10_000 {
+		t.Errorf("latency p50 = %dµs, %d clock-corrected samples", r.Latency.P50, r.Corrected.Count)
+	}
+}
+
+func TestRunReceiveSubscriptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{Channels: []server.ChannelRate{
+		{Channel: "tickers", Rate: 100},
+		{Channel: "trades", InstID: "BTC-USDT", Rate: 300},
+	}}).Serve(listener)
+
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/ws/v5/public",
+		TestDuration:       1,
+		Connections:        2,
+		Subscriptions:      []Subscription{{"tickers", "BTC-USDT"}, {"trades", "BTC-USDT"}, {"books", "BTC-USDT"}},
+		HistogramPrecision: 3,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if err := c.RunReceive(); err != nil {
+		t.Fatalf("RunReceive: %v", err)
+	}
+
+	r := c.GetReceiveResult()
+	if r.SubscribeAck.Count != 2*2 || r.UnsubscribeAck.Count != 2*3 || r.SubscribeErrors != 2 {
+		t.Errorf("acks = %d subscribe, %d unsubscribe, %d errors, want 4, 6, 2",
+			r.SubscribeAck.Count, r.UnsubscribeAck.Count, r.SubscribeErrors)
+	}
+	if len(r.Channels) != 2 {
+		t.Fatalf("channels = %d, want 2", len(r.Channels))
+	}
+	// Both connections subscribe to the same topics, and at the channel rates
+	tickers, trades := r.Channels[0], r.Channels[1]
+	if tickers.Topic.Channel != "tickers" || tickers.Events < 2*70 || tickers.Events > 2*130 {
+		t.Errorf("tickers: %s with %d events, want about 200", tickers.Topic, tickers.Events)
+	}
+	if trades.Topic.Channel != "trades" || trades.Events < 2*220 || trades.Events > 2*380 {
+		t.Errorf("trades: %s with %d events, want about 600", trades.Topic, trades.Events)
+	}
+	if r.Gaps != 0 || r.OutOfOrder != 0 || r.Events != tickers.Events+trades.Events {
+		t.Errorf("result = %d events, %d gaps, %d out of order", r.Events, r.Gaps, r.OutOfOrder)
+	}
+}
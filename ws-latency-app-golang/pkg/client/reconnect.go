@@ -0,0 +1,199 @@
+package client
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Outage is a period during which a connection was down.
+type Outage struct {
+	Conn      int
+	Start     time.Time
+	Duration  time.Duration   // Until reconnected, or until the test ended if never recovered
+	Error     string          // The error that took the connection down
+	Attempts  int             // Reconnect attempts made
+	Recovered bool            // Whether the connection was re-established
+	Reconnect HandshakeTiming // Setup timing of the successful reconnect
+	Unsent    int64           // Scheduled messages that could not be sent during the outage
+}
+
+// Default reconnect backoff
+const (
+	DefaultReconnectMinBackoff = 100 * time.Millisecond
+	DefaultReconnectMaxBackoff = 30 * time.Second
+	DefaultReconnectJitter     = 0.2
+)
+
+// currentConn returns the WebSocket connection, or nil while it is down.
+func (cn *connection) currentConn() *websocket.Conn {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.conn
+}
+
+// upChan returns a channel that is closed while the connection is up.
+func (cn *connection) upChan() <-chan struct{} {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.up
+}
+
+// handleFailure takes a failed connection down and starts reconnecting, if
+// reconnecting is enabled and sending has not finished. It returns false if
+// the failure ends the connection for the rest of the test. Failures of a
+// connection that was already replaced are ignored.
+func (cn *connection) handleFailure(conn *websocket.Conn, err error) bool {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if cn.conn != conn {
+		// Already handled by the other side of the connection
+		return true
+	}
+	cn.metrics.failed()
+	if !cn.reconnectEnabled() {
+		return false
+	}
+
+	log.Printf("Connection %d lost: %v; reconnecting...\n", cn.id, err)
+	cn.conn = nil
+	cn.up = make(chan struct{})
+	conn.Close()
+
+	// Messages in flight on the old connection can no longer be answered
+	cn.releaseSlots(cn.tracker.expireAll(time.Now().UnixNano() / 1000))
+
+	cn.downSince = time.Now()
+	outage := Outage{
+		Conn:   cn.id,
+		Start:  cn.downSince,
+		Error:  err.Error(),
+		Unsent: cn.tracker.snapshot().Unsent,
+	}
+	go cn.reconnect(outage)
+	return true
+}
+
+// reconnectEnabled reports whether failures should be reconnected. The caller
+// must hold cn.mu.
+func (cn *connection) reconnectEnabled() bool {
+	if !cn.config.Reconnect || !cn.config.Continuous || cn.sendingDone == nil {
+		return false
+	}
+	select {
+	case <-cn.sendingDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// reconnect redials with exponential backoff and jitter until it succeeds or
+// sending finishes, then records the outage. On success a new response reader
+// is started; otherwise the connection is finished.
+func (cn *connection) reconnect(outage Outage) {
+	backoff := cn.config.ReconnectMinBackoff
+	for {
+		select {
+		case <-cn.sendingDone:
+			outage.Duration = time.Since(outage.Start)
+			outage.Unsent = cn.tracker.snapshot().Unsent - outage.Unsent
+			log.Printf("Connection %d still down after %v and %d attempts; giving up\n", cn.id, outage.Duration.Round(time.Millisecond), outage.Attempts)
+			cn.mu.Lock()
+			cn.outages = append(cn.outages, outage)
+			cn.downSince = time.Time{}
+			cn.mu.Unlock()
+			close(cn.done)
+			return
+		case <-time.After(jitter(backoff, cn.config.ReconnectJitter)):
+		}
+
+		outage.Attempts++
+		conn, _, timing, err := dialWithTrace(cn.dialer, cn.config.ServerURL)
+		if err == nil {
+			if err = checkCodec(conn, cn.codec); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("Connection %d reconnect attempt %d failed: %v\n", cn.id, outage.Attempts, err)
+			backoff *= 2
+			if backoff > cn.config.ReconnectMaxBackoff {
+				backoff = cn.config.ReconnectMaxBackoff
+			}
+			continue
+		}
+
+		outage.Duration = time.Since(outage.Start)
+		outage.Recovered = true
+		outage.Reconnect = timing
+		outage.Unsent = cn.tracker.snapshot().Unsent - outage.Unsent
+		log.Printf("Connection %d reconnected after %v (%d attempts, handshake %v, %d messages unsent)\n",
+			cn.id, outage.Duration.Round(time.Millisecond), outage.Attempts, timing.Total, outage.Unsent)
+
+		cn.metrics.reconnected()
+		cn.mu.Lock()
+		cn.outages = append(cn.outages, outage)
+		cn.downSince = time.Time{}
+		if !cn.reconnectEnabled() {
+			// Sending finished while dialing; the new connection is not needed
+			cn.mu.Unlock()
+			conn.Close()
+			close(cn.done)
+			return
+		}
+		// The new connection may reach a server with a different clock
+		cn.clock.reset()
+		cn.conn = conn
+		close(cn.up)
+		cn.mu.Unlock()
+		go cn.readResponses(conn)
+		return
+	}
+}
+
+// jitter randomizes d by up to ±fraction of its value.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + fraction*(2*rand.Float64()-1)))
+}
+
+// outageSnapshot returns the recorded outages and, if the connection is down
+// now, when the ongoing outage started.
+func (cn *connection) outageSnapshot() ([]Outage, time.Time) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	outages := make([]Outage, len(cn.outages))
+	copy(outages, cn.outages)
+	return outages, cn.downSince
+}
+
+// printOutageResults prints every outage and the resulting availability over
+// the test period.
+func printOutageResults(outages []Outage, numConns int, period time.Duration) {
+	log.Println("===== Outages =====")
+	var downtime time.Duration
+	var unsent int64
+	for _, o := range outages {
+		downtime += o.Duration
+		unsent += o.Unsent
+		status := "recovered"
+		if !o.Recovered {
+			status = "not recovered"
+		}
+		log.Printf("Conn %3d: %s for %v, %s after %d attempts (reconnect handshake %v), %d unsent | %s\n",
+			o.Conn, o.Start.Format("15:04:05.000"), o.Duration.Round(time.Millisecond), status,
+			o.Attempts, o.Reconnect.Total.Round(time.Microsecond), o.Unsent, o.Error)
+	}
+
+	availability := 100.0
+	if total := period * time.Duration(numConns); total > 0 {
+		availability = 100 * (1 - float64(downtime)/float64(total))
+	}
+	log.Printf("Outages:      %d, total downtime %v, %d messages unsent\n", len(outages), downtime.Round(time.Millisecond), unsent)
+	log.Printf("Availability: %.4f%% of connection time\n", availability)
+}
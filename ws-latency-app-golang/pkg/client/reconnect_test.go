@@ -0,0 +1,67 @@
+package client
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReconnectRecordsOutage(t *testing.T) {
+	// The first connection is dropped after 20 messages; later ones echo
+	var accepted atomic.Int32
+	srv := newTestServer(t, func(conn *websocket.Conn) {
+		limit := 0
+		if accepted.Add(1) == 1 {
+			limit = 20
+		}
+		echo(conn, limit, nil)
+	})
+
+	c := NewClient(Config{
+		ServerURL:           "ws" + strings.TrimPrefix(srv.URL, "http"),
+		MessageRate:         200,
+		Continuous:          true,
+		ResponseTimeout:     500 * time.Millisecond,
+		Reconnect:           true,
+		ReconnectMinBackoff: 50 * time.Millisecond,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+
+	time.AfterFunc(time.Second, c.Stop)
+	if _, err := c.RunTest(); err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	outages := c.GetOutages()
+	if len(outages) != 1 {
+		t.Fatalf("got %d outages, want 1: %+v", len(outages), outages)
+	}
+	o := outages[0]
+	if !o.Recovered || o.Attempts < 1 || o.Duration <= 0 || o.Error == "" || o.Reconnect.Total <= 0 {
+		t.Errorf("outage = %+v", o)
+	}
+	counts := c.GetDeliveryCounts()
+	if counts.Unsent != o.Unsent {
+		t.Errorf("unsent = %d overall, %d in the outage", counts.Unsent, o.Unsent)
+	}
+	if counts.Received <= 20 {
+		t.Errorf("received %d responses, want more than the 20 before the outage", counts.Received)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if got := jitter(time.Second, 0); got != time.Second {
+		t.Errorf("jitter without fraction = %v", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := jitter(time.Second, 0.2); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter(1s, 0.2) = %v", got)
+		}
+	}
+}
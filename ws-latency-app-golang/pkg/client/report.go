@@ -0,0 +1,229 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"ws-latency-app-golang/pkg/stats"
+)
+
+// runReporter prints an interval and a cumulative summary every
+// ReportInterval until done is closed. Interval statistics are snapshotted and
+// reset in one step, so the reader goroutines keep recording throughout.
+func (c *Client) runReporter(testStart time.Time, done <-chan struct{}) {
+	ticker := time.NewTicker(c.config.ReportInterval)
+	defer ticker.Stop()
+
+	lastReport := testStart
+	var last DeliveryCounts
+	lastOutages := 0
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			counts := c.deliveryCounts()
+
+			interval := c.mergeStats("Interval RTT", func(cn *connection) *stats.LatencyStats { return cn.intervalStats }, true)
+			intervalCorrected := c.mergeStats("Interval Corrected RTT", func(cn *connection) *stats.LatencyStats { return cn.intervalCorrected }, true)
+			printIntervalReport("Interval", now.Sub(lastReport), counts.sub(last), interval, intervalCorrected)
+			c.metrics.update(interval)
+			dwell := c.mergeStats("Interval Server Dwell", func(cn *connection) *stats.LatencyStats { return cn.intervalDwell }, true)
+			path := c.mergeStats("Interval Network + Client", func(cn *connection) *stats.LatencyStats { return cn.intervalPath }, true)
+			if dwell.Count > 0 {
+				printBreakdownReport("Interval", now.Sub(lastReport), dwell, path)
+			}
+			up := c.mergeStats("Interval Client→Server", func(cn *connection) *stats.LatencyStats { return cn.intervalUp }, true)
+			down := c.mergeStats("Interval Server→Client", func(cn *connection) *stats.LatencyStats { return cn.intervalDown }, true)
+			if up.Count > 0 {
+				printOneWayReport("Interval", now.Sub(lastReport), up, down, c.conns[0].clock)
+			}
+			ping := c.mergeStats("Interval Ping RTT", func(cn *connection) *stats.LatencyStats { return cn.intervalPing }, true)
+			if ping.Count > 0 {
+				printPingReport("Interval", now.Sub(lastReport), interval, ping)
+			}
+
+			cumulative := c.mergeStats("RTT", func(cn *connection) *stats.LatencyStats { return cn.stats }, false)
+			cumulativeCorrected := c.mergeStats("Corrected RTT", func(cn *connection) *stats.LatencyStats { return cn.correctedStats }, false)
+			printIntervalReport("Cumulative", now.Sub(testStart), counts, cumulative, cumulativeCorrected)
+			if c.reconnecting() {
+				lastOutages = c.printOutageReport(now, lastReport, lastOutages, counts.sub(last).Unsent)
+			}
+
+			lastReport, last = now, counts
+		}
+	}
+}
+
+// printOutageReport prints outage activity since the last report, if there
+// was any, and returns the number of outages recorded so far.
+func (c *Client) printOutageReport(now, lastReport time.Time, lastOutages int, unsent int64) int {
+	recorded, down := 0, 0
+	var downtime time.Duration
+	for _, cn := range c.conns {
+		outages, downSince := cn.outageSnapshot()
+		recorded += len(outages)
+		for _, o := range outages {
+			downtime += overlap(o.Start, o.Start.Add(o.Duration), lastReport, now)
+		}
+		if !downSince.IsZero() {
+			down++
+			downtime += overlap(downSince, now, lastReport, now)
+		}
+	}
+	if recorded == lastOutages && down == 0 {
+		return lastOutages
+	}
+	log.Printf("[Interval %s] outages: ended=%d down=%d downtime=%v unsent=%d\n",
+		now.Sub(lastReport).Round(time.Second), recorded-lastOutages, down, downtime.Round(time.Millisecond), unsent)
+	return recorded
+}
+
+// overlap returns how much of [start, end) falls within [from, to).
+func overlap(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// mergeStats snapshots the statistics selected by pick on every connection,
+// optionally resetting them, and returns the calculated merge.
+func (c *Client) mergeStats(name string, pick func(cn *connection) *stats.LatencyStats, reset bool) *stats.LatencyStats {
+	merged := stats.NewLatencyStats(name, c.config.HistogramPrecision)
+	for _, cn := range c.conns {
+		merged.Merge(pick(cn).Snapshot(reset))
+	}
+	merged.Calculate()
+	return merged
+}
+
+// lossPercent returns the share of sent messages that timed out. Late
+// responses are not subtracted, so an interval reports the timeouts that
+// happened in it even if those messages arrive in a later interval.
+func lossPercent(timedOut, sent int64) float64 {
+	if sent <= 0 {
+		return 0
+	}
+	return float64(timedOut) / float64(sent) * 100
+}
+
+// printIntervalReport prints a one-line summary of the given statistics.
+func printIntervalReport(label string, period time.Duration, counts DeliveryCounts, rtt, corrected *stats.LatencyStats) {
+	var rate float64
+	if period > 0 {
+		rate = float64(counts.Received) / period.Seconds()
+	}
+	log.Printf("[%s %s] sent=%d recv=%d (%.0f/s) lost=%d (%.2f%%) late=%d dup=%d ooo=%d | RTT count=%d p50=%d p90=%d p99=%d p99.9=%d max=%d | Corrected p50=%d p99=%d p99.9=%d max=%d (µs)\n",
+		label, period.Round(time.Second), counts.Sent, counts.Received, rate, counts.TimedOut, lossPercent(counts.TimedOut, counts.Sent),
+		counts.Late, counts.Duplicated, counts.OutOfOrder,
+		rtt.Count, rtt.P50, rtt.P90, rtt.P99, rtt.P999, rtt.Max,
+		corrected.P50, corrected.P99, corrected.P999, corrected.Max)
+}
+
+// printBreakdownReport prints a one-line split of RTT into server dwell time
+// and network plus client time, to tell server stalls from path stalls.
+func printBreakdownReport(label string, period time.Duration, dwell, path *stats.LatencyStats) {
+	log.Printf("[%s %s] Server dwell p50=%d p99=%d p99.9=%d max=%d | Network+client p50=%d p99=%d p99.9=%d max=%d (µs)\n",
+		label, period.Round(time.Second),
+		dwell.P50, dwell.P99, dwell.P999, dwell.Max,
+		path.P50, path.P99, path.P999, path.Max)
+}
+
+// printOneWayReport prints a one-line summary of the estimated one-way
+// latencies, with the clock estimate of the first connection for reference.
+func printOneWayReport(label string, period time.Duration, up, down *stats.LatencyStats, clock *clockEstimator) {
+	var offset string
+	if e, ok := clock.estimate(0); ok {
+		offset = fmt.Sprintf(" | Conn 0 offset=%.1f±%.1f drift=%.2fppm", e.Offset, e.Error, e.DriftPPM)
+	}
+	log.Printf("[%s %s] Client→server p50=%d p99=%d max=%d | Server→client p50=%d p99=%d max=%d%s (µs, estimated)\n",
+		label, period.Round(time.Second),
+		up.P50, up.P99, up.Max, down.P50, down.P99, down.Max, offset)
+}
+
+// printDeliveryResults prints the final message delivery counts.
+func printDeliveryResults(counts DeliveryCounts) {
+	log.Println("===== Message Delivery =====")
+	log.Printf("Sent:         %d\n", counts.Sent)
+	log.Printf("Received:     %d\n", counts.Received)
+	log.Printf("Lost:         %d (%.3f%%)\n", counts.Lost(), lossPercent(counts.Lost(), counts.Sent))
+	log.Printf("Late:         %d (arrived after response timeout)\n", counts.Late)
+	log.Printf("Duplicated:   %d\n", counts.Duplicated)
+	log.Printf("Out-of-order: %d\n", counts.OutOfOrder)
+	if counts.Unknown > 0 {
+		log.Printf("Unknown:      %d (sequence never sent)\n", counts.Unknown)
+	}
+	if counts.Unsent > 0 {
+		log.Printf("Unsent:       %d (connection down)\n", counts.Unsent)
+	}
+}
+
+// printThroughput prints the achieved response throughput over the sending
+// period.
+func printThroughput(counts DeliveryCounts, period time.Duration, numConns int) {
+	if period <= 0 {
+		return
+	}
+	rate := float64(counts.Received) / period.Seconds()
+	log.Printf("Throughput:   %.2f msg/s (%.2f msg/s per connection)\n", rate, rate/float64(numConns))
+}
+
+// printPhaseResults prints a one-line summary per load-profile phase.
+func printPhaseResults(results []PhaseResult, corrected bool) {
+	log.Println("===== Per-Phase Results (µs) =====")
+	for _, r := range results {
+		line := fmt.Sprintf("%-10s %6d msg/s %3d conns %6d B: sent=%d recv=%d | RTT p50=%d p90=%d p99=%d p99.9=%d max=%d",
+			r.Phase.Name, r.Phase.Rate, r.Phase.Connections, r.Phase.PayloadSize, r.Sent, r.Stats.Count,
+			r.Stats.P50, r.Stats.P90, r.Stats.P99, r.Stats.P999, r.Stats.Max)
+		if corrected {
+			line += fmt.Sprintf(" | Corrected p99=%d p99.9=%d", r.CorrectedStats.P99, r.CorrectedStats.P999)
+		}
+		if r.Phase.Warmup {
+			line += " (warm-up)"
+		}
+		log.Println(line)
+	}
+}
+
+// printSizeSweep prints the latency of each size of a size sweep against the
+// payload size and the actual message size.
+func printSizeSweep(results []PhaseResult, corrected bool) {
+	log.Println("===== Latency vs Payload Size (µs) =====")
+	header := fmt.Sprintf("%10s %10s %10s %8s %8s %8s %8s %8s %8s", "Size B", "Message B", "Received", "P50", "P90", "P99", "P99.9", "Max", "Mean")
+	if corrected {
+		header += fmt.Sprintf(" %14s", "Corrected P99")
+	}
+	log.Println(header)
+	for _, r := range results {
+		if r.Phase.Warmup {
+			continue
+		}
+		line := fmt.Sprintf("%10d %10d %10d %8d %8d %8d %8d %8d %8.1f", r.Phase.PayloadSize, r.MessageBytes, r.Stats.Count,
+			r.Stats.P50, r.Stats.P90, r.Stats.P99, r.Stats.P999, r.Stats.Max, r.Stats.Mean)
+		if corrected {
+			line += fmt.Sprintf(" %14d", r.CorrectedStats.P99)
+		}
+		log.Println(line)
+	}
+}
+
+// printConnectionResults prints a one-line summary per connection.
+func printConnectionResults(conns []*connection) {
+	log.Println("===== Per-Connection Results (µs) =====")
+	for _, cn := range conns {
+		counts := cn.tracker.snapshot()
+		cn.dwellStats.Calculate()
+		log.Printf("Conn %3d: sent=%d recv=%d lost=%d | RTT p50=%d p90=%d p99=%d p99.9=%d max=%d | Corrected p99=%d | Dwell p99=%d\n",
+			cn.id, counts.Sent, counts.Received, counts.Lost(),
+			cn.stats.P50, cn.stats.P90, cn.stats.P99, cn.stats.P999, cn.stats.Max,
+			cn.correctedStats.P99, cn.dwellStats.P99)
+	}
+}
@@ -0,0 +1,374 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+
+	"ws-latency-app-golang/pkg/stats"
+)
+
+// Output formats of a Result
+const (
+	OutputJSON = "json"
+	OutputCSV  = "csv"
+)
+
+// Result is the machine-readable result of a test, for comparing runs and
+// feeding dashboards. Latencies are in microseconds.
+type Result struct {
+	Config         Config             `json:"config"`
+	Environment    Environment        `json:"environment"`
+	Start          time.Time          `json:"start"`
+	End            time.Time          `json:"end"`
+	SendDuration   float64            `json:"send_duration_s"` // Seconds spent sending, without waiting for the last responses
+	SendRate       float64            `json:"send_rate"`       // Messages sent per second
+	Delivery       DeliveryCounts     `json:"delivery"`
+	Lost           int64              `json:"lost"`
+	RTT            stats.Summary      `json:"rtt"`
+	CorrectedRTT   *stats.Summary     `json:"corrected_rtt,omitempty"`    // Open-loop only
+	ServerDwell    *stats.Summary     `json:"server_dwell,omitempty"`     // If the server stamps its times
+	NetworkClient  *stats.Summary     `json:"network_client,omitempty"`   // RTT minus server dwell
+	ClientToServer *stats.Summary     `json:"client_to_server,omitempty"` // With clock probes
+	ServerToClient *stats.Summary     `json:"server_to_client,omitempty"` // With clock probes
+	PingRTT        *stats.Summary     `json:"ping_rtt,omitempty"`         // With interleaved pings
+	Pings          *PingCounts        `json:"pings,omitempty"`            // With interleaved pings
+	Phases         []PhaseSummary     `json:"phases,omitempty"`
+	Handshakes     []HandshakeSummary `json:"handshakes"`
+	Outages        []OutageSummary    `json:"outages,omitempty"`
+	ClockEstimates []ClockEstimate    `json:"clock_estimates,omitempty"`
+}
+
+// Environment describes the machine and build that ran a test.
+type Environment struct {
+	Hostname   string   `json:"hostname"`
+	GoVersion  string   `json:"go_version"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+	CPUs       int      `json:"cpus"`
+	GOMAXPROCS int      `json:"gomaxprocs"`
+	Revision   string   `json:"revision,omitempty"` // VCS revision the binary was built from, if known
+	Args       []string `json:"args"`
+}
+
+// PhaseSummary is the result of one load-profile phase.
+type PhaseSummary struct {
+	Phase        Phase         `json:"phase"`
+	MessageBytes int           `json:"message_bytes"` // Average encoded size of the messages sent
+	Sent         int64         `json:"sent"`
+	RTT          stats.Summary `json:"rtt"`
+	CorrectedRTT stats.Summary `json:"corrected_rtt"`
+}
+
+// PingCounts counts the interleaved pings of a test.
+type PingCounts struct {
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"` // Pongs received
+}
+
+// HandshakeSummary is the connection setup timing of one connection.
+type HandshakeSummary struct {
+	Conn           int    `json:"conn"`
+	RemoteAddr     string `json:"remote_addr"`
+	DNSUs          int64  `json:"dns_us"`
+	TCPConnectUs   int64  `json:"tcp_connect_us"`
+	TLSHandshakeUs int64  `json:"tls_handshake_us"`
+	UpgradeUs      int64  `json:"upgrade_us"`
+	TotalUs        int64  `json:"total_us"`
+	TLSVersion     string `json:"tls_version,omitempty"`
+	TLSCipherSuite string `json:"tls_cipher_suite,omitempty"`
+	TLSResumed     bool   `json:"tls_resumed"`
+}
+
+// OutageSummary is one connection outage.
+type OutageSummary struct {
+	Conn       int       `json:"conn"`
+	Start      time.Time `json:"start"`
+	DurationUs int64     `json:"duration_us"`
+	Error      string    `json:"error"`
+	Attempts   int       `json:"attempts"`
+	Recovered  bool      `json:"recovered"`
+	Unsent     int64     `json:"unsent"`
+}
+
+// MarshalJSON encodes the configuration with durations as Go duration
+// strings.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plainConfig Config
+	return json.Marshal(struct {
+		plainConfig
+		ReportInterval      string `json:"report_interval"`
+		ResponseTimeout     string `json:"response_timeout"`
+		RampUp              string `json:"ramp_up"`
+		BurstInterval       string `json:"burst_interval"`
+		PacerSpin           string `json:"pacer_spin"`
+		ClockProbeInterval  string `json:"clock_probe_interval"`
+		PingInterval        string `json:"ping_interval"`
+		ReconnectMinBackoff string `json:"reconnect_min_backoff"`
+		ReconnectMaxBackoff string `json:"reconnect_max_backoff"`
+	}{
+		plainConfig:         plainConfig(c),
+		ReportInterval:      c.ReportInterval.String(),
+		ResponseTimeout:     c.ResponseTimeout.String(),
+		RampUp:              c.RampUp.String(),
+		BurstInterval:       c.BurstInterval.String(),
+		PacerSpin:           c.PacerSpin.String(),
+		ClockProbeInterval:  c.ClockProbeInterval.String(),
+		PingInterval:        c.PingInterval.String(),
+		ReconnectMinBackoff: c.ReconnectMinBackoff.String(),
+		ReconnectMaxBackoff: c.ReconnectMaxBackoff.String(),
+	})
+}
+
+// newEnvironment describes the current machine and build.
+func newEnvironment() Environment {
+	hostname, _ := os.Hostname()
+	env := Environment{
+		Hostname:   hostname,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Args:       os.Args,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				env.Revision = setting.Value
+			}
+		}
+	}
+	return env
+}
+
+// summaryIfAny returns the summary of s, or nil if it has no samples.
+func summaryIfAny(s *stats.LatencyStats) *stats.Summary {
+	if s == nil || s.Count == 0 {
+		return nil
+	}
+	summary := s.Summary()
+	return &summary
+}
+
+// buildResult collects the result of the test that ran from start to end,
+// sending for sendDuration. The statistics must have been merged.
+func (c *Client) buildResult(start, end time.Time, sendDuration time.Duration) *Result {
+	counts := c.deliveryCounts()
+	r := &Result{
+		Config:         c.config,
+		Environment:    newEnvironment(),
+		Start:          start,
+		End:            end,
+		SendDuration:   sendDuration.Seconds(),
+		Delivery:       counts,
+		Lost:           counts.Lost(),
+		RTT:            c.stats.Summary(),
+		ServerDwell:    summaryIfAny(c.dwellStats),
+		NetworkClient:  summaryIfAny(c.pathStats),
+		ClientToServer: summaryIfAny(c.upStats),
+		ServerToClient: summaryIfAny(c.downStats),
+		PingRTT:        summaryIfAny(c.pingStats),
+		Handshakes:     make([]HandshakeSummary, 0, len(c.handshakes)),
+	}
+	if sendDuration > 0 {
+		r.SendRate = float64(counts.Sent) / sendDuration.Seconds()
+	}
+	if !c.config.ClosedLoop {
+		corrected := c.correctedStats.Summary()
+		r.CorrectedRTT = &corrected
+	}
+	if c.phased() {
+		for _, p := range c.phaseResults {
+			r.Phases = append(r.Phases, PhaseSummary{
+				Phase:        p.Phase,
+				MessageBytes: p.MessageBytes,
+				Sent:         p.Sent,
+				RTT:          p.Stats.Summary(),
+				CorrectedRTT: p.CorrectedStats.Summary(),
+			})
+		}
+	}
+	for i, t := range c.handshakes {
+		r.Handshakes = append(r.Handshakes, HandshakeSummary{
+			Conn:           i,
+			RemoteAddr:     t.RemoteAddr,
+			DNSUs:          t.DNS.Microseconds(),
+			TCPConnectUs:   t.TCPConnect.Microseconds(),
+			TLSHandshakeUs: t.TLSHandshake.Microseconds(),
+			UpgradeUs:      t.Upgrade.Microseconds(),
+			TotalUs:        t.Total.Microseconds(),
+			TLSVersion:     t.TLSVersion,
+			TLSCipherSuite: t.TLSCipherSuite,
+			TLSResumed:     t.TLSResumed,
+		})
+	}
+	for _, o := range c.GetOutages() {
+		r.Outages = append(r.Outages, OutageSummary{
+			Conn:       o.Conn,
+			Start:      o.Start,
+			DurationUs: o.Duration.Microseconds(),
+			Error:      o.Error,
+			Attempts:   o.Attempts,
+			Recovered:  o.Recovered,
+			Unsent:     o.Unsent,
+		})
+	}
+	if c.config.ClockProbeInterval > 0 {
+		r.ClockEstimates = c.clockEstimates
+	}
+	if c.config.PingInterval > 0 {
+		sent, received := c.pingCounts()
+		r.Pings = &PingCounts{Sent: sent, Received: received}
+	}
+	return r
+}
+
+// Write writes the result to w in the given format.
+func (r *Result) Write(w io.Writer, format string) error {
+	switch format {
+	case OutputJSON:
+		return r.WriteJSON(w)
+	case OutputCSV:
+		return r.WriteCSV(w)
+	default:
+		return fmt.Errorf("unknown output format %q (want %s or %s)", format, OutputJSON, OutputCSV)
+	}
+}
+
+// WriteJSON writes the result to w as an indented JSON document.
+func (r *Result) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("failed to write JSON result: %w", err)
+	}
+	return nil
+}
+
+// WriteCSV writes the result to w as CSV in long format, one value per row
+// with the columns section, name, field and value. Latency statistics appear
+// in the latency, percentile and bucket sections, named after the statistic;
+// percentile rows have the percentile as field, and bucket rows the bucket's
+// low-high range in microseconds with its count as value.
+func (r *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{{"section", "name", "field", "value"}}
+	add := func(section, name, field, value string) {
+		rows = append(rows, []string{section, name, field, value})
+	}
+	addFields := func(section, name string, v any) error {
+		fields, err := flattenJSON(v)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			add(section, name, f[0], f[1])
+		}
+		return nil
+	}
+	addSummary := func(s *stats.Summary) {
+		if s == nil {
+			return
+		}
+		add("latency", s.Name, "count", strconv.FormatInt(s.Count, 10))
+		add("latency", s.Name, "min_us", strconv.FormatInt(s.MinUs, 10))
+		add("latency", s.Name, "max_us", strconv.FormatInt(s.MaxUs, 10))
+		add("latency", s.Name, "mean_us", strconv.FormatFloat(s.MeanUs, 'f', 2, 64))
+		add("latency", s.Name, "stddev_us", strconv.FormatFloat(s.StdDevUs, 'f', 2, 64))
+		add("latency", s.Name, "clamped", strconv.FormatInt(s.Clamped, 10))
+		for _, p := range s.Percentiles {
+			add("percentile", s.Name, strconv.FormatFloat(p.Percentile, 'g', -1, 64), strconv.FormatInt(p.ValueUs, 10))
+		}
+		for _, b := range s.Buckets {
+			add("bucket", s.Name, fmt.Sprintf("%d-%d", b.LowUs, b.HighUs), strconv.FormatInt(b.Count, 10))
+		}
+	}
+
+	add("test", "", "start", r.Start.Format(time.RFC3339Nano))
+	add("test", "", "end", r.End.Format(time.RFC3339Nano))
+	add("test", "", "send_duration_s", strconv.FormatFloat(r.SendDuration, 'f', 3, 64))
+	add("test", "", "send_rate", strconv.FormatFloat(r.SendRate, 'f', 2, 64))
+	add("test", "", "lost", strconv.FormatInt(r.Lost, 10))
+	if err := addFields("config", "", r.Config); err != nil {
+		return err
+	}
+	if err := addFields("environment", "", r.Environment); err != nil {
+		return err
+	}
+	if err := addFields("delivery", "", r.Delivery); err != nil {
+		return err
+	}
+	for _, s := range []*stats.Summary{&r.RTT, r.CorrectedRTT, r.ServerDwell, r.NetworkClient, r.ClientToServer, r.ServerToClient, r.PingRTT} {
+		addSummary(s)
+	}
+	if r.Pings != nil {
+		if err := addFields("pings", "", *r.Pings); err != nil {
+			return err
+		}
+	}
+	for _, p := range r.Phases {
+		add("phase", p.Phase.Name, "payload_size", strconv.Itoa(p.Phase.PayloadSize))
+		add("phase", p.Phase.Name, "message_bytes", strconv.Itoa(p.MessageBytes))
+		add("phase", p.Phase.Name, "sent", strconv.FormatInt(p.Sent, 10))
+		addSummary(&p.RTT)
+		addSummary(&p.CorrectedRTT)
+	}
+	for _, h := range r.Handshakes {
+		if err := addFields("handshake", strconv.Itoa(h.Conn), h); err != nil {
+			return err
+		}
+	}
+	for _, o := range r.Outages {
+		if err := addFields("outage", strconv.Itoa(o.Conn), o); err != nil {
+			return err
+		}
+	}
+	for _, e := range r.ClockEstimates {
+		if err := addFields("clock", strconv.Itoa(e.Conn), e); err != nil {
+			return err
+		}
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write CSV result: %w", err)
+	}
+	return nil
+}
+
+// flattenJSON returns the fields of the JSON object v encodes to as sorted
+// name and value pairs. Strings are unquoted; other values, including
+// nested objects and arrays, are left as JSON.
+func flattenJSON(v any) ([][2]string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result fields: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to encode result fields: %w", err)
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([][2]string, 0, len(names))
+	for _, name := range names {
+		value := string(fields[name])
+		var s string
+		if json.Unmarshal(fields[name], &s) == nil {
+			value = s
+		}
+		pairs = append(pairs, [2]string{name, value})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/server"
+)
+
+func TestRunTestResult(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{}).Serve(listener)
+
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/ws",
+		MessageRate:        200,
+		TestDuration:       1,
+		PrewarmCount:       20,
+		HistogramPrecision: 3,
+		ResponseTimeout:    time.Second,
+		Connections:        2,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	result, err := c.RunTest()
+	if err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := result.Write(&buf, OutputJSON); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded struct {
+		Config struct {
+			MessageRate     int    `json:"message_rate"`
+			Connections     int    `json:"connections"`
+			ResponseTimeout string `json:"response_timeout"`
+		} `json:"config"`
+		Environment Environment    `json:"environment"`
+		Delivery    DeliveryCounts `json:"delivery"`
+		RTT         struct {
+			Count       int64 `json:"count"`
+			Percentiles []struct {
+				Percentile float64 `json:"percentile"`
+			} `json:"percentiles"`
+			Buckets []struct {
+				Count int64 `json:"count"`
+			} `json:"buckets"`
+		} `json:"rtt"`
+		Handshakes []HandshakeSummary `json:"handshakes"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding JSON result: %v\n%s", err, buf.String())
+	}
+	if decoded.Config.MessageRate != 200 || decoded.Config.Connections != 2 || decoded.Config.ResponseTimeout != "1s" {
+		t.Errorf("config = %+v, want rate 200, 2 connections, 1s timeout", decoded.Config)
+	}
+	if decoded.Environment.GoVersion == "" || decoded.Environment.CPUs == 0 {
+		t.Errorf("environment = %+v, want Go version and CPUs", decoded.Environment)
+	}
+	counts := c.GetDeliveryCounts()
+	if decoded.Delivery != counts || counts.Sent < 150 {
+		t.Errorf("delivery = %+v, want %+v with about 200 sent", decoded.Delivery, counts)
+	}
+	if decoded.RTT.Count != c.GetStats().Count || len(decoded.RTT.Percentiles) == 0 {
+		t.Errorf("rtt count = %d with %d percentiles, want %d", decoded.RTT.Count, len(decoded.RTT.Percentiles), c.GetStats().Count)
+	}
+	var bucketed int64
+	for _, b := range decoded.RTT.Buckets {
+		bucketed += b.Count
+	}
+	if bucketed != decoded.RTT.Count {
+		t.Errorf("buckets hold %d samples, want %d", bucketed, decoded.RTT.Count)
+	}
+	if len(decoded.Handshakes) != 2 || decoded.Handshakes[1].Conn != 1 || decoded.Handshakes[0].TotalUs <= 0 {
+		t.Errorf("handshakes = %+v, want 2 with timings", decoded.Handshakes)
+	}
+
+	buf.Reset()
+	if err := result.Write(&buf, OutputCSV); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV result: %v", err)
+	}
+	values := make(map[[3]string]string)
+	for _, row := range rows[1:] {
+		values[[3]string{row[0], row[1], row[2]}] = row[3]
+	}
+	for key, want := range map[[3]string]string{
+		{"config", "", "message_rate"}:     "200",
+		{"config", "", "response_timeout"}: "1s",
+		{"delivery", "", "sent"}:           strconv.FormatInt(counts.Sent, 10),
+		{"latency", "RTT", "count"}:        strconv.FormatInt(c.GetStats().Count, 10),
+		{"handshake", "1", "conn"}:         "1",
+	} {
+		if got := values[key]; got != want {
+			t.Errorf("CSV %v = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := values[[3]string{"percentile", "RTT", "99.9"}]; !ok {
+		t.Error("CSV lacks the RTT p99.9 row")
+	}
+
+	if err := result.Write(&buf, "xml"); err == nil {
+		t.Error("Write accepted an unknown format")
+	}
+}
+
+func TestRunSizeSweep(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.NewServer(server.Config{FastEcho: true}).Serve(listener)
+
+	template := filepath.Join(t.TempDir(), "book.json")
+	if err := os.WriteFile(template, []byte(`{"arg":{"channel":"books5"},"data":[{"asks":[["{{price}}","{{size}}"]],"ts":"{{ts}}"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(Config{
+		ServerURL:          "ws://" + listener.Addr().String() + "/ws",
+		MessageRate:        100,
+		TestDuration:       1,
+		PrewarmCount:       5,
+		HistogramPrecision: 3,
+		PayloadTemplate:    template,
+		SizeSweep:          []int{16, 64 << 10},
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	result, err := c.RunTest()
+	if err != nil {
+		t.Fatalf("RunTest: %v", err)
+	}
+
+	if len(result.Phases) != 3 {
+		t.Fatalf("got %d phases, want warm-up and two sizes", len(result.Phases))
+	}
+	small, large := result.Phases[1], result.Phases[2]
+	if small.Phase.PayloadSize != 16 || small.MessageBytes < 16 || small.MessageBytes > 256 || small.RTT.Count == 0 {
+		t.Errorf("16 B phase = %+v", small)
+	}
+	if large.Phase.PayloadSize != 64<<10 || large.MessageBytes < 64<<10-payloadSizeSlack || large.MessageBytes > 64<<10+payloadSizeSlack || large.RTT.Count == 0 {
+		t.Errorf("64 KiB phase = %+v", large)
+	}
+	if counts := c.GetDeliveryCounts(); counts.Lost() != 0 {
+		t.Errorf("delivery = %+v", counts)
+	}
+
+	// Templates need the JSON codec
+	c = NewClient(Config{ServerURL: "ws://" + listener.Addr().String() + "/ws", Codec: "binary", PayloadTemplate: template, TestDuration: 1})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.RunTest(); err == nil || !strings.Contains(err.Error(), "json codec") {
+		t.Errorf("RunTest with a template and the binary codec = %v", err)
+	}
+}
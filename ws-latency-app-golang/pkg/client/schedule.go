@@ -0,0 +1,227 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported arrival-process schedules for open-loop tests
+const (
+	ScheduleConstant = "constant"
+	SchedulePoisson  = "poisson"
+	ScheduleBurst    = "burst"
+	ScheduleTrace    = "trace"
+)
+
+// Schedule produces the intended send times of an open-loop test as offsets
+// from the start of the test.
+type Schedule interface {
+	// Next returns the offset of the next intended send. Offsets never
+	// decrease; equal offsets mean back-to-back sends. It returns false when
+	// the schedule is exhausted.
+	Next() (time.Duration, bool)
+}
+
+// constantSchedule sends one message every interval.
+type constantSchedule struct {
+	next     time.Duration
+	interval time.Duration
+}
+
+// Next implements Schedule
+func (s *constantSchedule) Next() (time.Duration, bool) {
+	offset := s.next
+	s.next += s.interval
+	return offset, true
+}
+
+// poissonSchedule sends messages with exponentially distributed gaps, so
+// arrivals form a Poisson process with the given mean interval.
+type poissonSchedule struct {
+	next         time.Duration
+	meanInterval time.Duration
+	rng          *rand.Rand
+}
+
+// Next implements Schedule
+func (s *poissonSchedule) Next() (time.Duration, bool) {
+	s.next += time.Duration(s.rng.ExpFloat64() * float64(s.meanInterval))
+	return s.next, true
+}
+
+// burstSchedule sends size messages back-to-back every period.
+type burstSchedule struct {
+	next   time.Duration
+	period time.Duration
+	size   int
+	sent   int
+}
+
+// Next implements Schedule
+func (s *burstSchedule) Next() (time.Duration, bool) {
+	if s.sent == s.size {
+		s.sent = 0
+		s.next += s.period
+	}
+	s.sent++
+	return s.next, true
+}
+
+// traceSchedule replays recorded send offsets. When the trace runs out it
+// starts over, shifted by the trace span plus one average gap, so a short
+// trace can drive a long test.
+type traceSchedule struct {
+	offsets []time.Duration
+	index   int
+	base    time.Duration
+	span    time.Duration
+}
+
+// Next implements Schedule
+func (s *traceSchedule) Next() (time.Duration, bool) {
+	if len(s.offsets) == 0 {
+		return 0, false
+	}
+	if s.index == len(s.offsets) {
+		s.index = 0
+		s.base += s.span
+	}
+	offset := s.base + s.offsets[s.index]
+	s.index++
+	return offset, true
+}
+
+// newTraceSchedule creates a trace schedule from offsets sorted in ascending
+// order.
+func newTraceSchedule(offsets []time.Duration) *traceSchedule {
+	s := &traceSchedule{offsets: offsets}
+	if n := len(offsets); n > 1 {
+		last := offsets[n-1]
+		s.span = last + last/time.Duration(n-1)
+	}
+	if s.span <= 0 {
+		// A single timestamp, or all timestamps equal: repeat once a second
+		s.span = time.Second
+	}
+	return s
+}
+
+// loadTrace reads a trace file with one send timestamp in microseconds per
+// line. Blank lines and lines starting with '#' are ignored. Timestamps may be
+// absolute or relative; they are returned sorted, as offsets from the first.
+func loadTrace(path string) ([]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open trace file: %w", err)
+	}
+	defer f.Close()
+
+	var timestamps []int64
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Allow CSV-style traces by using the first column
+		if i := strings.IndexAny(line, ", \t"); i >= 0 {
+			line = line[:i]
+		}
+		ts, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("trace file line %d: %w", lineNum, err)
+		}
+		timestamps = append(timestamps, ts)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read trace file: %w", err)
+	}
+	if len(timestamps) == 0 {
+		return nil, fmt.Errorf("trace file %s contains no timestamps", path)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	offsets := make([]time.Duration, len(timestamps))
+	for i, ts := range timestamps {
+		offsets[i] = time.Duration(ts-timestamps[0]) * time.Microsecond
+	}
+	return offsets, nil
+}
+
+// newSchedule creates the schedule for connection connID of numConns. The
+// aggregate load described by config is split across connections: constant
+// and Poisson rates are divided evenly, trace entries are dealt round-robin,
+// and every connection sends its own bursts.
+func newSchedule(config Config, trace []time.Duration, connID, numConns int) (Schedule, error) {
+	switch config.Schedule {
+	case "", ScheduleConstant:
+		if config.MessageRate <= 0 {
+			return nil, fmt.Errorf("message rate must be positive, got %d", config.MessageRate)
+		}
+		// Offset each connection by a fraction of the interval so sends from
+		// different connections interleave instead of bursting together
+		interval := time.Second * time.Duration(numConns) / time.Duration(config.MessageRate)
+		return &constantSchedule{
+			next:     interval * time.Duration(connID) / time.Duration(numConns),
+			interval: interval,
+		}, nil
+	case SchedulePoisson:
+		if config.MessageRate <= 0 {
+			return nil, fmt.Errorf("message rate must be positive, got %d", config.MessageRate)
+		}
+		return &poissonSchedule{
+			meanInterval: time.Second * time.Duration(numConns) / time.Duration(config.MessageRate),
+			rng:          rand.New(rand.NewSource(time.Now().UnixNano() + int64(connID))),
+		}, nil
+	case ScheduleBurst:
+		if config.BurstSize <= 0 || config.BurstInterval <= 0 {
+			return nil, fmt.Errorf("burst schedule needs a positive burst size and interval")
+		}
+		return &burstSchedule{
+			next:   config.BurstInterval * time.Duration(connID) / time.Duration(numConns),
+			period: config.BurstInterval,
+			size:   config.BurstSize,
+		}, nil
+	case ScheduleTrace:
+		if len(trace) == 0 {
+			return nil, fmt.Errorf("trace schedule needs a trace file")
+		}
+		var offsets []time.Duration
+		for i := connID; i < len(trace); i += numConns {
+			offsets = append(offsets, trace[i])
+		}
+		if len(offsets) == 0 {
+			return nil, fmt.Errorf("trace has %d entries, too few for %d connections", len(trace), numConns)
+		}
+		s := newTraceSchedule(offsets)
+		// Every connection wraps with the span of the whole trace so the
+		// replayed pattern stays aligned across connections
+		full := newTraceSchedule(trace)
+		s.span = full.span
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown schedule %q", config.Schedule)
+	}
+}
+
+// describeSchedule returns a short description of the configured load.
+func describeSchedule(config Config) string {
+	switch config.Schedule {
+	case SchedulePoisson:
+		return fmt.Sprintf("Poisson arrivals at %d msg/s", config.MessageRate)
+	case ScheduleBurst:
+		return fmt.Sprintf("bursts of %d messages every %v per connection", config.BurstSize, config.BurstInterval)
+	case ScheduleTrace:
+		return fmt.Sprintf("trace replay from %s", config.TraceFile)
+	default:
+		return fmt.Sprintf("rate %d msg/s", config.MessageRate)
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextOffsets(t *testing.T, s Schedule, n int) []time.Duration {
+	t.Helper()
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offset, ok := s.Next()
+		if !ok {
+			t.Fatalf("schedule exhausted after %d sends", i)
+		}
+		offsets[i] = offset
+	}
+	return offsets
+}
+
+func TestConstantScheduleSplitsRateAcrossConnections(t *testing.T) {
+	config := Config{MessageRate: 1000}
+	s, err := newSchedule(config, nil, 1, 4)
+	if err != nil {
+		t.Fatalf("newSchedule: %v", err)
+	}
+	got := nextOffsets(t, s, 3)
+	want := []time.Duration{time.Millisecond, 5 * time.Millisecond, 9 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("offset %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPoissonScheduleMeanRate(t *testing.T) {
+	s, err := newSchedule(Config{Schedule: SchedulePoisson, MessageRate: 1000}, nil, 0, 1)
+	if err != nil {
+		t.Fatalf("newSchedule: %v", err)
+	}
+	const n = 100000
+	offsets := nextOffsets(t, s, n)
+	mean := offsets[n-1] / n
+	if mean < 950*time.Microsecond || mean > 1050*time.Microsecond {
+		t.Errorf("mean interval = %v, want about 1ms", mean)
+	}
+}
+
+func TestBurstSchedule(t *testing.T) {
+	config := Config{Schedule: ScheduleBurst, BurstSize: 3, BurstInterval: 10 * time.Millisecond}
+	s, err := newSchedule(config, nil, 0, 1)
+	if err != nil {
+		t.Fatalf("newSchedule: %v", err)
+	}
+	got := nextOffsets(t, s, 7)
+	want := []time.Duration{0, 0, 0, 10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("offset %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestTraceScheduleRoundRobinAndWrap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.txt")
+	content := "# send timestamps\n1000300\n1000000\n\n1000100,extra\n1000200\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	trace, err := loadTrace(path)
+	if err != nil {
+		t.Fatalf("loadTrace: %v", err)
+	}
+
+	s, err := newSchedule(Config{Schedule: ScheduleTrace}, trace, 1, 2)
+	if err != nil {
+		t.Fatalf("newSchedule: %v", err)
+	}
+	// Connection 1 of 2 gets entries 1 and 3; the trace spans 300µs plus an
+	// average gap of 100µs before it repeats
+	got := nextOffsets(t, s, 4)
+	want := []time.Duration{100 * time.Microsecond, 300 * time.Microsecond, 500 * time.Microsecond, 700 * time.Microsecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("offset %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestNewScheduleRejectsInvalidConfig(t *testing.T) {
+	invalid := []Config{
+		{Schedule: ScheduleConstant},
+		{Schedule: SchedulePoisson},
+		{Schedule: ScheduleBurst, BurstSize: 0, BurstInterval: time.Second},
+		{Schedule: ScheduleTrace},
+		{Schedule: "sawtooth", MessageRate: 10},
+	}
+	for _, config := range invalid {
+		if _, err := newSchedule(config, nil, 0, 1); err == nil {
+			t.Errorf("newSchedule(%+v) succeeded, want error", config)
+		}
+	}
+}
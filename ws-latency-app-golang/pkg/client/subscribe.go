@@ -0,0 +1,192 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ws-latency-app-golang/pkg/stats"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subscription is a channel of an instrument to subscribe to, and the topic
+// pushed events are counted under.
+type Subscription struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+// String returns the subscription as channel:instId.
+func (s Subscription) String() string {
+	return s.Channel + ":" + s.InstID
+}
+
+// ParseSubscriptions parses a comma-separated list of channel:instId entries,
+// e.g. "tickers:BTC-USDT,trades:BTC-USDT".
+func ParseSubscriptions(spec string) ([]Subscription, error) {
+	var subs []Subscription
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		channel, instID, ok := strings.Cut(entry, ":")
+		if !ok || channel == "" || instID == "" {
+			return nil, fmt.Errorf("subscription %q: want channel:instId", entry)
+		}
+		subs = append(subs, Subscription{Channel: channel, InstID: instID})
+	}
+	return subs, nil
+}
+
+// ChannelResult summarizes the events received for one subscription.
+type ChannelResult struct {
+	Topic      Subscription
+	Events     int64
+	Gaps       int64
+	Missed     int64
+	OutOfOrder int64
+	Latency    *stats.LatencyStats
+}
+
+// topicState is the receive state of one subscription on one connection.
+type topicState struct {
+	seen                             bool // Whether an event has been received
+	lastSeq                          uint64
+	events, gaps, missed, outOfOrder int64
+	latency                          *stats.LatencyStats
+}
+
+// opMessage is a subscription request or response.
+type opMessage struct {
+	ID    string         `json:"id,omitempty"`
+	Op    string         `json:"op,omitempty"`
+	Args  []Subscription `json:"args,omitempty"`
+	Event string         `json:"event,omitempty"`
+	Arg   *Subscription  `json:"arg,omitempty"`
+	Code  string         `json:"code,omitempty"`
+	Msg   string         `json:"msg,omitempty"`
+}
+
+// requestIDs numbers subscription requests.
+var requestIDs atomic.Int64
+
+// sendOp sends a subscribe or unsubscribe request for subs, recording each
+// argument as awaiting acknowledgement.
+func (cn *connection) sendOp(conn *websocket.Conn, r *receiver, op string, subs []Subscription) error {
+	message, err := json.Marshal(opMessage{ID: strconv.FormatInt(requestIDs.Add(1), 10), Op: op, Args: subs})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %w", op, err)
+	}
+
+	sendUs := time.Now().UnixNano() / 1000
+	r.mu.Lock()
+	for _, sub := range subs {
+		r.pending[op+" "+sub.String()] = sendUs
+	}
+	r.mu.Unlock()
+	return cn.write(conn, message)
+}
+
+// handleOpResponse records the acknowledgement latency of a subscribe or
+// unsubscribe response, or counts an error response.
+func (r *receiver) handleOpResponse(response opMessage, recvUs int64) {
+	op := response.Event
+	if op == "error" {
+		r.subscribeErrors.Add(1)
+		if response.Arg == nil {
+			log.Printf("Subscription error %s: %s", response.Code, response.Msg)
+			return
+		}
+		log.Printf("Subscription to %s failed with error %s: %s", response.Arg, response.Code, response.Msg)
+		op = "subscribe"
+	}
+	if response.Arg == nil {
+		return
+	}
+
+	key := op + " " + response.Arg.String()
+	r.mu.Lock()
+	sendUs, ok := r.pending[key]
+	delete(r.pending, key)
+	r.mu.Unlock()
+	if !ok || response.Event == "error" {
+		return
+	}
+	latency := max(recvUs-sendUs, 0)
+	if op == "subscribe" {
+		r.subscribeAcks.AddSample(latency)
+	} else {
+		r.unsubscribeAcks.AddSample(latency)
+	}
+}
+
+// awaitAcks waits until every request of r is acknowledged or deadline
+// passes, and returns the number still unacknowledged.
+func (r *receiver) awaitAcks(deadline time.Time) int {
+	for {
+		r.mu.Lock()
+		pending := len(r.pending)
+		r.mu.Unlock()
+		if pending == 0 || time.Now().After(deadline) {
+			return pending
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// mergeChannels combines the per-subscription state of all connections,
+// sorted by channel and instrument.
+func mergeChannels(receivers []*receiver, precision int) []ChannelResult {
+	merged := make(map[Subscription]*ChannelResult)
+	for _, r := range receivers {
+		r.mu.Lock()
+		for sub, t := range r.topics {
+			c, ok := merged[sub]
+			if !ok {
+				c = &ChannelResult{Topic: sub, Latency: stats.NewLatencyStats(sub.String(), precision)}
+				merged[sub] = c
+			}
+			c.Events += t.events
+			c.Gaps += t.gaps
+			c.Missed += t.missed
+			c.OutOfOrder += t.outOfOrder
+			c.Latency.Merge(t.latency.Snapshot(false))
+		}
+		r.mu.Unlock()
+	}
+
+	results := make([]ChannelResult, 0, len(merged))
+	for _, c := range merged {
+		c.Latency.Calculate()
+		results = append(results, *c)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].Topic, results[j].Topic
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.InstID < b.InstID
+	})
+	return results
+}
+
+// printSubscriptionResults prints the acknowledgement latency of subscription
+// requests and the events received per subscription.
+func printSubscriptionResults(r *ReceiveResult) {
+	log.Println("===== Subscriptions (µs) =====")
+	log.Printf("Subscribe acks:   %d p50=%d p99=%d max=%d\n", r.SubscribeAck.Count, r.SubscribeAck.P50, r.SubscribeAck.P99, r.SubscribeAck.Max)
+	log.Printf("Unsubscribe acks: %d p50=%d p99=%d max=%d\n", r.UnsubscribeAck.Count, r.UnsubscribeAck.P50, r.UnsubscribeAck.P99, r.UnsubscribeAck.Max)
+	log.Printf("Errors:           %d\n", r.SubscribeErrors)
+	for _, c := range r.Channels {
+		log.Printf("%-24s events=%d gaps=%d missed=%d ooo=%d | Latency p50=%d p99=%d p99.9=%d max=%d\n",
+			c.Topic, c.Events, c.Gaps, c.Missed, c.OutOfOrder,
+			c.Latency.P50, c.Latency.P99, c.Latency.P999, c.Latency.Max)
+	}
+}
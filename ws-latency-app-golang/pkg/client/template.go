@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templatePlaceholders are the placeholders of payload templates, each
+// appending a random value when messages are rendered. Values are written
+// as they are, so a template quotes them if it wants strings.
+var templatePlaceholders = map[string]func(b []byte) []byte{
+	// Price like the ticker's, e.g. 105926.1
+	"price": func(b []byte) []byte {
+		b = strconv.AppendInt(b, int64(100000+rand.Intn(10000)), 10)
+		b = append(b, '.')
+		return strconv.AppendInt(b, int64(rand.Intn(10)), 10)
+	},
+	// Quantity with eight decimals, e.g. 0.04848602
+	"size": func(b []byte) []byte {
+		b = append(b, "0."...)
+		return append(b, fmt.Sprintf("%08d", rand.Intn(100000000))...)
+	},
+	// Integer from 0 to 999999
+	"int": func(b []byte) []byte {
+		return strconv.AppendInt(b, int64(rand.Intn(1000000)), 10)
+	},
+	// Millisecond Unix time of rendering
+	"ts": func(b []byte) []byte {
+		return strconv.AppendInt(b, time.Now().UnixMilli(), 10)
+	},
+}
+
+// payloadTemplate is a JSON object sent in place of the ticker, with
+// {{name}} placeholders for random values.
+type payloadTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is literal text or, if fill is set, a placeholder.
+type templatePart struct {
+	text []byte
+	fill func(b []byte) []byte
+}
+
+// loadPayloadTemplate reads a payload template from a file.
+func loadPayloadTemplate(path string) (*payloadTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read payload template: %w", err)
+	}
+	t, err := parsePayloadTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("payload template %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// parsePayloadTemplate splits a template into text and placeholders, and
+// checks that it renders to a JSON object without the message's own
+// "_test" and "_pad" keys.
+func parsePayloadTemplate(data []byte) (*payloadTemplate, error) {
+	t := &payloadTemplate{}
+	for len(data) > 0 {
+		start := bytes.Index(data, []byte("{{"))
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{text: data})
+			break
+		}
+		end := bytes.Index(data[start:], []byte("}}"))
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder at byte %d", start)
+		}
+		name := strings.TrimSpace(string(data[start+2 : start+end]))
+		fill, ok := templatePlaceholders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {{%s}}, want one of %s", name, placeholderNames())
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{text: data[:start]})
+		}
+		t.parts = append(t.parts, templatePart{fill: fill})
+		data = data[start+end+2:]
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(t.render(nil), &object); err != nil {
+		return nil, fmt.Errorf("not a JSON object: %w", err)
+	}
+	for _, key := range []string{"_test", "_pad"} {
+		if _, ok := object[key]; ok {
+			return nil, fmt.Errorf("template has the reserved key %q", key)
+		}
+	}
+	return t, nil
+}
+
+// render appends the template to dst with new random values.
+func (t *payloadTemplate) render(dst []byte) []byte {
+	for _, p := range t.parts {
+		if p.fill != nil {
+			dst = p.fill(dst)
+		} else {
+			dst = append(dst, p.text...)
+		}
+	}
+	return dst
+}
+
+// placeholderNames returns the placeholder names for error messages.
+func placeholderNames() string {
+	var names []string
+	for name := range templatePlaceholders {
+		names = append(names, "{{"+name+"}}")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
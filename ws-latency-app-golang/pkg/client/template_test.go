@@ -0,0 +1,74 @@
+package client
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPayloadTemplate(t *testing.T) {
+	tmpl, err := parsePayloadTemplate([]byte(`{"bids": [["{{price}}", "{{ size }}", {{int}}]], "ts": {{ts}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Bids [][]interface{} `json:"bids"`
+		Ts   int64           `json:"ts"`
+	}
+	first := tmpl.render(nil)
+	if err := json.Unmarshal(first, &doc); err != nil {
+		t.Fatalf("rendered %s: %v", first, err)
+	}
+	price, size := doc.Bids[0][0].(string), doc.Bids[0][1].(string)
+	if !strings.Contains(price, ".") || !strings.HasPrefix(size, "0.") || len(size) != 10 || doc.Ts == 0 {
+		t.Errorf("rendered %s", first)
+	}
+	if second := tmpl.render(nil); string(second) == string(first) {
+		t.Errorf("two renderings are the same: %s", first)
+	}
+
+	for _, bad := range []string{
+		`{"price": {{cost}}}`,
+		`{"price": {{price}`,
+		`[{{price}}]`,
+		`{"price": {{price}}`,
+		`{"_test": {}}`,
+	} {
+		if _, err := parsePayloadTemplate([]byte(bad)); err == nil {
+			t.Errorf("template %s accepted", bad)
+		}
+	}
+}
+
+func TestPayloadTemplateMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.json")
+	if err := os.WriteFile(path, []byte(`{"arg": {"channel": "books"}, "data": [{"asks": [["{{price}}", "{{size}}"]]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadPayloadTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn := newConnection(0, Config{})
+	cn.template = tmpl
+	if err := cn.setPayloadSize(1024); err != nil {
+		t.Fatal(err)
+	}
+	message, _ := cn.payloads.take()
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(message, &doc); err != nil {
+		t.Fatalf("message %s: %v", message, err)
+	}
+	if string(doc["arg"]) != `{"channel": "books"}` || doc["_test"] == nil || doc["_pad"] == nil {
+		t.Errorf("message %s", message)
+	}
+	if diff := len(message) - 1024; diff < -payloadSizeSlack || diff > payloadSizeSlack {
+		t.Errorf("message is %d bytes, want about 1024", len(message))
+	}
+
+	if _, err := loadPayloadTemplate(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing template loaded")
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsSessionCacheSize is the number of TLS sessions kept for resumption.
+const tlsSessionCacheSize = 1024
+
+// tlsVersions maps the accepted version names to their constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the client TLS configuration for wss:// connections.
+// All connections share one session cache, so with resumption enabled every
+// connection after the first can resume instead of doing a full handshake.
+func newTLSConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		ServerName:         config.TLSServerName,
+	}
+
+	if config.TLSCAFile != "" {
+		pem, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseTLSVersion(config.TLSMinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(config.TLSMaxVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return nil, fmt.Errorf("TLS min version %s is above max version %s", config.TLSMinVersion, config.TLSMaxVersion)
+	}
+
+	if len(config.TLSCipherSuites) > 0 {
+		if tlsConfig.CipherSuites, err = parseCipherSuites(config.TLSCipherSuites); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DisableTLSResumption {
+		tlsConfig.SessionTicketsDisabled = true
+	} else {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(tlsSessionCacheSize)
+	}
+	return tlsConfig, nil
+}
+
+// parseTLSVersion converts a version such as "1.2" to its constant. An empty
+// string returns 0, leaving the choice to crypto/tls.
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q (want 1.0, 1.1, 1.2 or 1.3)", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites converts IANA cipher suite names, such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, to their IDs. Go does not allow TLS
+// 1.3 suites to be configured, so they only apply up to TLS 1.2.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[suite.Name] = suite.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTLSEchoServer starts a wss:// server that echoes every message. If
+// clientCAs is set, clients must present a certificate signed by it.
+func newTLSEchoServer(t *testing.T, clientCAs *x509.CertPool) (*httptest.Server, string) {
+	srv := httptest.NewUnstartedServer(testHandler(func(conn *websocket.Conn) {
+		echo(conn, 0, nil)
+	}))
+	if clientCAs != nil {
+		srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	// Trust the server's self-signed certificate through a CA file
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	return srv, caFile
+}
+
+// writePEM writes a single PEM block to path.
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newClientCert writes a self-signed client certificate and key and returns
+// their paths and the certificate.
+func newClientCert(t *testing.T) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ws-latency-test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// wssURL returns the wss:// URL of a test server.
+func wssURL(srv *httptest.Server) string {
+	return "wss" + strings.TrimPrefix(srv.URL, "https")
+}
+
+func TestTLSResumption(t *testing.T) {
+	srv, caFile := newTLSEchoServer(t, nil)
+
+	for _, disable := range []bool{false, true} {
+		c := NewClient(Config{ServerURL: wssURL(srv), Connections: 3, TLSCAFile: caFile, DisableTLSResumption: disable})
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect: %v", err)
+		}
+		c.Close()
+
+		for i, h := range c.GetHandshakeTimings() {
+			if h.TLSVersion == "" || h.TLSCipherSuite == "" || h.TLSHandshake <= 0 {
+				t.Errorf("connection %d: TLS not recorded: %+v", i, h)
+			}
+			wantResumed := !disable && i > 0
+			if h.TLSResumed != wantResumed {
+				t.Errorf("resumption disabled=%v, connection %d: resumed = %v, want %v", disable, i, h.TLSResumed, wantResumed)
+			}
+		}
+	}
+}
+
+func TestTLSVerification(t *testing.T) {
+	srv, caFile := newTLSEchoServer(t, nil)
+
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"system roots", Config{}, true},
+		{"insecure", Config{InsecureSkipVerify: true}, false},
+		{"CA file", Config{TLSCAFile: caFile}, false},
+		{"SNI override", Config{TLSCAFile: caFile, TLSServerName: "example.com"}, false},
+		{"wrong SNI", Config{TLSCAFile: caFile, TLSServerName: "wrong.example"}, true},
+		{"max version", Config{TLSCAFile: caFile, TLSMaxVersion: "1.2", TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}, false},
+	}
+	for _, tt := range tests {
+		tt.config.ServerURL = wssURL(srv)
+		c := NewClient(tt.config)
+		err := c.Connect()
+		c.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && tt.config.TLSMaxVersion == "1.2" {
+			h := c.GetHandshakeTimings()[0]
+			if h.TLSVersion != "TLS 1.2" || h.TLSCipherSuite != tt.config.TLSCipherSuites[0] {
+				t.Errorf("%s: negotiated %s %s", tt.name, h.TLSVersion, h.TLSCipherSuite)
+			}
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile, cert := newClientCert(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	srv, caFile := newTLSEchoServer(t, clientCAs)
+
+	c := NewClient(Config{ServerURL: wssURL(srv), TLSCAFile: caFile})
+	if err := c.Connect(); err == nil {
+		c.Close()
+		t.Error("connected without a client certificate")
+	}
+
+	c = NewClient(Config{ServerURL: wssURL(srv), TLSCAFile: caFile, TLSCertFile: certFile, TLSKeyFile: keyFile})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect with client certificate: %v", err)
+	}
+	c.Close()
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	for _, config := range []Config{
+		{TLSCAFile: "/nonexistent/ca.pem"},
+		{TLSCertFile: "client.pem"},
+		{TLSMinVersion: "1.4"},
+		{TLSMinVersion: "1.3", TLSMaxVersion: "1.2"},
+		{TLSCipherSuites: []string{"TLS_NOT_A_SUITE"}},
+	} {
+		if _, err := newTLSConfig(config); err == nil {
+			t.Errorf("newTLSConfig(%+v) succeeded, want error", config)
+		}
+	}
+
+	if v, err := parseTLSVersion("TLS1.3"); err != nil || v != tls.VersionTLS13 {
+		t.Errorf("parseTLSVersion(TLS1.3) = %x, %v", v, err)
+	}
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"sync"
+)
+
+// DeliveryCounts summarizes what happened to the messages sent during a test.
+type DeliveryCounts struct {
+	Sent       int64 `json:"sent"`         // Messages written to the connection
+	Received   int64 `json:"received"`     // Responses matched to an in-flight message, including late ones
+	TimedOut   int64 `json:"timed_out"`    // Messages with no response within the response timeout
+	Late       int64 `json:"late"`         // Responses that arrived after their message had timed out
+	Duplicated int64 `json:"duplicated"`   // Responses for a message that was already answered
+	OutOfOrder int64 `json:"out_of_order"` // Responses that arrived after a response with a higher sequence
+	Unknown    int64 `json:"unknown"`      // Responses carrying a sequence that was never sent
+	Unsent     int64 `json:"unsent"`       // Scheduled messages not sent because the connection was down
+}
+
+// Lost returns the number of messages that timed out and never arrived.
+func (d DeliveryCounts) Lost() int64 {
+	return d.TimedOut - d.Late
+}
+
+// sub returns the counts accumulated since prev.
+func (d DeliveryCounts) sub(prev DeliveryCounts) DeliveryCounts {
+	return DeliveryCounts{
+		Sent:       d.Sent - prev.Sent,
+		Received:   d.Received - prev.Received,
+		TimedOut:   d.TimedOut - prev.TimedOut,
+		Late:       d.Late - prev.Late,
+		Duplicated: d.Duplicated - prev.Duplicated,
+		OutOfOrder: d.OutOfOrder - prev.OutOfOrder,
+		Unknown:    d.Unknown - prev.Unknown,
+		Unsent:     d.Unsent - prev.Unsent,
+	}
+}
+
+// add returns the sum of both counts.
+func (d DeliveryCounts) add(other DeliveryCounts) DeliveryCounts {
+	return DeliveryCounts{
+		Sent:       d.Sent + other.Sent,
+		Received:   d.Received + other.Received,
+		TimedOut:   d.TimedOut + other.TimedOut,
+		Late:       d.Late + other.Late,
+		Duplicated: d.Duplicated + other.Duplicated,
+		OutOfOrder: d.OutOfOrder + other.OutOfOrder,
+		Unknown:    d.Unknown + other.Unknown,
+		Unsent:     d.Unsent + other.Unsent,
+	}
+}
+
+// receiveStatus classifies a response looked up in the in-flight table.
+type receiveStatus int
+
+const (
+	receiveOnTime receiveStatus = iota
+	receiveLate
+	receiveDuplicate
+	receiveUnknown
+)
+
+// inflightMessage records the send times and load-profile phase of a message
+// awaiting its response.
+type inflightMessage struct {
+	phase      int
+	intendedUs int64
+	sendUs     int64
+}
+
+// timedOutRetentionFactor controls how long timed-out sequences are
+// remembered, as a multiple of the response timeout, so late responses can be
+// told apart from duplicates.
+const timedOutRetentionFactor = 10
+
+// inflightTracker is the client's in-flight table. It matches responses to
+// sent messages by sequence number and classifies each response as on time,
+// late, duplicated or unknown.
+type inflightTracker struct {
+	mu              sync.Mutex
+	pending         map[uint64]inflightMessage
+	timedOut        map[uint64]timedOutMessage
+	nextSequence    uint64
+	highestReceived uint64
+	anyReceived     bool
+	counts          DeliveryCounts
+}
+
+// timedOutMessage is an in-flight message that passed the response timeout.
+type timedOutMessage struct {
+	inflightMessage
+	expiredUs int64
+}
+
+// newInflightTracker creates an empty in-flight table.
+func newInflightTracker() *inflightTracker {
+	return &inflightTracker{
+		pending:  make(map[uint64]inflightMessage),
+		timedOut: make(map[uint64]timedOutMessage),
+	}
+}
+
+// add registers a message as in flight. It must be called before the message
+// is written so a fast response cannot arrive ahead of its registration.
+func (t *inflightTracker) add(seq uint64, phase int, intendedUs, sendUs int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[seq] = inflightMessage{phase: phase, intendedUs: intendedUs, sendUs: sendUs}
+	if seq >= t.nextSequence {
+		t.nextSequence = seq + 1
+	}
+	t.counts.Sent++
+}
+
+// setSent sets the send time of a registered message that has not been
+// written yet, and its intended send time too if unscheduled.
+func (t *inflightTracker) setSent(seq uint64, sendUs int64, unscheduled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	msg, ok := t.pending[seq]
+	if !ok {
+		return
+	}
+	msg.sendUs = sendUs
+	if unscheduled {
+		msg.intendedUs = sendUs
+	}
+	t.pending[seq] = msg
+}
+
+// remove withdraws a message that could not be written.
+func (t *inflightTracker) remove(seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[seq]; ok {
+		delete(t.pending, seq)
+		t.counts.Sent--
+	}
+}
+
+// addUnsent counts a scheduled message that could not be sent.
+func (t *inflightTracker) addUnsent() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts.Unsent++
+}
+
+// receive looks up the message a response belongs to and classifies it.
+func (t *inflightTracker) receive(seq uint64) (inflightMessage, receiveStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg, ok := t.pending[seq]
+	status := receiveOnTime
+	if ok {
+		delete(t.pending, seq)
+	} else if late, wasTimedOut := t.timedOut[seq]; wasTimedOut {
+		delete(t.timedOut, seq)
+		msg = late.inflightMessage
+		status = receiveLate
+		t.counts.Late++
+	} else if seq < t.nextSequence {
+		t.counts.Duplicated++
+		return inflightMessage{}, receiveDuplicate
+	} else {
+		t.counts.Unknown++
+		return inflightMessage{}, receiveUnknown
+	}
+
+	t.counts.Received++
+	if t.anyReceived && seq < t.highestReceived {
+		t.counts.OutOfOrder++
+	}
+	if !t.anyReceived || seq > t.highestReceived {
+		t.highestReceived = seq
+		t.anyReceived = true
+	}
+	return msg, status
+}
+
+// lostFunc is called with each timed-out message that is forgotten without
+// having been answered.
+type lostFunc func(seq uint64, msg inflightMessage)
+
+// expire marks messages sent more than timeoutUs before nowUs as timed out,
+// and forgets timed-out messages that are too old to still arrive, passing
+// them to lost unless it is nil. It returns the number of newly timed-out
+// messages.
+func (t *inflightTracker) expire(nowUs, timeoutUs int64, lost lostFunc) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := 0
+	for seq, msg := range t.pending {
+		if nowUs-msg.sendUs >= timeoutUs {
+			delete(t.pending, seq)
+			t.timedOut[seq] = timedOutMessage{inflightMessage: msg, expiredUs: nowUs}
+			expired++
+		}
+	}
+	t.counts.TimedOut += int64(expired)
+
+	for seq, msg := range t.timedOut {
+		if nowUs-msg.expiredUs >= timeoutUs*timedOutRetentionFactor {
+			delete(t.timedOut, seq)
+			if lost != nil {
+				lost(seq, msg.inflightMessage)
+			}
+		}
+	}
+	return expired
+}
+
+// expireAll marks every message still in flight as timed out and returns
+// their number.
+func (t *inflightTracker) expireAll(nowUs int64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expired := len(t.pending)
+	for seq, msg := range t.pending {
+		delete(t.pending, seq)
+		t.timedOut[seq] = timedOutMessage{inflightMessage: msg, expiredUs: nowUs}
+	}
+	t.counts.TimedOut += int64(expired)
+	return expired
+}
+
+// forgetTimedOut forgets every timed-out message once no more responses are
+// expected, passing them to lost unless it is nil.
+func (t *inflightTracker) forgetTimedOut(lost lostFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for seq, msg := range t.timedOut {
+		delete(t.timedOut, seq)
+		if lost != nil {
+			lost(seq, msg.inflightMessage)
+		}
+	}
+}
+
+// outstanding returns the number of messages still awaiting a response.
+func (t *inflightTracker) outstanding() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// snapshot returns the current delivery counts.
+func (t *inflightTracker) snapshot() DeliveryCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.counts
+}
@@ -0,0 +1,108 @@
+package client
+
+import "testing"
+
+func TestInflightTrackerClassifiesResponses(t *testing.T) {
+	tr := newInflightTracker()
+	for seq := uint64(0); seq < 5; seq++ {
+		tr.add(seq, 0, int64(seq)*100, int64(seq)*100+1)
+	}
+
+	if _, status := tr.receive(0); status != receiveOnTime {
+		t.Errorf("receive(0) status = %v, want on time", status)
+	}
+	if _, status := tr.receive(2); status != receiveOnTime {
+		t.Errorf("receive(2) status = %v, want on time", status)
+	}
+	// 1 arrives after 2: out of order but still on time
+	msg, status := tr.receive(1)
+	if status != receiveOnTime || msg.sendUs != 101 {
+		t.Errorf("receive(1) = %+v, %v, want sendUs 101 on time", msg, status)
+	}
+	if _, status := tr.receive(1); status != receiveDuplicate {
+		t.Errorf("second receive(1) status = %v, want duplicate", status)
+	}
+	if _, status := tr.receive(99); status != receiveUnknown {
+		t.Errorf("receive(99) status = %v, want unknown", status)
+	}
+
+	// 3 and 4 time out; 3 then arrives late
+	if n := tr.expire(10000, 1000, nil); n != 2 {
+		t.Errorf("expire() = %d, want 2", n)
+	}
+	if _, status := tr.receive(3); status != receiveLate {
+		t.Errorf("receive(3) status = %v, want late", status)
+	}
+
+	got := tr.snapshot()
+	want := DeliveryCounts{Sent: 5, Received: 4, TimedOut: 2, Late: 1, Duplicated: 1, OutOfOrder: 1, Unknown: 1}
+	if got != want {
+		t.Errorf("snapshot() = %+v, want %+v", got, want)
+	}
+	if got.Lost() != 1 {
+		t.Errorf("Lost() = %d, want 1", got.Lost())
+	}
+}
+
+func TestInflightTrackerRemoveAndExpireAll(t *testing.T) {
+	tr := newInflightTracker()
+	tr.add(0, 0, 0, 0)
+	tr.add(1, 0, 0, 0)
+	tr.remove(1)
+	if tr.outstanding() != 1 {
+		t.Errorf("outstanding() = %d, want 1", tr.outstanding())
+	}
+
+	tr.expireAll(0)
+	got := tr.snapshot()
+	if got.Sent != 1 || got.TimedOut != 1 || tr.outstanding() != 0 {
+		t.Errorf("after expireAll: %+v, outstanding %d", got, tr.outstanding())
+	}
+}
+
+func TestInflightTrackerReportsLost(t *testing.T) {
+	tr := newInflightTracker()
+	tr.add(0, 0, 0, 0)
+	tr.add(1, 0, 0, 0)
+	tr.add(2, 0, 10_500, 10_500)
+	lost := make(map[uint64]bool)
+	record := func(seq uint64, _ inflightMessage) { lost[seq] = true }
+
+	// 0 and 1 time out, and 0 then arrives late: only 1 is lost, once it is
+	// too old to still arrive
+	tr.expire(1000, 1000, record)
+	tr.receive(0)
+	if tr.expire(5000, 1000, record); len(lost) != 0 {
+		t.Errorf("lost %v before the retention period", lost)
+	}
+	tr.expire(11_000, 1000, record)
+	if len(lost) != 1 || !lost[1] {
+		t.Errorf("lost %v, want 1", lost)
+	}
+
+	// 2 is never answered
+	tr.expireAll(11_000)
+	tr.forgetTimedOut(record)
+	if len(lost) != 2 || !lost[2] {
+		t.Errorf("lost %v, want 1 and 2", lost)
+	}
+}
+
+func TestInflightTrackerSetSent(t *testing.T) {
+	tr := newInflightTracker()
+	tr.add(0, 0, 100, 110)
+	tr.add(1, 0, 200, 200)
+	tr.setSent(0, 150, false)
+	tr.setSent(1, 250, true)
+	tr.setSent(2, 300, false) // Not registered
+
+	if msg, _ := tr.receive(0); msg.intendedUs != 100 || msg.sendUs != 150 {
+		t.Errorf("receive(0) = %+v, want intended 100 and sent 150", msg)
+	}
+	if msg, _ := tr.receive(1); msg.intendedUs != 250 || msg.sendUs != 250 {
+		t.Errorf("receive(1) = %+v, want intended and sent 250", msg)
+	}
+	if tr.outstanding() != 0 {
+		t.Errorf("outstanding() = %d, want 0", tr.outstanding())
+	}
+}
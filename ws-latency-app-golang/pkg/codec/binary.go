@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Binary is a raw frame with a fixed 48-byte little-endian header followed
+// by an opaque payload, which carries the padding. The ticker is not sent.
+//
+//	0  uint8  version (1)
+//	1  uint8  flags (bit 0: probe)
+//	2  [6]byte reserved
+//	8  uint64 sequence
+//	16 int64  client intended send time
+//	24 int64  client send time
+//	32 int64  server receive time
+//	40 int64  server send time
+//	48 payload
+var Binary Codec = binaryCodec{}
+
+type binaryCodec struct{}
+
+// Binary frame layout
+const (
+	BinaryVersion           = 1
+	BinaryHeaderSize        = 48
+	BinaryServerRecvAt      = 32 // Offset of the server receive time
+	BinaryServerSendAt      = 40 // Offset of the server send time
+	binaryFlagProbe    byte = 1
+)
+
+func (binaryCodec) Name() string     { return "binary" }
+func (binaryCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (c binaryCodec) Append(b []byte, m *Message) ([]byte, error) {
+	b, _, err := c.AppendTemplate(b, m)
+	return b, err
+}
+
+func (c binaryCodec) AppendTemplate(b []byte, m *Message) ([]byte, Slots, error) {
+	if len(m.Body) > 0 {
+		return b, Slots{}, errBody(c)
+	}
+	var flags byte
+	if m.Probe {
+		flags |= binaryFlagProbe
+	}
+	start := len(b)
+	slots := Slots{
+		sequence:   slot{at: start + 8},
+		intended:   slot{at: start + 16},
+		send:       slot{at: start + 24},
+		serverSend: slot{at: start + BinaryServerSendAt},
+	}
+	b = append(b, BinaryVersion, flags, 0, 0, 0, 0, 0, 0)
+	b = binary.LittleEndian.AppendUint64(b, m.Sequence)
+	b = binary.LittleEndian.AppendUint64(b, uint64(m.ClientIntendedUs))
+	b = binary.LittleEndian.AppendUint64(b, uint64(m.ClientSendUs))
+	b = binary.LittleEndian.AppendUint64(b, uint64(m.ServerRecvUs))
+	b = binary.LittleEndian.AppendUint64(b, uint64(m.ServerSendUs))
+	return append(b, m.Pad...), slots, nil
+}
+
+func (binaryCodec) Decode(data []byte, m *Message) error {
+	if len(data) < BinaryHeaderSize {
+		return fmt.Errorf("%w: binary frame of %d bytes is shorter than its header", ErrMalformed, len(data))
+	}
+	if data[0] != BinaryVersion {
+		return fmt.Errorf("%w: binary frame version %d", ErrMalformed, data[0])
+	}
+	*m = Message{
+		Probe:            data[1]&binaryFlagProbe != 0,
+		Sequence:         binary.LittleEndian.Uint64(data[8:]),
+		ClientIntendedUs: int64(binary.LittleEndian.Uint64(data[16:])),
+		ClientSendUs:     int64(binary.LittleEndian.Uint64(data[24:])),
+		ServerRecvUs:     int64(binary.LittleEndian.Uint64(data[BinaryServerRecvAt:])),
+		ServerSendUs:     int64(binary.LittleEndian.Uint64(data[BinaryServerSendAt:])),
+		Pad:              append(m.Pad[:0], data[BinaryHeaderSize:]...),
+	}
+	return nil
+}
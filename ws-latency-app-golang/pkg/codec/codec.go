@@ -0,0 +1,168 @@
+// Package codec encodes latency test messages in the wire formats shared by
+// the client and server. The format is negotiated per connection with the
+// Sec-WebSocket-Protocol header; connections without one use JSON, so
+// clients and servers predating codecs keep working.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SubprotocolPrefix prefixes codec names to form their WebSocket subprotocol
+const SubprotocolPrefix = "wslatency."
+
+// Message is a latency test message: a ticker update carrying the test
+// sequence number and timestamps. Times are microseconds since the Unix
+// epoch; zero means not stamped.
+type Message struct {
+	Sequence         uint64
+	Probe            bool // Clock probe; carries no ticker
+	ClientIntendedUs int64
+	ClientSendUs     int64
+	ServerRecvUs     int64
+	ServerSendUs     int64
+	Ticker           Ticker
+	Pad              []byte // Padding up to a payload size, echoed unchanged
+
+	// Body is a JSON object the JSON codec sends in place of the ticker,
+	// such as a rendered payload template. Other codecs reject it, and it is
+	// not decoded.
+	Body []byte
+}
+
+// hasTicker reports whether m is encoded with its ticker. Probes, messages
+// with a body and messages with a zero ticker are sent without one.
+func (m *Message) hasTicker() bool {
+	return !m.Probe && len(m.Body) == 0 && m.Ticker != (Ticker{})
+}
+
+// errBody is returned by codecs that cannot send a message body.
+func errBody(c Codec) error {
+	return fmt.Errorf("the %s codec cannot send a message body", c.Name())
+}
+
+// Ticker is the market data of a message, as in an OKX tickers push.
+type Ticker struct {
+	Channel   string
+	InstType  string
+	InstID    string
+	Last      string
+	LastSz    string
+	AskPx     string
+	AskSz     string
+	BidPx     string
+	BidSz     string
+	Open24h   string
+	High24h   string
+	Low24h    string
+	SodUtc0   string
+	SodUtc8   string
+	VolCcy24h string
+	Vol24h    string
+	Ts        string
+}
+
+// DefaultTicker returns the ticker the client starts messages from.
+func DefaultTicker() Ticker {
+	return Ticker{
+		Channel:   "tickers",
+		InstType:  "SPOT",
+		InstID:    "BTC-USDC",
+		Last:      "105926",
+		LastSz:    "0.00016398",
+		AskPx:     "105926.1",
+		AskSz:     "0.34547131",
+		BidPx:     "105926",
+		BidSz:     "0.04848602",
+		Open24h:   "103124.1",
+		High24h:   "106892.7",
+		Low24h:    "102100.6",
+		SodUtc0:   "105619.9",
+		SodUtc8:   "104822.1",
+		VolCcy24h: "78820585.423264371",
+		Vol24h:    "755.56112024",
+		Ts:        "1747721466604",
+	}
+}
+
+// Codec encodes and decodes messages in one wire format.
+type Codec interface {
+	// Name is the short name of the codec, as given on the command line.
+	Name() string
+	// MessageType is the WebSocket message type of encoded messages.
+	MessageType() int
+	// Append appends the encoding of m to dst.
+	Append(dst []byte, m *Message) ([]byte, error)
+	// AppendTemplate is Append, also returning where the sequence number,
+	// client timestamps and server send time are in the returned slice.
+	AppendTemplate(dst []byte, m *Message) ([]byte, Slots, error)
+	// Decode decodes data into m, overwriting all of its fields. m does not
+	// refer to data afterwards.
+	Decode(data []byte, m *Message) error
+}
+
+// Codecs are the supported codecs, JSON first.
+var Codecs = []Codec{JSON, MsgPack, Protobuf, Binary}
+
+// DecodeTest decodes the test fields of data into m, overwriting all of its
+// fields, but may leave the ticker and padding empty. Responses to large
+// payloads cost the JSON codec a search for "_test" instead of a full parse.
+func DecodeTest(c Codec, data []byte, m *Message) error {
+	if c == JSON {
+		return decodeJSONTest(data, m)
+	}
+	return c.Decode(data, m)
+}
+
+// ErrMalformed is returned, wrapped, for messages a codec cannot decode.
+var ErrMalformed = errors.New("malformed message")
+
+// ByName returns the codec with the given name.
+func ByName(name string) (Codec, error) {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q (want %s)", name, strings.Join(Names(), ", "))
+}
+
+// Names returns the names of the supported codecs.
+func Names() []string {
+	names := make([]string, len(Codecs))
+	for i, c := range Codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// Subprotocol returns the WebSocket subprotocol that selects c.
+func Subprotocol(c Codec) string {
+	return SubprotocolPrefix + c.Name()
+}
+
+// Subprotocols returns the WebSocket subprotocols of all codecs, for a server
+// to accept.
+func Subprotocols() []string {
+	protocols := make([]string, len(Codecs))
+	for i, c := range Codecs {
+		protocols[i] = Subprotocol(c)
+	}
+	return protocols
+}
+
+// BySubprotocol returns the codec selected by a negotiated subprotocol. No
+// subprotocol selects JSON; an unknown one returns nil.
+func BySubprotocol(protocol string) Codec {
+	if protocol == "" {
+		return JSON
+	}
+	for _, c := range Codecs {
+		if Subprotocol(c) == protocol {
+			return c
+		}
+	}
+	return nil
+}
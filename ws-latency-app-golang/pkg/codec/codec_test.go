@@ -0,0 +1,340 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testMessage() Message {
+	return Message{
+		Sequence:         1 << 40,
+		ClientIntendedUs: 1_747_721_466_604_000,
+		ClientSendUs:     1_747_721_466_604_012,
+		ServerRecvUs:     1_747_721_466_604_100,
+		ServerSendUs:     1_747_721_466_604_110,
+		Ticker:           DefaultTicker(),
+		Pad:              bytes.Repeat([]byte("x"), 300),
+	}
+}
+
+// equal compares messages, treating nil and empty padding alike.
+func equal(a, b Message) bool {
+	if !bytes.Equal(a.Pad, b.Pad) {
+		return false
+	}
+	a.Pad, b.Pad = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	probe := Message{Probe: true, ClientSendUs: 42}
+	small := Message{Sequence: 3, ClientSendUs: 1, Ticker: Ticker{Channel: "tickers", InstID: "ETH-USDT"}}
+	bare := Message{Sequence: 4, ClientSendUs: 2, Pad: []byte("xx")}
+	for _, c := range Codecs {
+		for _, m := range []Message{testMessage(), probe, small, bare} {
+			if c == Binary {
+				m.Ticker = Ticker{} // Not carried
+			}
+			data, err := c.Append(nil, &m)
+			if err != nil {
+				t.Fatalf("%s: Append: %v", c.Name(), err)
+			}
+			// Decoding overwrites every field of a reused message
+			got := testMessage()
+			got.Pad = make([]byte, 0, 1024)
+			if err := c.Decode(data, &got); err != nil {
+				t.Fatalf("%s: Decode: %v", c.Name(), err)
+			}
+			if !equal(got, m) {
+				t.Errorf("%s: round trip of %+v gave %+v", c.Name(), m, got)
+			}
+		}
+	}
+}
+
+func TestAppendAppends(t *testing.T) {
+	m := testMessage()
+	for _, c := range Codecs {
+		want, _ := c.Append(nil, &m)
+		got, _ := c.Append([]byte("prefix"), &m)
+		if string(got[:6]) != "prefix" || !bytes.Equal(got[6:], want) {
+			t.Errorf("%s: Append does not append to dst", c.Name())
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	// The JSON codec keeps the original ticker format
+	m := testMessage()
+	data, err := JSON.Append(nil, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	arg := doc["arg"].(map[string]interface{})
+	ticker := doc["data"].([]interface{})[0].(map[string]interface{})
+	test := doc["_test"].(map[string]interface{})
+	if arg["channel"] != "tickers" || ticker["instId"] != "BTC-USDC" || ticker["askPx"] != "105926.1" {
+		t.Errorf("ticker fields: %s", data)
+	}
+	if test["client_send_ts_us"] != float64(m.ClientSendUs) || test["server_send_ts_us"] != float64(m.ServerSendUs) {
+		t.Errorf("test fields: %s", data)
+	}
+	if len(doc["_pad"].(string)) != 300 {
+		t.Errorf("padding: %s", data)
+	}
+	// The server timestamps are last, at a fixed width
+	if want := `"server_send_ts_us":1747721466604110}}`; !strings.HasSuffix(string(data), want) {
+		t.Errorf("message does not end in %s: %s", want, data)
+	}
+	probe, _ := JSON.Append(nil, &Message{Probe: true, ClientSendUs: 1})
+	if want := `{"_test":{"probe":true,"sequence":0                   ,"client_intended_ts_us":0               ,"client_send_ts_us":1               ,"server_ts_us":0               ,"server_send_ts_us":0               }}`; string(probe) != want {
+		t.Errorf("probe encoded as %s, want %s", probe, want)
+	}
+
+	// Responses of servers that only stamp server_ts_us still decode
+	var got Message
+	if err := JSON.Decode([]byte(`{"_test":{"sequence":7,"client_send_ts_us":10,"server_ts_us":20},"extra":[1,2]}`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sequence != 7 || got.ClientSendUs != 10 || got.ServerRecvUs != 20 || got.ServerSendUs != 0 {
+		t.Errorf("decoded %+v", got)
+	}
+	if err := JSON.Decode([]byte(`{"_test":{"sequence":8,"server_ts_us":20.5}}`), &got); err != nil || got.ServerRecvUs != 20 {
+		t.Errorf("fractional timestamp decoded as %+v, %v", got, err)
+	}
+	if err := JSON.Decode([]byte(`{"event":"subscribe"}`), &got); !errors.Is(err, ErrMalformed) {
+		t.Errorf("message without _test decoded: %v", err)
+	}
+}
+
+func TestBody(t *testing.T) {
+	m := Message{Sequence: 5, Ticker: DefaultTicker(), Pad: []byte("xx"), Body: []byte(` {"bids": [["105926.1","0.5"]]} `)}
+	data, err := JSON.Append(nil, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"bids": [["105926.1","0.5"]],"_pad":"xx","_test":{"sequence":5 `; !strings.HasPrefix(string(data), want) {
+		t.Errorf("message with a body encoded as %s, want it to start with %s", data, want)
+	}
+	var got Message
+	if err := JSON.Decode(data, &got); err != nil || got.Sequence != 5 || got.Ticker != (Ticker{}) {
+		t.Errorf("decoded %+v, %v", got, err)
+	}
+
+	m.Body, m.Pad = []byte("{}"), nil
+	if data, _ := JSON.Append(nil, &m); !strings.HasPrefix(string(data), `{"_test":{`) {
+		t.Errorf("empty body encoded as %s", data)
+	}
+	m.Body = []byte(`[1,2]`)
+	if _, err := JSON.Append(nil, &m); err == nil {
+		t.Error("body that is not an object encoded")
+	}
+	for _, c := range Codecs[1:] {
+		if _, err := c.Append(nil, &m); err == nil {
+			t.Errorf("%s: body encoded", c.Name())
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	for _, c := range Codecs {
+		m := testMessage()
+		m.Sequence, m.ClientIntendedUs, m.ClientSendUs, m.ServerSendUs = 0, 0, 0, 0
+		if c == Binary {
+			m.Ticker = Ticker{}
+		}
+		plain, _ := c.Append(nil, &m)
+		data, slots, err := c.AppendTemplate([]byte("prefix"), &m)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !bytes.Equal(data[6:], plain) {
+			t.Errorf("%s: AppendTemplate and Append differ", c.Name())
+		}
+
+		// Writing the slots in place gives the encoding of the stamped message
+		allocs := testing.AllocsPerRun(10, func() {
+			slots.SetSequence(data, 1<<40+7)
+			slots.SetIntended(data, 1_747_721_466_604_000)
+			slots.SetSend(data, 1_747_721_466_604_012)
+			slots.SetServerSend(data, 1_747_721_466_604_110)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: writing slots allocates %.0f times", c.Name(), allocs)
+		}
+		m.Sequence, m.ClientIntendedUs, m.ClientSendUs = 1<<40+7, 1_747_721_466_604_000, 1_747_721_466_604_012
+		m.ServerSendUs = 1_747_721_466_604_110
+		want, _ := c.Append(nil, &m)
+		if !bytes.Equal(data[6:], want) {
+			t.Errorf("%s: stamped template\n%q\nwant\n%q", c.Name(), data[6:], want)
+		}
+	}
+}
+
+func TestDecodeTest(t *testing.T) {
+	m := testMessage()
+	for _, c := range Codecs {
+		data, _ := c.Append(nil, &m)
+		var got Message
+		if err := DecodeTest(c, data, &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		got.Ticker, got.Pad = Ticker{}, nil
+		want := m
+		want.Ticker, want.Pad = Ticker{}, nil
+		if !equal(got, want) {
+			t.Errorf("%s: decoded %+v, want %+v", c.Name(), got, want)
+		}
+	}
+
+	// Responses re-encoded by a generic JSON server have "_test" first
+	var got Message
+	resp := `{"_pad":"xx","_test":{"sequence":9,"client_send_ts_us":10,"server_ts_us":20.5},"arg":{"channel":"tickers"},"data":[{"instId":"BTC-USDC"}]}`
+	if err := DecodeTest(JSON, []byte(resp), &got); err != nil || got.Sequence != 9 || got.ServerRecvUs != 20 {
+		t.Errorf("decoded %+v, %v", got, err)
+	}
+	for _, bad := range []string{`{"event":"subscribe"}`, `{"_test":{"sequence":`} {
+		if err := DecodeTest(JSON, []byte(bad), &got); !errors.Is(err, ErrMalformed) {
+			t.Errorf("DecodeTest of %s = %v, want ErrMalformed", bad, err)
+		}
+	}
+}
+
+func TestMsgPackSkipsUnknownKeys(t *testing.T) {
+	m := testMessage()
+	data, _ := MsgPack.Append(nil, &m)
+	// Add an "extra" entry holding a nested map and array in front
+	if data[0]&0xf0 != 0x80 {
+		t.Fatalf("top level is not a fixmap: 0x%02x", data[0])
+	}
+	extra := []byte{data[0] + 1, 0xa5, 'e', 'x', 't', 'r', 'a', 0x82, 0xa1, 'a', 0x92, 0x01, 0xcb, 0, 0, 0, 0, 0, 0, 0, 0, 0xa1, 'b', 0xc0}
+	data = append(extra, data[1:]...)
+	var got Message
+	if err := MsgPack.Decode(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !equal(got, m) {
+		t.Errorf("decoded %+v, want %+v", got, m)
+	}
+}
+
+func TestProtobufSkipsUnknownFields(t *testing.T) {
+	m := testMessage()
+	data, _ := Protobuf.Append(nil, &m)
+	data = protowire.AppendTag(data, 99, protowire.BytesType)
+	data = protowire.AppendString(data, "from a newer version")
+	data = protowire.AppendTag(data, 100, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 1)
+	var got Message
+	if err := Protobuf.Decode(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !equal(got, m) {
+		t.Errorf("decoded %+v, want %+v", got, m)
+	}
+}
+
+func TestProtobufVarintServerTimes(t *testing.T) {
+	// Older servers encoded the server timestamps as varints
+	data := protowire.AppendTag(nil, pbSequence, protowire.Fixed64Type)
+	data = protowire.AppendFixed64(data, 3)
+	data = protowire.AppendTag(data, pbServerRecvUs, protowire.VarintType)
+	data = protowire.AppendVarint(data, 20)
+	data = protowire.AppendTag(data, pbServerSendUs, protowire.VarintType)
+	data = protowire.AppendVarint(data, 30)
+	var got Message
+	if err := Protobuf.Decode(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sequence != 3 || got.ServerRecvUs != 20 || got.ServerSendUs != 30 {
+		t.Errorf("decoded %+v", got)
+	}
+}
+
+func TestBinaryLayout(t *testing.T) {
+	m := testMessage()
+	data, _ := Binary.Append(nil, &m)
+	if len(data) != BinaryHeaderSize+len(m.Pad) || data[0] != BinaryVersion {
+		t.Fatalf("frame of %d bytes, version %d", len(data), data[0])
+	}
+	if got := int64(data[BinaryServerSendAt]) | int64(data[BinaryServerSendAt+1])<<8; got != m.ServerSendUs&0xffff {
+		t.Errorf("server send time not at offset %d", BinaryServerSendAt)
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	m := testMessage()
+	for _, c := range Codecs {
+		data, _ := c.Append(nil, &m)
+		// Binary payloads are opaque, so only a cut header is malformed
+		cut := len(data) - 1
+		if c == Binary {
+			cut = BinaryHeaderSize - 1
+		}
+		for _, bad := range [][]byte{data[:cut], []byte("\xff\xff\xff")} {
+			var got Message
+			if err := c.Decode(bad, &got); !errors.Is(err, ErrMalformed) {
+				t.Errorf("%s: Decode of %d bad bytes = %v, want ErrMalformed", c.Name(), len(bad), err)
+			}
+		}
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	if BySubprotocol("") != JSON {
+		t.Error("no subprotocol does not select JSON")
+	}
+	if BySubprotocol("wslatency.xml") != nil {
+		t.Error("unknown subprotocol selected a codec")
+	}
+	for _, c := range Codecs {
+		if BySubprotocol(Subprotocol(c)) != c {
+			t.Errorf("subprotocol %s does not select %s", Subprotocol(c), c.Name())
+		}
+		if got, err := ByName(c.Name()); got != c || err != nil {
+			t.Errorf("ByName(%s) = %v, %v", c.Name(), got, err)
+		}
+	}
+	if _, err := ByName("xml"); err == nil || !strings.Contains(err.Error(), "msgpack") {
+		t.Errorf("ByName(xml) = %v, want an error listing the codecs", err)
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	m := testMessage()
+	m.Pad = m.Pad[:0]
+	for _, c := range Codecs {
+		data, _ := c.Append(nil, &m)
+		b.Run(c.Name()+"/append", func(b *testing.B) {
+			b.ReportAllocs()
+			buf := make([]byte, 0, 1024)
+			for i := 0; i < b.N; i++ {
+				buf, _ = c.Append(buf[:0], &m)
+			}
+		})
+		b.Run(c.Name()+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			var got Message
+			for i := 0; i < b.N; i++ {
+				c.Decode(data, &got)
+			}
+		})
+		b.Run(c.Name()+"/decode-test", func(b *testing.B) {
+			b.ReportAllocs()
+			var got Message
+			for i := 0; i < b.N; i++ {
+				DecodeTest(c, data, &got)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// JSON is the original ticker format: an OKX-style tickers push with the
+// test fields in "_test" and padding in "_pad". Servers stamp server_ts_us
+// and server_send_ts_us. A message body replaces the ticker, its members
+// coming first.
+//
+// "_test" is encoded last, and its sequence number and timestamps are padded
+// with spaces to a fixed width, so a server can find the server timestamps
+// near the end of a message and overwrite them in place without parsing it,
+// and a sender can do the same with the client fields.
+var JSON Codec = jsonCodec{}
+
+// JSONStampWidth is the width of encoded timestamps: the digits of a
+// microsecond Unix time until the year 2286.
+const JSONStampWidth = 16
+
+// jsonSequenceWidth is the width of encoded sequence numbers, the digits of
+// the largest uint64.
+const jsonSequenceWidth = 20
+
+type jsonCodec struct{}
+
+type jsonArg struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+type jsonTicker struct {
+	InstType  string `json:"instType"`
+	InstID    string `json:"instId"`
+	Last      string `json:"last"`
+	LastSz    string `json:"lastSz"`
+	AskPx     string `json:"askPx"`
+	AskSz     string `json:"askSz"`
+	BidPx     string `json:"bidPx"`
+	BidSz     string `json:"bidSz"`
+	Open24h   string `json:"open24h"`
+	High24h   string `json:"high24h"`
+	Low24h    string `json:"low24h"`
+	SodUtc0   string `json:"sodUtc0"`
+	SodUtc8   string `json:"sodUtc8"`
+	VolCcy24h string `json:"volCcy24h"`
+	Vol24h    string `json:"vol24h"`
+	Ts        string `json:"ts"`
+}
+
+// jsonMessage is a message without its "_test" field, which is encoded by
+// hand.
+type jsonMessage struct {
+	Arg  *jsonArg     `json:"arg,omitempty"`
+	Data []jsonTicker `json:"data,omitempty"`
+	Pad  string       `json:"_pad,omitempty"`
+}
+
+// jsonDecoded is a message as decoded. A missing "_test" is told apart from
+// a zero one.
+type jsonDecoded struct {
+	Arg  *jsonArg     `json:"arg"`
+	Data []jsonTicker `json:"data"`
+	Test *jsonTest    `json:"_test"`
+	Pad  string       `json:"_pad"`
+}
+
+// jsonTest is the "_test" field as decoded. Other servers may stamp
+// fractional microseconds, so numbers are read as float64.
+type jsonTest struct {
+	Probe            bool    `json:"probe"`
+	Sequence         float64 `json:"sequence"`
+	ClientIntendedUs float64 `json:"client_intended_ts_us"`
+	ClientSendUs     float64 `json:"client_send_ts_us"`
+	ServerRecvUs     float64 `json:"server_ts_us"`
+	ServerSendUs     float64 `json:"server_send_ts_us"`
+}
+
+// message returns the test fields as a message with the given padding.
+func (t *jsonTest) message(pad []byte) Message {
+	return Message{
+		Sequence:         uint64(t.Sequence),
+		Probe:            t.Probe,
+		ClientIntendedUs: int64(t.ClientIntendedUs),
+		ClientSendUs:     int64(t.ClientSendUs),
+		ServerRecvUs:     int64(t.ServerRecvUs),
+		ServerSendUs:     int64(t.ServerSendUs),
+		Pad:              pad,
+	}
+}
+
+// jsonTestKey is the key of the test fields
+var jsonTestKey = []byte(`"_test":`)
+
+func (jsonCodec) Name() string     { return "json" }
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (c jsonCodec) Append(dst []byte, m *Message) ([]byte, error) {
+	dst, _, err := c.AppendTemplate(dst, m)
+	return dst, err
+}
+
+func (jsonCodec) AppendTemplate(dst []byte, m *Message) ([]byte, Slots, error) {
+	msg := jsonMessage{Pad: string(m.Pad)}
+	if m.hasTicker() {
+		t := &m.Ticker
+		msg.Arg = &jsonArg{Channel: t.Channel, InstID: t.InstID}
+		msg.Data = []jsonTicker{{
+			InstType: t.InstType, InstID: t.InstID, Last: t.Last, LastSz: t.LastSz,
+			AskPx: t.AskPx, AskSz: t.AskSz, BidPx: t.BidPx, BidSz: t.BidSz,
+			Open24h: t.Open24h, High24h: t.High24h, Low24h: t.Low24h,
+			SodUtc0: t.SodUtc0, SodUtc8: t.SodUtc8, VolCcy24h: t.VolCcy24h, Vol24h: t.Vol24h, Ts: t.Ts,
+		}}
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return dst, Slots{}, err
+	}
+
+	// Write the body's members, then the others and "_test" last
+	dst = append(dst, '{')
+	start := len(dst)
+	if len(m.Body) > 0 {
+		body := bytes.TrimSpace(m.Body)
+		if len(body) < 2 || body[0] != '{' || body[len(body)-1] != '}' {
+			return dst, Slots{}, errors.New("message body is not a JSON object")
+		}
+		dst = append(dst, bytes.TrimSpace(body[1:len(body)-1])...)
+	}
+	if len(data) > 2 {
+		if len(dst) > start {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, data[1:len(data)-1]...)
+	}
+	if len(dst) > start {
+		dst = append(dst, ',')
+	}
+	dst = append(dst, `"_test":{`...)
+	if m.Probe {
+		dst = append(dst, `"probe":true,`...)
+	}
+	var slots Slots
+	dst = append(dst, `"sequence":`...)
+	dst, slots.sequence = appendJSONSlot(dst, int64(m.Sequence), jsonSequenceWidth)
+	dst = append(dst, `,"client_intended_ts_us":`...)
+	dst, slots.intended = appendJSONSlot(dst, m.ClientIntendedUs, JSONStampWidth)
+	dst = append(dst, `,"client_send_ts_us":`...)
+	dst, slots.send = appendJSONSlot(dst, m.ClientSendUs, JSONStampWidth)
+	dst = append(dst, `,"server_ts_us":`...)
+	dst, _ = appendJSONSlot(dst, m.ServerRecvUs, JSONStampWidth)
+	dst = append(dst, `,"server_send_ts_us":`...)
+	dst, slots.serverSend = appendJSONSlot(dst, m.ServerSendUs, JSONStampWidth)
+	return append(dst, "}}"...), slots, nil
+}
+
+// appendJSONSlot appends v padded with spaces to width.
+func appendJSONSlot(b []byte, v int64, width int) ([]byte, slot) {
+	s := slot{at: len(b), width: width}
+	b = strconv.AppendInt(b, v, 10)
+	for len(b)-s.at < width {
+		b = append(b, ' ')
+	}
+	return b, s
+}
+
+func (jsonCodec) Decode(data []byte, m *Message) error {
+	var msg jsonDecoded
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if msg.Test == nil {
+		return fmt.Errorf("%w: no _test field", ErrMalformed)
+	}
+	*m = msg.Test.message(append(m.Pad[:0], msg.Pad...))
+	if msg.Arg != nil {
+		m.Ticker.Channel = msg.Arg.Channel
+	}
+	if len(msg.Data) > 0 {
+		d := &msg.Data[0]
+		m.Ticker = Ticker{
+			Channel: m.Ticker.Channel, InstType: d.InstType, InstID: d.InstID, Last: d.Last, LastSz: d.LastSz,
+			AskPx: d.AskPx, AskSz: d.AskSz, BidPx: d.BidPx, BidSz: d.BidSz,
+			Open24h: d.Open24h, High24h: d.High24h, Low24h: d.Low24h,
+			SodUtc0: d.SodUtc0, SodUtc8: d.SodUtc8, VolCcy24h: d.VolCcy24h, Vol24h: d.Vol24h, Ts: d.Ts,
+		}
+	}
+	return nil
+}
+
+// decodeJSONTest decodes only the value of the last "_test" key of a JSON
+// message, which the JSON codec writes last, without scanning the rest.
+func decodeJSONTest(data []byte, m *Message) error {
+	i := bytes.LastIndex(data, jsonTestKey)
+	if i < 0 {
+		return fmt.Errorf("%w: no _test field", ErrMalformed)
+	}
+	var test jsonTest
+	if err := json.NewDecoder(bytes.NewReader(data[i+len(jsonTestKey):])).Decode(&test); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	*m = test.message(m.Pad[:0])
+	return nil
+}
@@ -0,0 +1,465 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/gorilla/websocket"
+)
+
+// MsgPack encodes the JSON document in MessagePack, with the same keys and
+// the padding as binary. The sequence number and the client and server
+// timestamps are always 64-bit integers. Unknown keys are skipped when decoding.
+var MsgPack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string     { return "msgpack" }
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (c msgpackCodec) Append(b []byte, m *Message) ([]byte, error) {
+	b, _, err := c.AppendTemplate(b, m)
+	return b, err
+}
+
+func (c msgpackCodec) AppendTemplate(b []byte, m *Message) ([]byte, Slots, error) {
+	if len(m.Body) > 0 {
+		return b, Slots{}, errBody(c)
+	}
+	var slots Slots
+	entries := 1
+	if m.hasTicker() {
+		entries += 2
+	}
+	if len(m.Pad) > 0 {
+		entries++
+	}
+	b = appendMsgpackMap(b, entries)
+	if m.hasTicker() {
+		t := &m.Ticker
+		b = appendMsgpackStr(b, "arg")
+		b = appendMsgpackMap(b, 2)
+		b = appendMsgpackStr(b, "channel")
+		b = appendMsgpackStr(b, t.Channel)
+		b = appendMsgpackStr(b, "instId")
+		b = appendMsgpackStr(b, t.InstID)
+
+		b = appendMsgpackStr(b, "data")
+		b = append(b, 0x91) // Array of one
+		b = appendMsgpackMap(b, 16)
+		for _, f := range [...]struct{ key, value string }{
+			{"instType", t.InstType}, {"instId", t.InstID}, {"last", t.Last}, {"lastSz", t.LastSz},
+			{"askPx", t.AskPx}, {"askSz", t.AskSz}, {"bidPx", t.BidPx}, {"bidSz", t.BidSz},
+			{"open24h", t.Open24h}, {"high24h", t.High24h}, {"low24h", t.Low24h},
+			{"sodUtc0", t.SodUtc0}, {"sodUtc8", t.SodUtc8}, {"volCcy24h", t.VolCcy24h}, {"vol24h", t.Vol24h}, {"ts", t.Ts},
+		} {
+			b = appendMsgpackStr(b, f.key)
+			b = appendMsgpackStr(b, f.value)
+		}
+	}
+
+	b = appendMsgpackStr(b, "_test")
+	b = appendMsgpackMap(b, 6)
+	b = appendMsgpackStr(b, "probe")
+	if m.Probe {
+		b = append(b, 0xc3)
+	} else {
+		b = append(b, 0xc2)
+	}
+	b = appendMsgpackStr(b, "sequence")
+	slots.sequence = slot{at: len(b) + 1, big: true}
+	b = append(b, 0xcf)
+	b = binary.BigEndian.AppendUint64(b, m.Sequence)
+	b = appendMsgpackStr(b, "client_intended_ts_us")
+	slots.intended = slot{at: len(b) + 1, big: true}
+	b = append(b, 0xd3)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.ClientIntendedUs))
+	b = appendMsgpackStr(b, "client_send_ts_us")
+	slots.send = slot{at: len(b) + 1, big: true}
+	b = append(b, 0xd3)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.ClientSendUs))
+	b = appendMsgpackStr(b, "server_ts_us")
+	b = append(b, 0xd3)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.ServerRecvUs))
+	b = appendMsgpackStr(b, "server_send_ts_us")
+	slots.serverSend = slot{at: len(b) + 1, big: true}
+	b = append(b, 0xd3)
+	b = binary.BigEndian.AppendUint64(b, uint64(m.ServerSendUs))
+
+	if len(m.Pad) > 0 {
+		b = appendMsgpackStr(b, "_pad")
+		b = appendMsgpackBin(b, m.Pad)
+	}
+	return b, slots, nil
+}
+
+func (msgpackCodec) Decode(data []byte, m *Message) error {
+	pad := m.Pad[:0]
+	*m = Message{}
+	r := msgpackReader{b: data}
+	for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+		switch string(r.strBytes()) {
+		case "arg":
+			for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+				switch string(r.strBytes()) {
+				case "channel":
+					m.Ticker.Channel = r.str()
+				default:
+					r.skip()
+				}
+			}
+		case "data":
+			for i, n := 0, r.arrayLen(); i < n && r.err == nil; i++ {
+				if i > 0 {
+					r.skip()
+					continue
+				}
+				r.ticker(&m.Ticker)
+			}
+		case "_test":
+			for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+				switch string(r.strBytes()) {
+				case "probe":
+					m.Probe = r.bool()
+				case "sequence":
+					m.Sequence = uint64(r.int())
+				case "client_intended_ts_us":
+					m.ClientIntendedUs = r.int()
+				case "client_send_ts_us":
+					m.ClientSendUs = r.int()
+				case "server_ts_us":
+					m.ServerRecvUs = r.int()
+				case "server_send_ts_us":
+					m.ServerSendUs = r.int()
+				default:
+					r.skip()
+				}
+			}
+		case "_pad":
+			pad = append(pad, r.binBytes()...)
+		default:
+			r.skip()
+		}
+	}
+	m.Pad = pad
+	if r.err != nil {
+		return fmt.Errorf("%w: msgpack: %v", ErrMalformed, r.err)
+	}
+	return nil
+}
+
+func appendMsgpackMap(b []byte, n int) []byte {
+	if n < 16 {
+		return append(b, 0x80|byte(n))
+	}
+	return append(b, 0xde, byte(n>>8), byte(n))
+}
+
+func appendMsgpackStr(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackBin(b []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xc6)
+		b = binary.BigEndian.AppendUint32(b, uint32(n))
+	}
+	return append(b, data...)
+}
+
+// msgpackReader decodes the MessagePack subset messages use. The first error
+// stops decoding; later reads return zero values.
+type msgpackReader struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes.
+func (r *msgpackReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = fmt.Errorf("truncated")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// format returns the next format byte.
+func (r *msgpackReader) format() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0xc1 // Never used, so a type error for every caller
+	}
+	return b[0]
+}
+
+// length reads a big-endian length of size bytes.
+func (r *msgpackReader) length(size int) int {
+	b := r.next(size)
+	switch size {
+	case 1:
+		if b != nil {
+			return int(b[0])
+		}
+	case 2:
+		if b != nil {
+			return int(binary.BigEndian.Uint16(b))
+		}
+	case 4:
+		if b != nil {
+			return int(binary.BigEndian.Uint32(b))
+		}
+	}
+	return 0
+}
+
+func (r *msgpackReader) fail(what string, f byte) {
+	if r.err == nil {
+		r.err = fmt.Errorf("want %s, got format 0x%02x", what, f)
+	}
+}
+
+func (r *msgpackReader) mapLen() int {
+	switch f := r.format(); {
+	case f&0xf0 == 0x80:
+		return int(f & 0x0f)
+	case f == 0xde:
+		return r.length(2)
+	case f == 0xdf:
+		return r.length(4)
+	default:
+		r.fail("map", f)
+		return 0
+	}
+}
+
+func (r *msgpackReader) arrayLen() int {
+	switch f := r.format(); {
+	case f&0xf0 == 0x90:
+		return int(f & 0x0f)
+	case f == 0xdc:
+		return r.length(2)
+	case f == 0xdd:
+		return r.length(4)
+	default:
+		r.fail("array", f)
+		return 0
+	}
+}
+
+// strBytes returns a string without copying it.
+func (r *msgpackReader) strBytes() []byte {
+	switch f := r.format(); {
+	case f&0xe0 == 0xa0:
+		return r.next(int(f & 0x1f))
+	case f == 0xd9:
+		return r.next(r.length(1))
+	case f == 0xda:
+		return r.next(r.length(2))
+	case f == 0xdb:
+		return r.next(r.length(4))
+	default:
+		r.fail("string", f)
+		return nil
+	}
+}
+
+func (r *msgpackReader) str() string {
+	return string(r.strBytes())
+}
+
+// binBytes returns binary data, or a string, without copying it.
+func (r *msgpackReader) binBytes() []byte {
+	switch f := r.b; {
+	case len(f) > 0 && f[0] >= 0xc4 && f[0] <= 0xc6:
+		r.format()
+		return r.next(r.length(1 << (f[0] - 0xc4)))
+	default:
+		return r.strBytes()
+	}
+}
+
+func (r *msgpackReader) bool() bool {
+	switch f := r.format(); f {
+	case 0xc2:
+		return false
+	case 0xc3:
+		return true
+	default:
+		r.fail("bool", f)
+		return false
+	}
+}
+
+// int reads any integer format.
+func (r *msgpackReader) int() int64 {
+	f := r.format()
+	switch {
+	case f <= 0x7f:
+		return int64(f)
+	case f >= 0xe0:
+		return int64(int8(f))
+	}
+	switch f {
+	case 0xcc:
+		return int64(r.length(1))
+	case 0xcd:
+		return int64(r.length(2))
+	case 0xce:
+		return int64(r.length(4))
+	case 0xcf:
+		if b := r.next(8); b != nil {
+			return int64(binary.BigEndian.Uint64(b))
+		}
+	case 0xd0:
+		if b := r.next(1); b != nil {
+			return int64(int8(b[0]))
+		}
+	case 0xd1:
+		if b := r.next(2); b != nil {
+			return int64(int16(binary.BigEndian.Uint16(b)))
+		}
+	case 0xd2:
+		if b := r.next(4); b != nil {
+			return int64(int32(binary.BigEndian.Uint32(b)))
+		}
+	case 0xd3:
+		if b := r.next(8); b != nil {
+			return int64(binary.BigEndian.Uint64(b))
+		}
+	default:
+		r.fail("integer", f)
+	}
+	return 0
+}
+
+// ticker reads a ticker map into t.
+func (r *msgpackReader) ticker(t *Ticker) {
+	for n := r.mapLen(); n > 0 && r.err == nil; n-- {
+		key := r.strBytes()
+		var field *string
+		switch string(key) {
+		case "instType":
+			field = &t.InstType
+		case "instId":
+			field = &t.InstID
+		case "last":
+			field = &t.Last
+		case "lastSz":
+			field = &t.LastSz
+		case "askPx":
+			field = &t.AskPx
+		case "askSz":
+			field = &t.AskSz
+		case "bidPx":
+			field = &t.BidPx
+		case "bidSz":
+			field = &t.BidSz
+		case "open24h":
+			field = &t.Open24h
+		case "high24h":
+			field = &t.High24h
+		case "low24h":
+			field = &t.Low24h
+		case "sodUtc0":
+			field = &t.SodUtc0
+		case "sodUtc8":
+			field = &t.SodUtc8
+		case "volCcy24h":
+			field = &t.VolCcy24h
+		case "vol24h":
+			field = &t.Vol24h
+		case "ts":
+			field = &t.Ts
+		default:
+			r.skip()
+			continue
+		}
+		*field = r.str()
+	}
+}
+
+// skip skips one value of any type.
+func (r *msgpackReader) skip() {
+	f := r.format()
+	switch {
+	case f <= 0x7f, f >= 0xe0, f == 0xc0, f == 0xc2, f == 0xc3:
+	case f&0xf0 == 0x80:
+		r.skipN(2 * int(f&0x0f))
+	case f&0xf0 == 0x90:
+		r.skipN(int(f & 0x0f))
+	case f&0xe0 == 0xa0:
+		r.next(int(f & 0x1f))
+	default:
+		switch f {
+		case 0xc4, 0xd9:
+			r.next(r.length(1))
+		case 0xc5, 0xda:
+			r.next(r.length(2))
+		case 0xc6, 0xdb:
+			r.next(r.length(4))
+		case 0xcc, 0xd0:
+			r.next(1)
+		case 0xcd, 0xd1:
+			r.next(2)
+		case 0xca, 0xce, 0xd2:
+			r.next(4)
+		case 0xcb, 0xcf, 0xd3:
+			r.next(8)
+		case 0xd4:
+			r.next(2)
+		case 0xd5:
+			r.next(3)
+		case 0xd6:
+			r.next(5)
+		case 0xd7:
+			r.next(9)
+		case 0xd8:
+			r.next(17)
+		case 0xc7:
+			r.next(r.length(1) + 1)
+		case 0xc8:
+			r.next(r.length(2) + 1)
+		case 0xc9:
+			r.next(r.length(4) + 1)
+		case 0xdc:
+			r.skipN(r.length(2))
+		case 0xdd:
+			r.skipN(r.length(4))
+		case 0xde:
+			r.skipN(2 * r.length(2))
+		case 0xdf:
+			r.skipN(2 * r.length(4))
+		default:
+			r.fail("value", f)
+		}
+	}
+}
+
+// skipN skips n values.
+func (r *msgpackReader) skipN(n int) {
+	for ; n > 0 && r.err == nil; n-- {
+		r.skip()
+	}
+}
@@ -0,0 +1,195 @@
+package codec
+
+import (
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encodes messages in the Protocol Buffers wire format, as
+// described by message.proto. It is encoded by hand with protowire, so no
+// generated code is needed; unknown fields are skipped when decoding. The
+// sequence number and timestamps are fixed64 fields, always present.
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+// Field numbers of LatencyMessage
+const (
+	pbSequence         protowire.Number = 1
+	pbProbe            protowire.Number = 2
+	pbClientIntendedUs protowire.Number = 3
+	pbClientSendUs     protowire.Number = 4
+	pbServerRecvUs     protowire.Number = 5
+	pbServerSendUs     protowire.Number = 6
+	pbTicker           protowire.Number = 7
+	pbPad              protowire.Number = 8
+)
+
+func (protobufCodec) Name() string     { return "protobuf" }
+func (protobufCodec) MessageType() int { return websocket.BinaryMessage }
+
+// tickerFields returns pointers to the fields of t in field number order,
+// starting at 1.
+func tickerFields(t *Ticker) [17]*string {
+	return [...]*string{
+		&t.Channel, &t.InstType, &t.InstID, &t.Last, &t.LastSz, &t.AskPx, &t.AskSz, &t.BidPx, &t.BidSz,
+		&t.Open24h, &t.High24h, &t.Low24h, &t.SodUtc0, &t.SodUtc8, &t.VolCcy24h, &t.Vol24h, &t.Ts,
+	}
+}
+
+func (c protobufCodec) Append(b []byte, m *Message) ([]byte, error) {
+	b, _, err := c.AppendTemplate(b, m)
+	return b, err
+}
+
+func (c protobufCodec) AppendTemplate(b []byte, m *Message) ([]byte, Slots, error) {
+	if len(m.Body) > 0 {
+		return b, Slots{}, errBody(c)
+	}
+	// Proto3 leaves out fields with zero values, except the fixed-width ones
+	// that are written in place
+	varint := func(num protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	}
+	fixed := func(num protowire.Number, v uint64) slot {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		s := slot{at: len(b)}
+		b = protowire.AppendFixed64(b, v)
+		return s
+	}
+	var slots Slots
+	slots.sequence = fixed(pbSequence, m.Sequence)
+	varint(pbProbe, protowire.EncodeBool(m.Probe))
+	slots.intended = fixed(pbClientIntendedUs, uint64(m.ClientIntendedUs))
+	slots.send = fixed(pbClientSendUs, uint64(m.ClientSendUs))
+	fixed(pbServerRecvUs, uint64(m.ServerRecvUs))
+	slots.serverSend = fixed(pbServerSendUs, uint64(m.ServerSendUs))
+
+	if m.hasTicker() {
+		fields := tickerFields(&m.Ticker)
+		size := 0
+		for i, f := range fields {
+			if *f != "" {
+				size += protowire.SizeTag(protowire.Number(i+1)) + protowire.SizeBytes(len(*f))
+			}
+		}
+		b = protowire.AppendTag(b, pbTicker, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(size))
+		for i, f := range fields {
+			if *f != "" {
+				b = protowire.AppendTag(b, protowire.Number(i+1), protowire.BytesType)
+				b = protowire.AppendString(b, *f)
+			}
+		}
+	}
+	if len(m.Pad) > 0 {
+		b = protowire.AppendTag(b, pbPad, protowire.BytesType)
+		b = protowire.AppendBytes(b, m.Pad)
+	}
+	return b, slots, nil
+}
+
+func (protobufCodec) Decode(data []byte, m *Message) error {
+	pad := m.Pad[:0]
+	*m = Message{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return pbError(n)
+		}
+		data = data[n:]
+
+		switch {
+		case typ == protowire.Fixed64Type && (num == pbSequence || num == pbClientIntendedUs || num == pbClientSendUs ||
+			num == pbServerRecvUs || num == pbServerSendUs):
+			v, n := protowire.ConsumeFixed64(data)
+			if n < 0 {
+				return pbError(n)
+			}
+			data = data[n:]
+			switch num {
+			case pbSequence:
+				m.Sequence = v
+			case pbClientIntendedUs:
+				m.ClientIntendedUs = int64(v)
+			case pbClientSendUs:
+				m.ClientSendUs = int64(v)
+			case pbServerRecvUs:
+				m.ServerRecvUs = int64(v)
+			case pbServerSendUs:
+				m.ServerSendUs = int64(v)
+			}
+		// Varint server timestamps are from older servers
+		case typ == protowire.VarintType && (num == pbProbe || num == pbServerRecvUs || num == pbServerSendUs):
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				return pbError(n)
+			}
+			data = data[n:]
+			switch num {
+			case pbProbe:
+				m.Probe = protowire.DecodeBool(v)
+			case pbServerRecvUs:
+				m.ServerRecvUs = int64(v)
+			case pbServerSendUs:
+				m.ServerSendUs = int64(v)
+			}
+		case typ == protowire.BytesType && (num == pbTicker || num == pbPad):
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return pbError(n)
+			}
+			data = data[n:]
+			if num == pbPad {
+				pad = append(pad, v...)
+			} else if err := decodeTicker(v, &m.Ticker); err != nil {
+				return err
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return pbError(n)
+			}
+			data = data[n:]
+		}
+	}
+	m.Pad = pad
+	return nil
+}
+
+// decodeTicker decodes a Ticker message into t.
+func decodeTicker(data []byte, t *Ticker) error {
+	fields := tickerFields(t)
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return pbError(n)
+		}
+		data = data[n:]
+		if typ == protowire.BytesType && num >= 1 && int(num) <= len(fields) {
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return pbError(n)
+			}
+			data = data[n:]
+			*fields[num-1] = string(v)
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			return pbError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// pbError wraps the error of a negative protowire result.
+func pbError(n int) error {
+	return fmt.Errorf("%w: protobuf: %v", ErrMalformed, protowire.ParseError(n))
+}
@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Slots locates the sequence number, the client timestamps and the server
+// send time in a message encoded by AppendTemplate. All codecs encode these
+// fields at a fixed width, so a sender can encode messages ahead of time and
+// write only them, the send time last, just before sending.
+type Slots struct {
+	sequence, intended, send, serverSend slot
+}
+
+// SetSequence writes the sequence number into message.
+func (s Slots) SetSequence(message []byte, seq uint64) {
+	s.sequence.put(message, int64(seq))
+}
+
+// SetIntended writes the intended send time into message.
+func (s Slots) SetIntended(message []byte, us int64) {
+	s.intended.put(message, us)
+}
+
+// SetSend writes the send time into message.
+func (s Slots) SetSend(message []byte, us int64) {
+	s.send.put(message, us)
+}
+
+// SetServerSend writes the server send time into message.
+func (s Slots) SetServerSend(message []byte, us int64) {
+	s.serverSend.put(message, us)
+}
+
+// slot is a fixed-width field at an offset of a message.
+type slot struct {
+	at    int
+	width int // Decimal digits padded with spaces; 0 for 8-byte integers
+	big   bool
+}
+
+func (s slot) put(b []byte, v int64) {
+	switch {
+	case s.width > 0:
+		var buf [20]byte
+		n := copy(b[s.at:s.at+s.width], strconv.AppendInt(buf[:0], v, 10))
+		for i := s.at + n; i < s.at+s.width; i++ {
+			b[i] = ' '
+		}
+	case s.big:
+		binary.BigEndian.PutUint64(b[s.at:], uint64(v))
+	default:
+		binary.LittleEndian.PutUint64(b[s.at:], uint64(v))
+	}
+}
@@ -0,0 +1,160 @@
+package record
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// column is one field of a sample in converted output.
+type column struct {
+	name  string
+	value func(s *Sample) int64
+	rtt   bool // Derived from the receive time, so empty for lost samples
+}
+
+// columns are the fields of converted output: the recorded timestamps
+// followed by the round-trip times derived from them.
+var columns = []column{
+	{"conn", func(s *Sample) int64 { return int64(s.Conn) }, false},
+	{"seq", func(s *Sample) int64 { return int64(s.Seq) }, false},
+	{"intended_us", func(s *Sample) int64 { return s.IntendedUs }, false},
+	{"send_us", func(s *Sample) int64 { return s.SendUs }, false},
+	{"server_recv_us", func(s *Sample) int64 { return s.ServerRecvUs }, false},
+	{"server_send_us", func(s *Sample) int64 { return s.ServerSendUs }, false},
+	{"recv_us", func(s *Sample) int64 { return s.RecvUs }, false},
+	{"warmup", func(s *Sample) int64 { return int64(s.Flags & FlagWarmup) }, false},
+	{"late", func(s *Sample) int64 { return int64(s.Flags&FlagLate) >> 1 }, false},
+	{"lost", func(s *Sample) int64 { return int64(s.Flags&FlagLost) >> 2 }, false},
+	{"rtt_us", func(s *Sample) int64 { return s.RTT() }, true},
+	{"corrected_rtt_us", func(s *Sample) int64 { return s.CorrectedRTT() }, true},
+}
+
+// appendValue appends the value of column c for s to buf, or empty if the
+// sample has none.
+func appendValue(buf []byte, c column, s *Sample, empty string) []byte {
+	if c.rtt && s.Lost() {
+		return append(buf, empty...)
+	}
+	return strconv.AppendInt(buf, c.value(s), 10)
+}
+
+// WriteCSV converts the samples of r to CSV, one row per sample, and returns
+// the number of samples. The round-trip times of lost samples are empty. A truncated last record is left out, and
+// ErrTruncated returned after the output is complete.
+func WriteCSV(w io.Writer, r *Reader) (int64, error) {
+	bw := bufio.NewWriter(w)
+	for i, c := range columns {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(c.name)
+	}
+	bw.WriteByte('\n')
+
+	var s Sample
+	var n int64
+	var buf []byte
+	for {
+		err := r.Next(&s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			bw.Flush()
+			return n, err
+		}
+		buf = buf[:0]
+		for i, c := range columns {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendValue(buf, c, &s, "")
+		}
+		buf = append(buf, '\n')
+		bw.Write(buf)
+		n++
+	}
+	if err := bw.Flush(); err != nil {
+		return n, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return n, nil
+}
+
+// WriteColumns converts the record file read from src to columnar JSON, an
+// object with one array per field:
+//
+//	{"start_us":...,"count":N,"columns":{"conn":[...],"seq":[...],...}}
+//
+// The round-trip times of lost samples are null. Each column is read in a
+// separate pass over src, so memory use does not grow with the file. It
+// returns the number of samples. A truncated last record is left out, and
+// ErrTruncated returned after the output is complete.
+func WriteColumns(w io.Writer, src io.ReadSeeker) (int64, error) {
+	r, err := rewind(src)
+	if err != nil {
+		return 0, err
+	}
+	n, truncated := count(r)
+	if truncated != nil && !errors.Is(truncated, ErrTruncated) {
+		return 0, truncated
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `{"start_us":%d,"count":%d,"columns":{`, r.Header().StartUs, n)
+	var buf []byte
+	for i, c := range columns {
+		if r, err = rewind(src); err != nil {
+			return n, err
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		fmt.Fprintf(bw, "\n%q:[", c.name)
+		var s Sample
+		for j := int64(0); j < n; j++ {
+			if err := r.Next(&s); err != nil {
+				return n, err
+			}
+			buf = buf[:0]
+			if j > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendValue(buf, c, &s, "null")
+			bw.Write(buf)
+		}
+		bw.WriteByte(']')
+	}
+	bw.WriteString("}}\n")
+	if err := bw.Flush(); err != nil {
+		return n, fmt.Errorf("failed to write columns: %w", err)
+	}
+	return n, truncated
+}
+
+// rewind seeks src back to the start and reads the header again.
+func rewind(src io.ReadSeeker) (*Reader, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind record file: %w", err)
+	}
+	return NewReader(bufio.NewReaderSize(src, 64*1024))
+}
+
+// count returns the number of samples left in r, and the error that ended
+// them if it was not io.EOF.
+func count(r *Reader) (int64, error) {
+	var s Sample
+	var n int64
+	for {
+		err := r.Next(&s)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+}
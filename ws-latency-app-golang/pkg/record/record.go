@@ -0,0 +1,169 @@
+// Package record stores individual latency samples in a compact append-only
+// binary file, for analysis beyond the percentiles the client reports.
+//
+// A file starts with a header:
+//
+//	magic       [8]byte "WSLATREC"
+//	version     uint16  (1)
+//	record size uint16  (56 in version 1)
+//	reserved    uint32
+//	start       int64   microseconds since the Unix epoch
+//
+// followed by fixed-size records of:
+//
+//	seq            uint64
+//	intended       int64  client intended send time, µs
+//	send           int64  client send time, µs
+//	server recv    int64  server receive time, µs (0 if not stamped)
+//	server send    int64  server send time, µs (0 if not stamped)
+//	recv           int64  client receive time, µs (0 if lost)
+//	conn           uint32
+//	flags          uint32 (FlagWarmup, FlagLate, FlagLost)
+//
+// All integers are little-endian. Readers skip any bytes beyond the fields
+// they know, so later versions may append fields to a record.
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Format constants
+const (
+	Magic      = "WSLATREC"
+	Version    = 1
+	HeaderSize = 24
+	RecordSize = 56
+)
+
+// Sample flags
+const (
+	FlagWarmup uint32 = 1 << iota // Sent in a warm-up phase
+	FlagLate                      // Received after the response timeout
+	FlagLost                      // Never received
+)
+
+// Header is the header of a record file.
+type Header struct {
+	Version    uint16
+	RecordSize uint16
+	StartUs    int64 // Creation time of the file
+}
+
+// Sample is the timing of one message, in microseconds since the Unix epoch.
+type Sample struct {
+	Seq          uint64
+	IntendedUs   int64
+	SendUs       int64
+	ServerRecvUs int64
+	ServerSendUs int64
+	RecvUs       int64
+	Conn         uint32
+	Flags        uint32
+}
+
+// Lost reports whether the message of the sample was never answered.
+func (s *Sample) Lost() bool {
+	return s.Flags&FlagLost != 0
+}
+
+// RTT returns the round-trip time of the sample. It is meaningless for a
+// lost sample.
+func (s *Sample) RTT() int64 {
+	return s.RecvUs - s.SendUs
+}
+
+// CorrectedRTT returns the round-trip time from the intended send time.
+func (s *Sample) CorrectedRTT() int64 {
+	return s.RecvUs - s.IntendedUs
+}
+
+// encodeHeader encodes h into b, which must hold HeaderSize bytes.
+func encodeHeader(b []byte, h Header) {
+	copy(b, Magic)
+	binary.LittleEndian.PutUint16(b[8:], h.Version)
+	binary.LittleEndian.PutUint16(b[10:], h.RecordSize)
+	binary.LittleEndian.PutUint32(b[12:], 0)
+	binary.LittleEndian.PutUint64(b[16:], uint64(h.StartUs))
+}
+
+// encodeSample encodes s into b, which must hold RecordSize bytes.
+func encodeSample(b []byte, s *Sample) {
+	binary.LittleEndian.PutUint64(b[0:], s.Seq)
+	binary.LittleEndian.PutUint64(b[8:], uint64(s.IntendedUs))
+	binary.LittleEndian.PutUint64(b[16:], uint64(s.SendUs))
+	binary.LittleEndian.PutUint64(b[24:], uint64(s.ServerRecvUs))
+	binary.LittleEndian.PutUint64(b[32:], uint64(s.ServerSendUs))
+	binary.LittleEndian.PutUint64(b[40:], uint64(s.RecvUs))
+	binary.LittleEndian.PutUint32(b[48:], s.Conn)
+	binary.LittleEndian.PutUint32(b[52:], s.Flags)
+}
+
+// decodeSample decodes a version 1 record from b into s.
+func decodeSample(b []byte, s *Sample) {
+	s.Seq = binary.LittleEndian.Uint64(b[0:])
+	s.IntendedUs = int64(binary.LittleEndian.Uint64(b[8:]))
+	s.SendUs = int64(binary.LittleEndian.Uint64(b[16:]))
+	s.ServerRecvUs = int64(binary.LittleEndian.Uint64(b[24:]))
+	s.ServerSendUs = int64(binary.LittleEndian.Uint64(b[32:]))
+	s.RecvUs = int64(binary.LittleEndian.Uint64(b[40:]))
+	s.Conn = binary.LittleEndian.Uint32(b[48:])
+	s.Flags = binary.LittleEndian.Uint32(b[52:])
+}
+
+// ErrTruncated is returned by Reader.Next when the file ends inside a record,
+// as when the recording process was killed mid-write.
+var ErrTruncated = errors.New("record file ends inside a record")
+
+// Reader reads samples from a record file.
+type Reader struct {
+	r      io.Reader
+	header Header
+	buf    []byte
+}
+
+// NewReader reads the header from r and returns a Reader for its samples.
+func NewReader(r io.Reader) (*Reader, error) {
+	var b [HeaderSize]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, fmt.Errorf("failed to read record header: %w", err)
+	}
+	if string(b[:8]) != Magic {
+		return nil, fmt.Errorf("not a record file: bad magic %q", b[:8])
+	}
+	h := Header{
+		Version:    binary.LittleEndian.Uint16(b[8:]),
+		RecordSize: binary.LittleEndian.Uint16(b[10:]),
+		StartUs:    int64(binary.LittleEndian.Uint64(b[16:])),
+	}
+	if h.Version == 0 || h.Version > Version {
+		return nil, fmt.Errorf("unsupported record file version %d (up to %d supported)", h.Version, Version)
+	}
+	if h.RecordSize < RecordSize {
+		return nil, fmt.Errorf("record size %d is smaller than %d", h.RecordSize, RecordSize)
+	}
+	return &Reader{r: r, header: h, buf: make([]byte, h.RecordSize)}, nil
+}
+
+// Header returns the file header.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next reads the next sample into s. It returns io.EOF after the last one.
+func (r *Reader) Next(s *Sample) error {
+	n, err := io.ReadFull(r.r, r.buf)
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case err == io.ErrUnexpectedEOF:
+		return fmt.Errorf("%w (%d trailing bytes)", ErrTruncated, n)
+	case err != nil:
+		return fmt.Errorf("failed to read record: %w", err)
+	}
+	decodeSample(r.buf, s)
+	return nil
+}
@@ -0,0 +1,278 @@
+package record
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sample(i int) Sample {
+	base := int64(1_700_000_000_000_000 + i*1000)
+	return Sample{
+		Seq:          uint64(i),
+		IntendedUs:   base,
+		SendUs:       base + 5,
+		ServerRecvUs: base + 60,
+		ServerSendUs: base + 70,
+		RecvUs:       base + 125,
+		Conn:         uint32(i % 3),
+		Flags:        uint32(i % 4),
+	}
+}
+
+// record writes n samples over two streams and returns the file contents.
+// It lets the writer catch up after every block, as samples arriving at
+// network speed would.
+func record(t *testing.T, n int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	r, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := []*Stream{r.Stream(), r.Stream()}
+	for i := 0; i < n; i++ {
+		streams[i%2].Add(sample(i))
+		if (i+1)%(2*blockRecords) == 0 {
+			deadline := time.Now().Add(5 * time.Second)
+			for r.Written() < int64(i+1) && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if r.Written() != int64(n) || r.Dropped() != 0 {
+		t.Fatalf("written %d, dropped %d, want %d, 0", r.Written(), r.Dropped(), n)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	const n = 5000
+	data := record(t, n)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Header(); h.Version != Version || h.RecordSize != RecordSize || h.StartUs == 0 {
+		t.Errorf("header = %+v", h)
+	}
+	seen := make(map[uint64]bool)
+	var s Sample
+	for {
+		err := r.Next(&s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := sample(int(s.Seq)); s != want {
+			t.Fatalf("sample = %+v, want %+v", s, want)
+		}
+		seen[s.Seq] = true
+	}
+	if len(seen) != n {
+		t.Errorf("read %d samples, want %d", len(seen), n)
+	}
+}
+
+func TestReaderRejectsBadHeaders(t *testing.T) {
+	data := record(t, 1)
+	if _, err := NewReader(strings.NewReader("not a record file at all")); err == nil {
+		t.Error("accepted a bad magic")
+	}
+	future := append([]byte(nil), data...)
+	future[8] = Version + 1
+	if _, err := NewReader(bytes.NewReader(future)); err == nil {
+		t.Error("accepted a future version")
+	}
+}
+
+func TestReaderSkipsUnknownFields(t *testing.T) {
+	// A later version's file with 8 more bytes per record
+	var buf bytes.Buffer
+	header := make([]byte, HeaderSize)
+	encodeHeader(header, Header{Version: Version, RecordSize: RecordSize + 8, StartUs: 1})
+	buf.Write(header)
+	for i := 0; i < 2; i++ {
+		b := make([]byte, RecordSize+8)
+		s := sample(i)
+		encodeSample(b, &s)
+		buf.Write(b)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s Sample
+	for i := 0; i < 2; i++ {
+		if err := r.Next(&s); err != nil || s != sample(i) {
+			t.Fatalf("sample %d = %+v, %v", i, s, err)
+		}
+	}
+	if err := r.Next(&s); err != io.EOF {
+		t.Errorf("Next = %v, want EOF", err)
+	}
+}
+
+func TestTruncatedFile(t *testing.T) {
+	data := record(t, 10)
+	data = data[:len(data)-RecordSize/2]
+
+	var out bytes.Buffer
+	n, err := WriteColumns(&out, bytes.NewReader(data))
+	if !errors.Is(err, ErrTruncated) || n != 9 {
+		t.Fatalf("WriteColumns = %d, %v, want 9, ErrTruncated", n, err)
+	}
+	var doc struct {
+		Count   int64              `json:"count"`
+		Columns map[string][]int64 `json:"columns"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding columns: %v\n%s", err, out.String())
+	}
+	if doc.Count != 9 || len(doc.Columns) != len(columns) {
+		t.Fatalf("count %d with %d columns", doc.Count, len(doc.Columns))
+	}
+	for name, values := range doc.Columns {
+		if len(values) != 9 {
+			t.Errorf("column %s has %d values, want 9", name, len(values))
+		}
+	}
+	if rtt := doc.Columns["rtt_us"]; rtt[0] != 120 {
+		t.Errorf("rtt_us = %d, want 120", rtt[0])
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	data := record(t, 3)
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if n, err := WriteCSV(&out, r); n != 3 || err != nil {
+		t.Fatalf("WriteCSV = %d, %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || lines[0] != "conn,seq,intended_us,send_us,server_recv_us,server_send_us,recv_us,warmup,late,lost,rtt_us,corrected_rtt_us" {
+		t.Fatalf("CSV:\n%s", out.String())
+	}
+	// Samples of each stream come in order, the streams one after another
+	want := "0,0,1700000000000000,1700000000000005,1700000000000060,1700000000000070,1700000000000125,0,0,0,120,125"
+	if lines[1] != want {
+		t.Errorf("first row = %s, want %s", lines[1], want)
+	}
+}
+
+func TestLostSamples(t *testing.T) {
+	var buf bytes.Buffer
+	r, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := r.Stream()
+	s.Add(sample(0))
+	s.Add(Sample{Seq: 1, IntendedUs: 1000, SendUs: 1005, Conn: 2, Flags: FlagLost})
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := WriteCSV(&out, reader); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[2] != "2,1,1000,1005,0,0,0,0,0,1,," {
+		t.Errorf("CSV:\n%s", out.String())
+	}
+
+	out.Reset()
+	if _, err := WriteColumns(&out, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Columns map[string][]*int64 `json:"columns"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding columns: %v\n%s", err, out.String())
+	}
+	if lost := doc.Columns["lost"]; len(lost) != 2 || *lost[0] != 0 || *lost[1] != 1 {
+		t.Errorf("lost column = %v", lost)
+	}
+	if rtt := doc.Columns["rtt_us"]; len(rtt) != 2 || rtt[0] == nil || rtt[1] != nil {
+		t.Errorf("rtt_us column = %v, want a value and null", rtt)
+	}
+}
+
+// blockingWriter blocks writes until unblock is closed.
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+func TestDropsWhenWriterFallsBehind(t *testing.T) {
+	w := blockingWriter{unblock: make(chan struct{})}
+	r, err := NewRecorder(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := r.Stream()
+	const n = 20 * blocksPerStream * blockRecords
+	for i := 0; i < n; i++ {
+		s.Add(sample(i))
+	}
+	if r.Dropped() == 0 {
+		t.Error("no samples dropped with a stuck writer")
+	}
+	close(w.unblock)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Written()+r.Dropped() != n {
+		t.Errorf("written %d + dropped %d, want %d", r.Written(), r.Dropped(), n)
+	}
+}
+
+func TestStreamAddDoesNotAllocate(t *testing.T) {
+	r, err := NewRecorder(io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := r.Stream()
+	sm := sample(1)
+	if allocs := testing.AllocsPerRun(10000, func() { s.Add(sm) }); allocs != 0 {
+		t.Errorf("Add allocates %.1f times per sample", allocs)
+	}
+}
+
+func BenchmarkStreamAdd(b *testing.B) {
+	r, err := NewRecorder(io.Discard)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	s := r.Stream()
+	sm := sample(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Add(sm)
+	}
+}
@@ -0,0 +1,218 @@
+package record
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Buffering per stream. A block is handed to the writer goroutine when it
+// fills; samples are dropped, not waited for, if the writer falls behind by
+// all blocks of a stream.
+const (
+	blockRecords    = 256
+	blocksPerStream = 4
+)
+
+// Recorder writes samples to a record file. Samples are added through
+// Streams, which copy them into preallocated blocks without allocating or
+// blocking; a background goroutine writes full blocks to the file, so disk
+// I/O never delays the code being measured.
+type Recorder struct {
+	w      *bufio.Writer
+	closer io.Closer // The file, if the Recorder created it
+
+	mu      sync.Mutex
+	streams []*Stream
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+	err     error // First write error, owned by the writer goroutine
+
+	written atomic.Int64
+	dropped atomic.Int64
+}
+
+// Create creates the record file path and returns a Recorder writing to it.
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file: %w", err)
+	}
+	r, err := NewRecorder(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewRecorder writes a record file header to w and returns a Recorder
+// writing samples after it.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		w:    bufio.NewWriterSize(w, 64*1024),
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	var header [HeaderSize]byte
+	encodeHeader(header[:], Header{Version: Version, RecordSize: RecordSize, StartUs: time.Now().UnixNano() / 1000})
+	if _, err := r.w.Write(header[:]); err != nil {
+		return nil, fmt.Errorf("failed to write record header: %w", err)
+	}
+	go r.run()
+	return r, nil
+}
+
+// Stream returns a new stream of samples into the file. A stream is meant
+// for one goroutine at a time, such as the reader of one connection.
+func (r *Recorder) Stream() *Stream {
+	s := &Stream{
+		r:    r,
+		free: make(chan []byte, blocksPerStream),
+		full: make(chan []byte, blocksPerStream),
+	}
+	for i := 0; i < blocksPerStream; i++ {
+		s.free <- make([]byte, 0, blockRecords*RecordSize)
+	}
+	r.mu.Lock()
+	r.streams = append(r.streams, s)
+	r.mu.Unlock()
+	return s
+}
+
+// Written returns the number of samples written to the file so far.
+func (r *Recorder) Written() int64 {
+	return r.written.Load()
+}
+
+// Dropped returns the number of samples dropped because the writer fell
+// behind.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close flushes the samples of all streams, which take no more samples, and
+// closes the file if the Recorder created it.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	streams := r.streams
+	r.mu.Unlock()
+	for _, s := range streams {
+		s.close()
+	}
+	close(r.quit)
+	<-r.done
+
+	err := r.err
+	if flushErr := r.w.Flush(); flushErr != nil && err == nil {
+		err = fmt.Errorf("failed to write record file: %w", flushErr)
+	}
+	if r.closer != nil {
+		if closeErr := r.closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// run writes full blocks until Close, then whatever is left.
+func (r *Recorder) run() {
+	defer close(r.done)
+	for {
+		select {
+		case <-r.wake:
+			r.drain()
+		case <-r.quit:
+			r.drain()
+			return
+		}
+	}
+}
+
+// drain writes the full blocks of every stream and returns them to it.
+func (r *Recorder) drain() {
+	r.mu.Lock()
+	streams := r.streams
+	r.mu.Unlock()
+	for _, s := range streams {
+		for {
+			var block []byte
+			select {
+			case block = <-s.full:
+			default:
+			}
+			if block == nil {
+				break
+			}
+			if r.err == nil {
+				if _, err := r.w.Write(block); err != nil {
+					r.err = fmt.Errorf("failed to write record file: %w", err)
+				} else {
+					r.written.Add(int64(len(block) / RecordSize))
+				}
+			}
+			s.free <- block[:0]
+		}
+	}
+}
+
+// Stream is a source of samples for a Recorder.
+type Stream struct {
+	r      *Recorder
+	mu     sync.Mutex // Uncontended unless a reconnect briefly overlaps two readers
+	block  []byte     // Block being filled, nil if none was free
+	free   chan []byte
+	full   chan []byte
+	closed bool
+}
+
+// Add records a sample. It never allocates or blocks on I/O; if the writer
+// has fallen behind, the sample is counted as dropped.
+func (s *Stream) Add(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.block == nil {
+		select {
+		case s.block = <-s.free:
+		default:
+			s.r.dropped.Add(1)
+			return
+		}
+	}
+	n := len(s.block)
+	s.block = s.block[:n+RecordSize]
+	encodeSample(s.block[n:], &sample)
+	if len(s.block) == cap(s.block) {
+		s.hand()
+	}
+}
+
+// hand passes the current block to the writer goroutine.
+func (s *Stream) hand() {
+	s.full <- s.block
+	s.block = nil
+	select {
+	case s.r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close hands over the partly filled block and stops taking samples.
+func (s *Stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.block != nil && len(s.block) > 0 {
+		s.hand()
+	}
+	s.closed = true
+}
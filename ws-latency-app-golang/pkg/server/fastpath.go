@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+)
+
+// Keys of the server timestamps in JSON messages
+var (
+	serverRecvKey = []byte(`"server_ts_us":`)
+	serverSendKey = []byte(`"server_send_ts_us":`)
+)
+
+// fastEcho stamps the server timestamps into message without decoding it,
+// and returns the response: message itself, or a copy appended to dst if a
+// timestamp did not fit. It returns nil for messages it cannot stamp, which
+// take the full path of processMessage or processFrame.
+func (s *Server) fastEcho(c codec.Codec, message, dst []byte, recvUs int64) []byte {
+	var response []byte
+	switch c {
+	case codec.JSON:
+		response = stampJSON(message, dst, recvUs)
+	case codec.Binary:
+		response = stampBinary(message, recvUs)
+	}
+	if response != nil {
+		s.metrics.observeProcessing(recvUs)
+	}
+	return response
+}
+
+// stampJSON writes the server timestamps over the values of the last
+// server_ts_us and server_send_ts_us keys of a JSON message. The JSON codec
+// writes "_test" last with space-padded values, so they are found quickly and
+// overwritten in place. Narrower values, as sent by other clients, are
+// widened in a copy appended to dst. Messages without both keys, or with
+// values that are not numbers, return nil.
+func stampJSON(message, dst []byte, recvUs int64) []byte {
+	recv, ok := numberSpan(message, serverRecvKey)
+	if !ok {
+		return nil
+	}
+	send, ok := numberSpan(message, serverSendKey)
+	if !ok || recv == send {
+		return nil
+	}
+
+	var recvBuf, sendBuf [20]byte
+	recvDigits := strconv.AppendInt(recvBuf[:0], recvUs, 10)
+	sendDigits := strconv.AppendInt(sendBuf[:0], time.Now().UnixNano()/1000, 10)
+	if len(recvDigits) <= recv.len() && len(sendDigits) <= send.len() {
+		recv.fill(message, recvDigits)
+		send.fill(message, sendDigits)
+		return message
+	}
+
+	// Splice the values in, in the order they appear
+	first, second := recv, send
+	firstDigits, secondDigits := recvDigits, sendDigits
+	if send.start < recv.start {
+		first, second = send, recv
+		firstDigits, secondDigits = sendDigits, recvDigits
+	}
+	dst = append(dst, message[:first.start]...)
+	dst = append(dst, firstDigits...)
+	dst = append(dst, message[first.end:second.start]...)
+	dst = append(dst, secondDigits...)
+	return append(dst, message[second.end:]...)
+}
+
+// stampSend writes the current time over the value of the last
+// server_send_ts_us key of a JSON message in place, if the value is at least
+// as wide. Messages without the key are left unchanged.
+func stampSend(message []byte) {
+	send, ok := numberSpan(message, serverSendKey)
+	if !ok {
+		return
+	}
+	var buf [20]byte
+	if digits := strconv.AppendInt(buf[:0], time.Now().UnixNano()/1000, 10); len(digits) <= send.len() {
+		send.fill(message, digits)
+	}
+}
+
+// span is the byte range [start, end) of a value in a message.
+type span struct {
+	start, end int
+}
+
+func (s span) len() int { return s.end - s.start }
+
+// fill writes digits at the start of s in message and spaces after them.
+func (s span) fill(message, digits []byte) {
+	n := copy(message[s.start:s.end], digits)
+	for i := s.start + n; i < s.end; i++ {
+		message[i] = ' '
+	}
+}
+
+// numberSpan returns the span of the number value of the last occurrence of
+// key in message, including surrounding whitespace, up to the following
+// comma or closing brace.
+func numberSpan(message, key []byte) (span, bool) {
+	i := bytes.LastIndex(message, key)
+	if i < 0 {
+		return span{}, false
+	}
+	s := span{start: i + len(key)}
+	digits := false
+	for s.end = s.start; s.end < len(message); s.end++ {
+		switch b := message[s.end]; {
+		case b >= '0' && b <= '9':
+			digits = true
+		case b == ' ' || b == '\t' || b == '\r' || b == '\n' || b == '-' || b == '+' || b == '.' || b == 'e' || b == 'E':
+		case b == ',' || b == '}':
+			return s, digits
+		default:
+			return span{}, false
+		}
+	}
+	return span{}, false
+}
+
+// stampBinary writes the server timestamps into their fixed offsets of a
+// binary codec frame. Frames of another version return nil.
+func stampBinary(message []byte, recvUs int64) []byte {
+	if len(message) < codec.BinaryHeaderSize || message[0] != codec.BinaryVersion {
+		return nil
+	}
+	binary.LittleEndian.PutUint64(message[codec.BinaryServerRecvAt:], uint64(recvUs))
+	binary.LittleEndian.PutUint64(message[codec.BinaryServerSendAt:], uint64(time.Now().UnixNano()/1000))
+	return message
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverMetrics are the Prometheus metrics served at /metrics. Counter names
+// follow the Java implementation's MetricsManager (ws_events_sent_total,
+// ws_events_received_total, ws_errors_total), so dashboards work for either.
+type serverMetrics struct {
+	registry   *prometheus.Registry
+	active     *prometheus.GaugeVec
+	received   *prometheus.CounterVec
+	sent       *prometheus.CounterVec
+	pings      *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	processing prometheus.Histogram
+}
+
+// newServerMetrics creates the metrics in a registry of their own, along with
+// the standard Go runtime and process metrics.
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		active: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ws_connections_active",
+			Help: "WebSocket connections currently open, by endpoint",
+		}, []string{"endpoint"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_events_received_total",
+			Help: "Messages received from clients, by endpoint",
+		}, []string{"endpoint"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_events_sent_total",
+			Help: "Messages sent to clients, including pushed events, by endpoint",
+		}, []string{"endpoint"}),
+		pings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_pings_received_total",
+			Help: "WebSocket pings received from clients and answered, by endpoint",
+		}, []string{"endpoint"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_errors_total",
+			Help: "Read, write and message processing errors",
+		}, []string{"op"}),
+		processing: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ws_server_processing_seconds",
+			Help:    "Time from reading a message to its response being ready to write",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 2, 20), // 1µs to about 0.5s
+		}),
+	}
+	m.registry.MustRegister(m.active, m.received, m.sent, m.pings, m.errors, m.processing,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// handler serves the metrics in the Prometheus text format.
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// endpoint returns the metrics of connections to endpoint.
+func (m *serverMetrics) endpoint(endpoint string) *endpointMetrics {
+	return &endpointMetrics{
+		active:      m.active.WithLabelValues(endpoint),
+		received:    m.received.WithLabelValues(endpoint),
+		sent:        m.sent.WithLabelValues(endpoint),
+		pings:       m.pings.WithLabelValues(endpoint),
+		readErrors:  m.errors.WithLabelValues("read"),
+		writeErrors: m.errors.WithLabelValues("write"),
+	}
+}
+
+// observeProcessing records the processing time of a message read at recvUs.
+func (m *serverMetrics) observeProcessing(recvUs int64) {
+	m.processing.Observe(float64(time.Now().UnixNano()/1000-recvUs) / 1e6)
+}
+
+// endpointMetrics are the metrics of connections to one endpoint, resolved
+// once per connection.
+type endpointMetrics struct {
+	active      prometheus.Gauge
+	received    prometheus.Counter
+	sent        prometheus.Counter
+	pings       prometheus.Counter
+	readErrors  prometheus.Counter
+	writeErrors prometheus.Counter
+}
+
+// readError counts err unless the client just disconnected, with or without
+// a close frame; load-test clients often close the TCP connection directly.
+func (m *endpointMetrics) readError(err error) {
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+		m.readErrors.Inc()
+	}
+}
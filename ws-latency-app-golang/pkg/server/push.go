@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pushQueueSize is the number of events queued per subscriber. When a
+// subscriber falls further behind, new events are dropped for it and it sees
+// a gap in the event sequence, as with a real market-data feed.
+const pushQueueSize = 1024
+
+// subscriber is a connection receiving pushed events. Everything sent to it
+// goes through its queue, which its own goroutine writes out.
+type subscriber struct {
+	conn    *websocket.Conn
+	send    chan []byte
+	dropped atomic.Int64 // Messages dropped because the queue was full
+	metrics *endpointMetrics
+}
+
+// newSubscriber creates a subscriber with an empty queue for conn, counted in
+// metrics.
+func newSubscriber(conn *websocket.Conn, metrics *endpointMetrics) *subscriber {
+	return &subscriber{conn: conn, send: make(chan []byte, pushQueueSize), metrics: metrics}
+}
+
+// enqueue queues message, dropping it if the queue is full.
+func (sub *subscriber) enqueue(message []byte) {
+	select {
+	case sub.send <- message:
+	default:
+		sub.dropped.Add(1)
+	}
+}
+
+// writeLoop writes queued messages until the queue is closed, stamping the
+// send time of those that have one right before writing them, so time spent
+// in the queue counts as server time. After a write error the connection is
+// closed and the queue drained.
+func (sub *subscriber) writeLoop() {
+	var buf []byte
+	for message := range sub.send {
+		// Events are shared with other subscribers, so stamp a copy
+		buf = append(buf[:0], message...)
+		stampSend(buf)
+		if err := sub.conn.WriteMessage(websocket.TextMessage, buf); err != nil {
+			log.Println("Write error:", err)
+			sub.metrics.writeErrors.Inc()
+			sub.conn.Close()
+			for range sub.send {
+				// Drain until the subscriber is removed
+			}
+			return
+		}
+		sub.metrics.sent.Inc()
+	}
+}
+
+// broadcaster pushes events of one channel and instrument to every subscriber
+// at a fixed rate. Each event is encoded once with the time it was generated
+// and queued for every subscriber, whose writer stamps its send time.
+type broadcaster struct {
+	channel     string
+	instID      string
+	rate        int
+	pad         string
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	sequence    uint64
+}
+
+// newBroadcaster creates a broadcaster without subscribers whose events
+// encode to about payloadSize bytes.
+func newBroadcaster(channel, instID string, rate, payloadSize int) *broadcaster {
+	b := &broadcaster{
+		channel:     channel,
+		instID:      instID,
+		rate:        rate,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+	b.pad = b.padding(payloadSize)
+	return b
+}
+
+// run generates events until stop is closed. Events are generated at fixed
+// intended times; if generation falls behind, the backlog is sent at once
+// rather than skipped.
+func (b *broadcaster) run(stop <-chan struct{}) {
+	interval := time.Second / time.Duration(b.rate)
+	start := time.Now()
+	for i := 0; ; i++ {
+		if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		} else {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+		b.broadcast()
+	}
+}
+
+// broadcast sends the next event to every subscriber. With no subscribers, no
+// event is generated, so sequences start where subscribers join.
+func (b *broadcaster) broadcast() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) == 0 {
+		return
+	}
+
+	b.sequence++
+	message, err := json.Marshal(newEvent(b.channel, b.instID, b.sequence, time.Now().UnixNano()/1000, b.pad))
+	if err != nil {
+		log.Println("JSON marshal error:", err)
+		return
+	}
+	for sub := range b.subscribers {
+		sub.enqueue(message)
+	}
+}
+
+// padding returns the pad that makes events encode to about payloadSize
+// bytes.
+func (b *broadcaster) padding(payloadSize int) string {
+	message, err := json.Marshal(newEvent(b.channel, b.instID, 1, time.Now().UnixNano()/1000, ""))
+	if err != nil {
+		return ""
+	}
+	// The pad field adds `,"_pad":""` plus its value
+	const padOverhead = 10
+	if n := payloadSize - len(message) - padOverhead; n > 0 {
+		return strings.Repeat("x", n)
+	}
+	return ""
+}
+
+// add adds sub to the subscribers.
+func (b *broadcaster) add(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+}
+
+// remove removes sub and returns the number of subscribers left. Once it
+// returns, no more events are queued to sub.
+func (b *broadcaster) remove(sub *subscriber) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, sub)
+	return len(b.subscribers)
+}
+
+// newEvent creates an exchange-style event of channel for instID with the
+// given sequence number and generation timestamp, which is also the
+// placeholder of the send timestamp. Trades and order book channels get their
+// own data fields; other channels get ticker fields.
+func newEvent(channel, instID string, sequence uint64, genUs int64, pad string) map[string]interface{} {
+	last := 105000 + rand.Float64()*2000
+	ts := strconv.FormatInt(genUs/1000, 10)
+	var data map[string]interface{}
+	switch channel {
+	case "trades":
+		side := "buy"
+		if rand.Intn(2) == 0 {
+			side = "sell"
+		}
+		data = map[string]interface{}{
+			"instId":  instID,
+			"tradeId": strconv.FormatUint(sequence, 10),
+			"px":      strconv.FormatFloat(last, 'f', 1, 64),
+			"sz":      strconv.FormatFloat(rand.Float64(), 'f', 8, 64),
+			"side":    side,
+			"ts":      ts,
+		}
+	case "books", "books5":
+		asks := make([][]string, 5)
+		bids := make([][]string, 5)
+		for i := range asks {
+			asks[i] = []string{strconv.FormatFloat(last+0.1*float64(i+1), 'f', 1, 64), strconv.FormatFloat(rand.Float64(), 'f', 8, 64), "0", "1"}
+			bids[i] = []string{strconv.FormatFloat(last-0.1*float64(i), 'f', 1, 64), strconv.FormatFloat(rand.Float64(), 'f', 8, 64), "0", "1"}
+		}
+		data = map[string]interface{}{"instId": instID, "asks": asks, "bids": bids, "ts": ts}
+	default:
+		data = map[string]interface{}{
+			"instType": "SPOT",
+			"instId":   instID,
+			"last":     strconv.FormatFloat(last, 'f', 1, 64),
+			"lastSz":   strconv.FormatFloat(rand.Float64(), 'f', 8, 64),
+			"askPx":    strconv.FormatFloat(last+0.1, 'f', 1, 64),
+			"bidPx":    strconv.FormatFloat(last, 'f', 1, 64),
+			"ts":       ts,
+		}
+	}
+
+	event := map[string]interface{}{
+		"arg":  map[string]interface{}{"channel": channel, "instId": instID},
+		"data": []map[string]interface{}{data},
+		"_test": map[string]interface{}{
+			"sequence":          sequence,
+			"server_gen_ts_us":  genUs,
+			"server_send_ts_us": genUs,
+		},
+	}
+	if pad != "" {
+		event["_pad"] = pad
+	}
+	return event
+}
+
+// handlePush handles WebSocket connections subscribing to pushed events.
+// Messages the client sends, such as clock probes, are answered like on /ws.
+func (s *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	m := s.metrics.endpoint("/push")
+	m.active.Inc()
+	defer m.active.Dec()
+	answerPings(conn, m)
+	sub := newSubscriber(conn, m)
+	s.push.add(sub)
+	log.Printf("Subscriber connected - %s", conn.RemoteAddr())
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		sub.writeLoop()
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			m.readError(err)
+			break
+		}
+		m.received.Inc()
+		response, err := s.processMessage(message, time.Now().UnixNano()/1000)
+		if err != nil {
+			log.Println("Process error:", err)
+			continue
+		}
+		sub.enqueue(response)
+	}
+
+	s.push.remove(sub)
+	close(sub.send)
+	<-writerDone
+	log.Printf("Subscriber disconnected - %s, %d events dropped", conn.RemoteAddr(), sub.dropped.Load())
+}
@@ -2,30 +2,62 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"ws-latency-app-golang/pkg/codec"
+
 	"github.com/gorilla/websocket"
 )
 
 // Config holds the configuration for the WebSocket server
 type Config struct {
 	Port string
+
+	// TLS termination. With a certificate and key, or TLSSelfSigned, the
+	// server serves wss:// instead of ws://.
+	TLSCertFile   string // PEM server certificate chain
+	TLSKeyFile    string // PEM private key for TLSCertFile
+	TLSSelfSigned bool   // Generate an ephemeral self-signed certificate at startup
+
+	// Push mode. With a positive PushRate, clients connecting to /push
+	// receive ticker events at that rate.
+	PushRate        int // Events per second
+	PushPayloadSize int // Approximate encoded event size in bytes; 0 leaves events unpadded, also for Channels
+
+	// Channels that clients of /ws/v5/public can subscribe to, with their
+	// publish rates. Empty disables the endpoint.
+	Channels []ChannelRate
+
+	// FastEcho stamps /ws messages in the JSON and binary codecs without
+	// decoding them, overwriting the server timestamps in place
+	FastEcho bool
 }
 
 // Server represents a WebSocket server for latency testing
 type Server struct {
 	config   Config
 	upgrader websocket.Upgrader
+	echo     websocket.Upgrader // Upgrader of /ws, which also negotiates codecs
+	metrics  *serverMetrics
+	push     *broadcaster
+	topicsMu sync.Mutex
+	topics   map[opArg]*topic
 }
 
 // NewServer creates a new WebSocket server with the given configuration
 func NewServer(config Config) *Server {
-	return &Server{
-		config: config,
+	s := &Server{
+		config:  config,
+		metrics: newServerMetrics(),
+		topics:  make(map[opArg]*topic),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -34,21 +66,79 @@ func NewServer(config Config) *Server {
 			},
 		},
 	}
+	s.echo = s.upgrader
+	s.echo.Subprotocols = codec.Subprotocols()
+	return s
 }
 
 // Start starts the WebSocket server
 func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", ":"+s.config.Port)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves WebSocket, health check and metrics requests on listener,
+// terminating TLS first if it is configured.
+func (s *Server) Serve(listener net.Listener) error {
+	mux := http.NewServeMux()
+
 	// Add a health check endpoint
-	http.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/health", s.handleHealth)
+
+	// Prometheus metrics
+	mux.Handle("/metrics", s.metrics.handler())
 
 	// Set up WebSocket handler
-	http.HandleFunc("/ws", s.handleConnection)
+	mux.HandleFunc("/ws", s.handleConnection)
+	if s.config.FastEcho {
+		log.Println("Fast-path echo enabled: /ws messages are stamped without parsing")
+	}
+
+	// Push events to subscribers until the server stops
+	if s.config.PushRate > 0 {
+		s.push = newBroadcaster("tickers", "BTC-USDC", s.config.PushRate, s.config.PushPayloadSize)
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.push.run(stop)
+		log.Printf("Pushing events at %d/s to subscribers at /push\n", s.config.PushRate)
+		mux.HandleFunc("/push", s.handlePush)
+	}
+
+	// Subscription protocol endpoint, named after the OKX public endpoint
+	if len(s.config.Channels) > 0 {
+		var channels []string
+		for _, c := range s.config.Channels {
+			if c.InstID == "" {
+				channels = append(channels, fmt.Sprintf("%s (%d/s)", c.Channel, c.Rate))
+			} else {
+				channels = append(channels, fmt.Sprintf("%s %s (%d/s)", c.Channel, c.InstID, c.Rate))
+			}
+		}
+		log.Printf("Subscriptions available at /ws/v5/public: %s\n", strings.Join(channels, ", "))
+		mux.HandleFunc("/ws/v5/public", s.handlePublic)
+	}
+
+	wsScheme, httpScheme := "ws", "http"
+	if s.config.tlsEnabled() {
+		tlsConfig, err := newTLSConfig(s.config)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		wsScheme, httpScheme = "wss", "https"
+	}
 
 	// Start server
-	log.Printf("WebSocket server starting on port %s...\n", s.config.Port)
-	log.Printf("Connect to: ws://localhost:%s/ws\n", s.config.Port)
-	log.Printf("Health check available at: http://localhost:%s/health\n", s.config.Port)
-	return http.ListenAndServe(":"+s.config.Port, nil)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	log.Printf("WebSocket server starting on port %s...\n", port)
+	log.Printf("Connect to: %s://localhost:%s/ws\n", wsScheme, port)
+	log.Printf("Health check available at: %s://localhost:%s/health\n", httpScheme, port)
+	log.Printf("Metrics available at: %s://localhost:%s/metrics\n", httpScheme, port)
+	return http.Serve(listener, mux)
 }
 
 // handleHealth handles health check requests
@@ -58,36 +148,90 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(`{"status":"healthy","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
 }
 
-// processMessage processes a WebSocket message by adding server timestamp
-func (s *Server) processMessage(message []byte) ([]byte, error) {
+// processMessage processes a WebSocket message by adding the server receive
+// timestamp recvUs and, once the response is encoded, the send timestamp.
+// Their difference is the time the message spent in the server, including
+// encoding the response.
+func (s *Server) processMessage(message []byte, recvUs int64) ([]byte, error) {
 	// Parse message
 	var data map[string]interface{}
 	if err := json.Unmarshal(message, &data); err != nil {
+		s.metrics.errors.WithLabelValues("process").Inc()
 		return nil, err
 	}
 
-	// Add server timestamp
-	if test, ok := data["_test"].(map[string]interface{}); ok {
-		test["server_ts_us"] = time.Now().UnixNano() / 1000
-	} else {
-		test := make(map[string]interface{})
-		test["server_ts_us"] = time.Now().UnixNano() / 1000
+	// Add server timestamps
+	test, ok := data["_test"].(map[string]interface{})
+	if !ok {
+		test = make(map[string]interface{})
 		data["_test"] = test
 	}
+	test["server_ts_us"] = recvUs
+	test["server_send_ts_us"] = recvUs // Placeholder as wide as the send time
+
+	// Serialize, then write the send time over the placeholder as the last
+	// step before the response is written
+	response, err := json.Marshal(data)
+	if err != nil {
+		s.metrics.errors.WithLabelValues("process").Inc()
+		return nil, err
+	}
+	s.metrics.observeProcessing(recvUs)
+	if stamped := stampJSON(response, nil, recvUs); stamped != nil {
+		response = stamped
+	}
+	return response, nil
+}
+
+// processFrame is processMessage for messages in a codec other than JSON. It
+// decodes into m and appends the response to dst, so both can be reused.
+func (s *Server) processFrame(c codec.Codec, m *codec.Message, message, dst []byte, recvUs int64) ([]byte, error) {
+	if err := c.Decode(message, m); err != nil {
+		s.metrics.errors.WithLabelValues("process").Inc()
+		return nil, err
+	}
+	m.ServerRecvUs = recvUs
+	m.ServerSendUs = recvUs // Placeholder
 
-	// Serialize and return
-	return json.Marshal(data)
+	// Encode, then write the send time into its slot as the last step before
+	// the response is written
+	response, slots, err := c.AppendTemplate(dst, m)
+	if err != nil {
+		s.metrics.errors.WithLabelValues("process").Inc()
+		return nil, err
+	}
+	s.metrics.observeProcessing(recvUs)
+	slots.SetServerSend(response, time.Now().UnixNano()/1000)
+	return response, nil
+}
+
+// answerPings replies to every ping on conn with a pong carrying the same
+// payload, as the default handler does, and counts the pings. A client
+// that measures ping RTT through a load balancer can tell from the count
+// whether its pings reach the server or are answered on the way.
+func answerPings(conn *websocket.Conn, m *endpointMetrics) {
+	conn.SetPingHandler(func(appData string) error {
+		m.pings.Inc()
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+		if _, ok := err.(net.Error); ok || err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
 }
 
 // handleConnection handles WebSocket connections
 func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, r, nil)
+	conn, err := s.echo.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Upgrade error:", err)
 		return
 	}
 	defer conn.Close()
 
+	// Only codec subprotocols are accepted, and none selects JSON
+	c := codec.BySubprotocol(conn.Subprotocol())
+
 	// Get client IP address
 	clientIP := r.Header.Get("X-Forwarded-For")
 	if clientIP == "" {
@@ -106,32 +250,59 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 		tcpIP = "unknown"
 	}
 
-	log.Printf("Client connected - HTTP IP: %s, TCP IP: %s", clientIP, tcpIP)
+	log.Printf("Client connected - HTTP IP: %s, TCP IP: %s, codec: %s", clientIP, tcpIP, c.Name())
 
 	// Set TCP_NODELAY to disable Nagle's algorithm for lower latency
-	if tcpConn, ok := conn.UnderlyingConn().(*net.TCPConn); ok {
+	netConn := conn.UnderlyingConn()
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := netConn.(*net.TCPConn); ok {
 		tcpConn.SetNoDelay(true)
 	}
 
+	m := s.metrics.endpoint("/ws")
+	m.active.Inc()
+	defer m.active.Dec()
+	answerPings(conn, m)
+	var msg codec.Message
+	var buf []byte
 	for {
 		messageType, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("Read error:", err)
+			m.readError(err)
 			break
 		}
+		recvUs := time.Now().UnixNano() / 1000
+		m.received.Inc()
 
-		// Process the message
-		response, err := s.processMessage(message)
-		if err != nil {
-			log.Println("Process error:", err)
-			continue
+		// Process the message. JSON messages are processed generically,
+		// keeping fields the codec does not know for other clients.
+		var response []byte
+		if s.config.FastEcho {
+			response = s.fastEcho(c, message, buf[:0], recvUs)
+		}
+		if response == nil {
+			if c == codec.JSON {
+				response, err = s.processMessage(message, recvUs)
+			} else {
+				response, err = s.processFrame(c, &msg, message, buf[:0], recvUs)
+			}
+			if err != nil {
+				log.Println("Process error:", err)
+				continue
+			}
 		}
+		buf = response
 
 		// Send the response
 		if err := conn.WriteMessage(messageType, response); err != nil {
 			log.Println("Write error:", err)
+			m.writeErrors.Inc()
 			break
 		}
+		m.sent.Inc()
 	}
 
 	log.Printf("Client disconnected - HTTP IP: %s, TCP IP: %s", clientIP, tcpIP)
@@ -0,0 +1,608 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ws-latency-app-golang/pkg/codec"
+
+	"github.com/gorilla/websocket"
+)
+
+// startServer serves config on a loopback port and returns its address.
+func startServer(t *testing.T, config Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go NewServer(config).Serve(listener)
+	return listener.Addr().String()
+}
+
+// echo sends one test message over url and returns the response's _test field.
+func echo(t *testing.T, dialer *websocket.Dialer, url string) map[string]interface{} {
+	t.Helper()
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Dial %s: %v", url, err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"_test":{"sequence":1}}`)); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(message, &data); err != nil {
+		t.Fatal(err)
+	}
+	test := data["_test"].(map[string]interface{})
+	recvUs, _ := test["server_ts_us"].(float64)
+	sendUs, _ := test["server_send_ts_us"].(float64)
+	if test["sequence"] != 1.0 || recvUs == 0 || sendUs < recvUs {
+		t.Errorf("unexpected response %s", message)
+	}
+	return test
+}
+
+func TestServeWS(t *testing.T) {
+	addr := startServer(t, Config{})
+	echo(t, websocket.DefaultDialer, "ws://"+addr+"/ws")
+}
+
+func TestServeSelfSigned(t *testing.T) {
+	addr := startServer(t, Config{TLSSelfSigned: true})
+
+	// The certificate is not trusted by default
+	if _, _, err := websocket.DefaultDialer.Dial("wss://"+addr+"/ws", nil); err == nil {
+		t.Error("self-signed certificate was accepted without -insecure")
+	}
+
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	echo(t, &dialer, "wss://"+addr+"/ws")
+}
+
+func TestServeCertFiles(t *testing.T) {
+	cert, err := generateSelfSignedCert([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	addr := startServer(t, Config{TLSCertFile: certFile, TLSKeyFile: keyFile})
+
+	// Verify the certificate properly against itself as the CA
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = &tls.Config{RootCAs: roots}
+	echo(t, &dialer, "wss://"+addr+"/ws")
+	_, port, _ := net.SplitHostPort(addr)
+	echo(t, &dialer, "wss://localhost:"+port+"/ws")
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	for _, config := range []Config{
+		{TLSCertFile: "server.pem"},
+		{TLSKeyFile: "server-key.pem"},
+		{TLSCertFile: "/nonexistent/server.pem", TLSKeyFile: "/nonexistent/server-key.pem"},
+		{TLSCertFile: "server.pem", TLSKeyFile: "server-key.pem", TLSSelfSigned: true},
+	} {
+		if _, err := newTLSConfig(config); err == nil {
+			t.Errorf("newTLSConfig(%+v) succeeded, want error", config)
+		}
+	}
+}
+
+func TestProcessMessage(t *testing.T) {
+	s := NewServer(Config{})
+	recvUs := time.Now().UnixNano()/1000 - 1000
+	before := time.Now().UnixNano() / 1000
+	response, err := s.processMessage([]byte(`{"symbol":"BTCUSDT"}`), recvUs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(response, &data); err != nil {
+		t.Fatal(err)
+	}
+	test := data["_test"].(map[string]interface{})
+	if data["symbol"] != "BTCUSDT" || test["server_ts_us"] != float64(recvUs) {
+		t.Errorf("unexpected response %s", response)
+	}
+	// The send time is stamped over its placeholder after encoding
+	if sendUs := test["server_send_ts_us"].(float64); sendUs < float64(before) {
+		t.Errorf("server_send_ts_us = %.0f, want at least %d", sendUs, before)
+	}
+
+	if _, err := s.processMessage([]byte("not json"), recvUs); err == nil {
+		t.Error("processMessage accepted invalid JSON")
+	}
+}
+
+func TestProcessFrame(t *testing.T) {
+	s := NewServer(Config{})
+	recvUs := time.Now().UnixNano()/1000 - 1000
+	for _, c := range codec.Codecs {
+		if c == codec.JSON {
+			continue
+		}
+		sent := codec.Message{Sequence: 5, ClientSendUs: 1, Pad: []byte("pad")}
+		message, _ := c.Append(nil, &sent)
+		before := time.Now().UnixNano() / 1000
+		var m codec.Message
+		response, err := s.processFrame(c, &m, message, nil, recvUs)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		var got codec.Message
+		if err := c.Decode(response, &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		// The send time is written into its slot after encoding
+		if got.Sequence != 5 || got.ServerRecvUs != recvUs || got.ServerSendUs < before {
+			t.Errorf("%s: unexpected response %+v", c.Name(), got)
+		}
+	}
+}
+
+func TestServeCodecs(t *testing.T) {
+	for _, fast := range []bool{false, true} {
+		testServeCodecs(t, Config{FastEcho: fast})
+	}
+}
+
+func testServeCodecs(t *testing.T, config Config) {
+	addr := startServer(t, config)
+	for _, c := range codec.Codecs {
+		dialer := *websocket.DefaultDialer
+		dialer.Subprotocols = []string{codec.Subprotocol(c)}
+		conn, _, err := dialer.Dial("ws://"+addr+"/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if conn.Subprotocol() != codec.Subprotocol(c) {
+			t.Errorf("%s: negotiated subprotocol %q", c.Name(), conn.Subprotocol())
+		}
+
+		sent := codec.Message{Sequence: 5, ClientSendUs: 1, Ticker: codec.DefaultTicker(), Pad: []byte("pad")}
+		message, _ := c.Append(nil, &sent)
+		if err := conn.WriteMessage(c.MessageType(), message); err != nil {
+			t.Fatal(err)
+		}
+		messageType, response, err := conn.ReadMessage()
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got codec.Message
+		if err := c.Decode(response, &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if messageType != c.MessageType() || got.Sequence != 5 || string(got.Pad) != "pad" || got.ServerRecvUs == 0 || got.ServerSendUs < got.ServerRecvUs {
+			t.Errorf("%s: unexpected response %+v", c.Name(), got)
+		}
+	}
+
+}
+
+func TestSubprotocolOnlyOnWS(t *testing.T) {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = []string{codec.Subprotocol(codec.Binary)}
+	addr := startServer(t, Config{PushRate: 10})
+	conn, _, err := dialer.Dial("ws://"+addr+"/push", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != "" {
+		t.Errorf("/push negotiated %q", conn.Subprotocol())
+	}
+}
+
+func TestStampJSON(t *testing.T) {
+	recvUs := time.Now().UnixNano() / 1000
+	padded, _ := codec.JSON.Append(nil, &codec.Message{Sequence: 9, Ticker: codec.DefaultTicker()})
+	tests := []struct {
+		name    string
+		message string
+		inPlace bool
+	}{
+		{"json codec", string(padded), true},
+		{"compact", `{"_test":{"sequence":9,"server_ts_us":0,"server_send_ts_us":0},"x":1}`, false},
+		{"send first", `{"_test":{"server_send_ts_us":0,"sequence":9,"server_ts_us": 0 }}`, false},
+	}
+	for _, tt := range tests {
+		message := []byte(tt.message)
+		response := stampJSON(message, nil, recvUs)
+		if response == nil {
+			t.Fatalf("%s: not stamped", tt.name)
+		}
+		if inPlace := &response[0] == &message[0]; inPlace != tt.inPlace {
+			t.Errorf("%s: stamped in place = %v, want %v", tt.name, inPlace, tt.inPlace)
+		}
+		var got codec.Message
+		if err := codec.JSON.Decode(response, &got); err != nil {
+			t.Fatalf("%s: %v: %s", tt.name, err, response)
+		}
+		if got.Sequence != 9 || got.ServerRecvUs != recvUs || got.ServerSendUs < recvUs {
+			t.Errorf("%s: stamped %s", tt.name, response)
+		}
+	}
+
+	// Messages without number timestamps take the full path
+	for _, message := range []string{
+		`{"_test":{"sequence":1}}`,
+		`{"_test":{"server_ts_us":0}}`,
+		`{"_test":{"server_ts_us":"0","server_send_ts_us":0}}`,
+		`{"_test":{"server_ts_us":,"server_send_ts_us":0}}`,
+		`{"_test":{"server_ts_us":0,"server_send_ts_us":0`,
+	} {
+		if response := stampJSON([]byte(message), nil, recvUs); response != nil {
+			t.Errorf("stamped %s as %s", message, response)
+		}
+	}
+}
+
+func TestStampBinary(t *testing.T) {
+	sent := codec.Message{Sequence: 3, ClientSendUs: 1, Pad: []byte("payload")}
+	message, _ := codec.Binary.Append(nil, &sent)
+	recvUs := time.Now().UnixNano() / 1000
+	var got codec.Message
+	if err := codec.Binary.Decode(stampBinary(message, recvUs), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sequence != 3 || got.ServerRecvUs != recvUs || got.ServerSendUs < recvUs || string(got.Pad) != "payload" {
+		t.Errorf("stamped %+v", got)
+	}
+	if stampBinary(message[:codec.BinaryHeaderSize-1], recvUs) != nil {
+		t.Error("stamped a cut frame")
+	}
+}
+
+// BenchmarkEcho compares the server's processing of one echoed message on the
+// full path (decode, stamp, encode) with the fast path, by codec.
+func BenchmarkEcho(b *testing.B) {
+	s := NewServer(Config{})
+	for _, size := range []int{0, 1024} {
+		m := codec.Message{Sequence: 1, ClientIntendedUs: 1, ClientSendUs: 1, Ticker: codec.DefaultTicker(), Pad: bytes.Repeat([]byte("x"), size)}
+		for _, c := range codec.Codecs {
+			message, _ := c.Append(nil, &m)
+			name := fmt.Sprintf("%s/%dB", c.Name(), size)
+			b.Run(name+"/full", func(b *testing.B) {
+				b.ReportAllocs()
+				var msg codec.Message
+				var buf []byte
+				for i := 0; i < b.N; i++ {
+					if c == codec.JSON {
+						buf, _ = s.processMessage(message, 1)
+					} else {
+						buf, _ = s.processFrame(c, &msg, message, buf[:0], 1)
+					}
+				}
+			})
+			if c != codec.JSON && c != codec.Binary {
+				continue
+			}
+			b.Run(name+"/fast", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					s.fastEcho(c, message, nil, 1)
+				}
+			})
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	addr := startServer(t, Config{PushRate: 1000, PushPayloadSize: 1024})
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/push", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Probes are answered on the same connection as the events
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"_test":{"probe":true,"client_send_ts_us":1}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var lastSeq float64
+	probed := false
+	for events := 0; events < 50 || !probed; {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(message, &data); err != nil {
+			t.Fatal(err)
+		}
+		test := data["_test"].(map[string]interface{})
+		if test["probe"] == true {
+			probed = test["server_ts_us"] != nil && test["server_send_ts_us"] != nil
+			continue
+		}
+
+		events++
+		seq := test["sequence"].(float64)
+		if lastSeq != 0 && seq != lastSeq+1 {
+			t.Errorf("sequence %v after %v", seq, lastSeq)
+		}
+		lastSeq = seq
+		// The send time is stamped when the event is written, after it was
+		// generated and queued
+		if genUs, _ := test["server_gen_ts_us"].(float64); genUs <= 0 || test["server_send_ts_us"].(float64) < genUs {
+			t.Errorf("event without generation and send timestamps: %s", message)
+		}
+		if len(message) < 1000 || len(message) > 1050 {
+			t.Errorf("event size = %d, want about 1024", len(message))
+		}
+	}
+}
+
+func TestBroadcastDropsForSlowSubscribers(t *testing.T) {
+	b := newBroadcaster("tickers", "BTC-USDC", 1000, 0)
+	b.broadcast()
+	if b.sequence != 0 {
+		t.Errorf("sequence = %d after broadcasting without subscribers", b.sequence)
+	}
+
+	// Nothing reads this subscriber's queue
+	sub := newSubscriber(nil, newServerMetrics().endpoint("/push"))
+	b.add(sub)
+	for i := 0; i < pushQueueSize+10; i++ {
+		b.broadcast()
+	}
+	if left := b.remove(sub); left != 0 {
+		t.Errorf("subscribers left = %d, want 0", left)
+	}
+	if dropped := sub.dropped.Load(); dropped != 10 {
+		t.Errorf("dropped = %d, want 10", dropped)
+	}
+	if len(sub.send) != pushQueueSize {
+		t.Errorf("queued = %d, want %d", len(sub.send), pushQueueSize)
+	}
+}
+
+func TestParseChannels(t *testing.T) {
+	rates, err := ParseChannels("tickers:10, trades:100,tickers:BTC-USDT:1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ChannelRate{{"tickers", "", 10}, {"trades", "", 100}, {"tickers", "BTC-USDT", 1000}}
+	if len(rates) != len(want) {
+		t.Fatalf("rates = %v, want %v", rates, want)
+	}
+	for i := range want {
+		if rates[i] != want[i] {
+			t.Errorf("rates[%d] = %v, want %v", i, rates[i], want[i])
+		}
+	}
+
+	for _, tc := range []struct {
+		channel, instID string
+		rate            int
+		ok              bool
+	}{
+		{"tickers", "ETH-USDT", 10, true},
+		{"tickers", "BTC-USDT", 1000, true},
+		{"trades", "BTC-USDT", 100, true},
+		{"books", "BTC-USDT", 0, false},
+	} {
+		rate, ok := channelRate(rates, tc.channel, tc.instID)
+		if rate != tc.rate || ok != tc.ok {
+			t.Errorf("channelRate(%s, %s) = %d, %v, want %d, %v", tc.channel, tc.instID, rate, ok, tc.rate, tc.ok)
+		}
+	}
+
+	for _, spec := range []string{"tickers", "tickers:0", "tickers:fast", ":10", "a:b:c:10"} {
+		if _, err := ParseChannels(spec); err == nil {
+			t.Errorf("ParseChannels(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewServer(Config{Channels: []ChannelRate{{Channel: "tickers", Rate: 1000}, {Channel: "trades", Rate: 500}}})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws/v5/public", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(message string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// next returns the next message that is not an event
+	next := func() map[string]interface{} {
+		t.Helper()
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data map[string]interface{}
+			if err := json.Unmarshal(message, &data); err != nil {
+				t.Fatalf("%s: %v", message, err)
+			}
+			if data["data"] == nil {
+				return data
+			}
+		}
+	}
+
+	send(`{"id":"1","op":"subscribe","args":[{"channel":"tickers","instId":"BTC-USDT"},{"channel":"books","instId":"BTC-USDT"}]}`)
+	if ack := next(); ack["event"] != "subscribe" || ack["id"] != "1" || ack["arg"].(map[string]interface{})["channel"] != "tickers" {
+		t.Errorf("ack = %v", ack)
+	}
+	if e := next(); e["event"] != "error" || e["code"] != codeChannelNotFound {
+		t.Errorf("error = %v, want code %s", e, codeChannelNotFound)
+	}
+	send(`{"op":"resubscribe","args":[]}`)
+	if e := next(); e["event"] != "error" || e["code"] != codeInvalidRequest {
+		t.Errorf("error = %v, want code %s", e, codeInvalidRequest)
+	}
+
+	// Events of the subscribed topic arrive in sequence
+	var lastSeq float64
+	for events := 0; events < 20; {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event struct {
+			Arg  opArg `json:"arg"`
+			Test struct {
+				Sequence float64 `json:"sequence"`
+			} `json:"_test"`
+		}
+		if err := json.Unmarshal(message, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Arg != (opArg{"tickers", "BTC-USDT"}) {
+			t.Fatalf("event of %v", event.Arg)
+		}
+		if lastSeq != 0 && event.Test.Sequence != lastSeq+1 {
+			t.Errorf("sequence %v after %v", event.Test.Sequence, lastSeq)
+		}
+		lastSeq = event.Test.Sequence
+		events++
+	}
+
+	// Unsubscribing from the last subscriber stops the topic
+	send(`{"id":"2","op":"unsubscribe","args":[{"channel":"tickers","instId":"BTC-USDT"}]}`)
+	if ack := next(); ack["event"] != "unsubscribe" || ack["id"] != "2" {
+		t.Errorf("ack = %v", ack)
+	}
+	s.topicsMu.Lock()
+	topics := len(s.topics)
+	s.topicsMu.Unlock()
+	if topics != 0 {
+		t.Errorf("%d topics left after unsubscribing", topics)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	addr := startServer(t, Config{})
+	echo(t, websocket.DefaultDialer, "ws://"+addr+"/ws")
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The invalid message gets no response and the first connection closes
+	// asynchronously, so poll until both are counted
+	var metrics string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics = string(body)
+		if strings.Contains(metrics, `ws_errors_total{op="process"} 1`) &&
+			strings.Contains(metrics, `ws_connections_active{endpoint="/ws"} 1`) {
+			break
+		}
+	}
+
+	for _, want := range []string{
+		`ws_connections_active{endpoint="/ws"} 1`,
+		`ws_events_received_total{endpoint="/ws"} 2`,
+		`ws_events_sent_total{endpoint="/ws"} 1`,
+		`ws_errors_total{op="process"} 1`,
+		`ws_server_processing_seconds_count 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics lack %s:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestPings(t *testing.T) {
+	addr := startServer(t, Config{})
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(appData string) error {
+		pongs <- appData
+		return nil
+	})
+
+	// Pongs are handled while reading, here the response to a message
+	if err := conn.WriteControl(websocket.PingMessage, []byte("ping 1"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"_test":{"sequence":1}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-pongs:
+		if got != "ping 1" {
+			t.Errorf("pong carries %q, want the ping's payload", got)
+		}
+	default:
+		t.Fatal("no pong before the response")
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if want := `ws_pings_received_total{endpoint="/ws"} 1`; !strings.Contains(string(body), want) {
+		t.Errorf("metrics lack %s", want)
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Error codes of subscription errors, as used by OKX
+const (
+	codeInvalidRequest  = "60012"
+	codeChannelNotFound = "60018"
+)
+
+// ChannelRate is the publish rate of a channel. Without an instrument it
+// applies to every instrument of the channel not listed separately.
+type ChannelRate struct {
+	Channel string
+	InstID  string // Empty for the channel default
+	Rate    int    // Events per second per subscribed instrument
+}
+
+// ParseChannels parses a comma-separated list of channel:rate entries, with
+// channel:instId:rate entries overriding the rate of one instrument, e.g.
+// "tickers:10,trades:100,tickers:BTC-USDT:1000".
+func ParseChannels(spec string) ([]ChannelRate, error) {
+	var rates []ChannelRate
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("channel %q: want channel:rate or channel:instId:rate", entry)
+		}
+		rate, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("channel %q: rate must be a positive integer", entry)
+		}
+		r := ChannelRate{Channel: fields[0], Rate: rate}
+		if len(fields) == 3 {
+			r.InstID = fields[1]
+		}
+		if r.Channel == "" {
+			return nil, fmt.Errorf("channel %q: empty channel name", entry)
+		}
+		rates = append(rates, r)
+	}
+	return rates, nil
+}
+
+// channelRate returns the publish rate of channel for instID, or false if the
+// channel does not exist.
+func channelRate(rates []ChannelRate, channel, instID string) (int, bool) {
+	rate, found := 0, false
+	for _, r := range rates {
+		if r.Channel != channel {
+			continue
+		}
+		if r.InstID == instID {
+			return r.Rate, true
+		}
+		if r.InstID == "" {
+			rate, found = r.Rate, true
+		}
+	}
+	return rate, found
+}
+
+// opArg identifies a channel of an instrument in requests and responses.
+type opArg struct {
+	Channel string `json:"channel"`
+	InstID  string `json:"instId"`
+}
+
+// opRequest is a subscribe or unsubscribe request.
+type opRequest struct {
+	ID   string  `json:"id,omitempty"`
+	Op   string  `json:"op"`
+	Args []opArg `json:"args"`
+}
+
+// opResponse acknowledges or rejects a request, echoing its id.
+type opResponse struct {
+	ID     string `json:"id,omitempty"`
+	Event  string `json:"event"`
+	Arg    *opArg `json:"arg,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Msg    string `json:"msg,omitempty"`
+	ConnID string `json:"connId,omitempty"`
+}
+
+// topic is the publisher of one channel and instrument.
+type topic struct {
+	broadcaster *broadcaster
+	stop        chan struct{}
+}
+
+// subscribeTopic adds sub to the topic of arg, starting its publisher if sub
+// is the first subscriber.
+func (s *Server) subscribeTopic(sub *subscriber, arg opArg, rate int) {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	t, ok := s.topics[arg]
+	if !ok {
+		t = &topic{
+			broadcaster: newBroadcaster(arg.Channel, arg.InstID, rate, s.config.PushPayloadSize),
+			stop:        make(chan struct{}),
+		}
+		s.topics[arg] = t
+		go t.broadcaster.run(t.stop)
+	}
+	t.broadcaster.add(sub)
+}
+
+// unsubscribeTopic removes sub from the topic of arg, stopping its publisher
+// if no subscribers are left.
+func (s *Server) unsubscribeTopic(sub *subscriber, arg opArg) {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	t, ok := s.topics[arg]
+	if !ok {
+		return
+	}
+	if t.broadcaster.remove(sub) == 0 {
+		close(t.stop)
+		delete(s.topics, arg)
+	}
+}
+
+// connIDs numbers subscription connections.
+var connIDs atomic.Int64
+
+// handlePublic handles WebSocket connections using the exchange-style
+// subscription protocol: {"op":"subscribe","args":[{"channel":"tickers",
+// "instId":"BTC-USDT"}]} subscribes to events of each argument, acknowledged
+// with {"event":"subscribe","arg":{...}} per argument, and "unsubscribe" ends
+// them. Invalid requests and unknown channels get {"event":"error"}
+// responses. A plain "ping" is answered with "pong", and other JSON messages,
+// such as clock probes, are answered like on /ws.
+func (s *Server) handlePublic(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	m := s.metrics.endpoint("/ws/v5/public")
+	m.active.Inc()
+	defer m.active.Dec()
+	answerPings(conn, m)
+	sub := newSubscriber(conn, m)
+	connID := strconv.FormatInt(connIDs.Add(1), 16)
+	log.Printf("Subscription client connected - %s, connId %s", conn.RemoteAddr(), connID)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		sub.writeLoop()
+	}()
+
+	subscribed := make(map[opArg]bool)
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			m.readError(err)
+			break
+		}
+		recvUs := time.Now().UnixNano() / 1000
+		m.received.Inc()
+		if string(message) == "ping" {
+			sub.enqueue([]byte("pong"))
+			continue
+		}
+
+		var req opRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			sub.enqueue(encodeResponse(opResponse{Event: "error", Code: codeInvalidRequest, Msg: "Invalid request: " + string(message)}))
+			continue
+		}
+		if req.Op == "" {
+			response, err := s.processMessage(message, recvUs)
+			if err != nil {
+				log.Println("Process error:", err)
+				continue
+			}
+			sub.enqueue(response)
+			continue
+		}
+		for _, response := range s.handleOp(sub, req, subscribed, connID) {
+			sub.enqueue(encodeResponse(response))
+		}
+	}
+
+	for arg := range subscribed {
+		s.unsubscribeTopic(sub, arg)
+	}
+	close(sub.send)
+	<-writerDone
+	log.Printf("Subscription client disconnected - %s, %d subscriptions, %d messages dropped",
+		conn.RemoteAddr(), len(subscribed), sub.dropped.Load())
+}
+
+// handleOp carries out a subscribe or unsubscribe request for sub, whose
+// subscriptions are tracked in subscribed, and returns the responses.
+func (s *Server) handleOp(sub *subscriber, req opRequest, subscribed map[opArg]bool, connID string) []opResponse {
+	if req.Op != "subscribe" && req.Op != "unsubscribe" {
+		return []opResponse{{ID: req.ID, Event: "error", Code: codeInvalidRequest, Msg: "Invalid request: unknown op " + req.Op}}
+	}
+	if len(req.Args) == 0 {
+		return []opResponse{{ID: req.ID, Event: "error", Code: codeInvalidRequest, Msg: "Invalid request: missing args"}}
+	}
+
+	responses := make([]opResponse, 0, len(req.Args))
+	for _, arg := range req.Args {
+		arg := arg
+		if req.Op == "unsubscribe" {
+			if subscribed[arg] {
+				s.unsubscribeTopic(sub, arg)
+				delete(subscribed, arg)
+			}
+			responses = append(responses, opResponse{ID: req.ID, Event: "unsubscribe", Arg: &arg, ConnID: connID})
+			continue
+		}
+
+		rate, ok := channelRate(s.config.Channels, arg.Channel, arg.InstID)
+		if !ok || arg.InstID == "" {
+			responses = append(responses, opResponse{ID: req.ID, Event: "error", Arg: &arg, Code: codeChannelNotFound,
+				Msg: fmt.Sprintf("Wrong URL or channel:%s,instId:%s doesn't exist", arg.Channel, arg.InstID)})
+			continue
+		}
+		if !subscribed[arg] {
+			s.subscribeTopic(sub, arg, rate)
+			subscribed[arg] = true
+		}
+		responses = append(responses, opResponse{ID: req.ID, Event: "subscribe", Arg: &arg, ConnID: connID})
+	}
+	return responses
+}
+
+// encodeResponse encodes a subscription response.
+func encodeResponse(response opResponse) []byte {
+	message, err := json.Marshal(response)
+	if err != nil {
+		log.Println("JSON marshal error:", err)
+	}
+	return message
+}
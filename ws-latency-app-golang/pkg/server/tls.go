@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// selfSignedValidity is how long a generated certificate is valid for.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// tlsEnabled reports whether the server terminates TLS itself.
+func (c Config) tlsEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSKeyFile != "" || c.TLSSelfSigned
+}
+
+// newTLSConfig builds the server TLS configuration from the certificate and
+// key files, or from a freshly generated self-signed certificate.
+func newTLSConfig(config Config) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case config.TLSCertFile != "" || config.TLSKeyFile != "":
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("server certificate and key must be given together")
+		}
+		if config.TLSSelfSigned {
+			return nil, fmt.Errorf("a self-signed certificate cannot be combined with certificate files")
+		}
+		cert, err = tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load server certificate: %w", err)
+		}
+	default:
+		cert, err = generateSelfSignedCert(selfSignedHosts())
+		if err != nil {
+			return nil, fmt.Errorf("generate self-signed certificate: %w", err)
+		}
+		fingerprint := sha256.Sum256(cert.Certificate[0])
+		log.Printf("Generated self-signed certificate (SHA-256 %X); clients need -insecure\n", fingerprint)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// selfSignedHosts returns the names a self-signed certificate is issued for:
+// localhost, the loopback addresses and the host name.
+func selfSignedHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+// generateSelfSignedCert creates an ECDSA P-256 certificate for hosts, which
+// may be DNS names or IP addresses.
+func generateSelfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"ws-latency-app"}},
+		NotBefore:    now.Add(-time.Hour), // Tolerate clock skew
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,326 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Default histogram range and precision. Values are recorded in microseconds,
+// so the default range covers 1µs up to 10 minutes.
+const (
+	DefaultLowestTrackableValue  int64 = 1
+	DefaultHighestTrackableValue int64 = 10 * 60 * 1000 * 1000
+	DefaultSignificantFigures          = 3
+)
+
+// Histogram is a fixed-memory HDR-style histogram. Values are grouped into
+// exponentially sized buckets, each split into linear sub-buckets, so the
+// relative error of any recorded value is bounded by the configured number of
+// significant figures regardless of how many values are recorded.
+type Histogram struct {
+	lowestTrackableValue  int64
+	highestTrackableValue int64
+	significantFigures    int
+
+	unitMagnitude               int
+	subBucketHalfCountMagnitude int
+	subBucketCount              int
+	subBucketHalfCount          int
+	subBucketMask               int64
+	bucketCount                 int
+
+	counts     []int64
+	totalCount int64
+	min        int64
+	max        int64
+}
+
+// NewHistogram creates a histogram able to track values between lowest and
+// highest (inclusive) with the given number of significant decimal figures
+// (1 to 5).
+func NewHistogram(lowest, highest int64, significantFigures int) (*Histogram, error) {
+	if lowest < 1 {
+		return nil, fmt.Errorf("lowest trackable value must be >= 1, got %d", lowest)
+	}
+	if highest < 2*lowest {
+		return nil, fmt.Errorf("highest trackable value must be >= 2 * lowest, got %d", highest)
+	}
+	if significantFigures < 1 || significantFigures > 5 {
+		return nil, fmt.Errorf("significant figures must be between 1 and 5, got %d", significantFigures)
+	}
+
+	largestValueWithSingleUnitResolution := 2 * int64(math.Pow10(significantFigures))
+	subBucketCountMagnitude := int(math.Ceil(math.Log2(float64(largestValueWithSingleUnitResolution))))
+	subBucketHalfCountMagnitude := subBucketCountMagnitude - 1
+	if subBucketHalfCountMagnitude < 0 {
+		subBucketHalfCountMagnitude = 0
+	}
+	unitMagnitude := int(math.Floor(math.Log2(float64(lowest))))
+	subBucketCount := 1 << (subBucketHalfCountMagnitude + 1)
+
+	// Find the number of buckets needed to cover the highest trackable value
+	smallestUntrackableValue := int64(subBucketCount) << unitMagnitude
+	bucketCount := 1
+	for smallestUntrackableValue <= highest {
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			bucketCount++
+			break
+		}
+		smallestUntrackableValue <<= 1
+		bucketCount++
+	}
+
+	h := &Histogram{
+		lowestTrackableValue:        lowest,
+		highestTrackableValue:       highest,
+		significantFigures:          significantFigures,
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketCount:              subBucketCount,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               int64(subBucketCount-1) << unitMagnitude,
+		bucketCount:                 bucketCount,
+		counts:                      make([]int64, (bucketCount+1)*(subBucketCount/2)),
+	}
+	h.Reset()
+	return h, nil
+}
+
+// Reset clears all recorded values.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.totalCount = 0
+	h.min = math.MaxInt64
+	h.max = 0
+}
+
+// Copy returns an independent copy of the histogram.
+func (h *Histogram) Copy() *Histogram {
+	c := *h
+	c.counts = make([]int64, len(h.counts))
+	copy(c.counts, h.counts)
+	return &c
+}
+
+// Merge adds all values recorded in other to this histogram. Values in other
+// that are outside this histogram's range are clamped to its bounds.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.totalCount == 0 {
+		return
+	}
+	prevMin, prevMax := h.min, h.max
+	for i, count := range other.counts {
+		if count == 0 {
+			continue
+		}
+		v := other.valueFromIndex(i)
+		if v > h.highestTrackableValue {
+			v = h.highestTrackableValue
+		}
+		h.RecordValues(v, count)
+	}
+
+	// Recording bucket values loses the exact extremes, so restore them
+	h.min = prevMin
+	if other.min < h.min {
+		h.min = other.min
+	}
+	h.max = prevMax
+	otherMax := other.max
+	if otherMax > h.highestTrackableValue {
+		otherMax = h.highestTrackableValue
+	}
+	if otherMax > h.max {
+		h.max = otherMax
+	}
+}
+
+// RecordValue records a single value.
+func (h *Histogram) RecordValue(v int64) error {
+	return h.RecordValues(v, 1)
+}
+
+// RecordValues records n occurrences of a value.
+func (h *Histogram) RecordValues(v, n int64) error {
+	if v < 0 || v > h.highestTrackableValue {
+		return fmt.Errorf("value %d outside trackable range [0, %d]", v, h.highestTrackableValue)
+	}
+	h.counts[h.countsIndexFor(v)] += n
+	h.totalCount += n
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	return nil
+}
+
+// TotalCount returns the number of recorded values.
+func (h *Histogram) TotalCount() int64 {
+	return h.totalCount
+}
+
+// Min returns the smallest recorded value, or 0 if the histogram is empty.
+func (h *Histogram) Min() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value, or 0 if the histogram is empty.
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the mean of the recorded values, computed from bucket midpoints.
+func (h *Histogram) Mean() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	var total float64
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		total += float64(count) * float64(h.medianEquivalentValue(h.valueFromIndex(i)))
+	}
+	return total / float64(h.totalCount)
+}
+
+// StdDev returns the population standard deviation of the recorded values,
+// computed from bucket midpoints.
+func (h *Histogram) StdDev() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	mean := h.Mean()
+	var geometricDevTotal float64
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		dev := float64(h.medianEquivalentValue(h.valueFromIndex(i))) - mean
+		geometricDevTotal += dev * dev * float64(count)
+	}
+	return math.Sqrt(geometricDevTotal / float64(h.totalCount))
+}
+
+// ValueAtPercentile returns the value below which the given percentage
+// (0-100) of recorded values fall. The result is the highest value equivalent
+// to the matching bucket, capped at the recorded maximum.
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	if percentile <= 0 {
+		return h.Min()
+	}
+
+	countAtPercentile := int64(percentile/100*float64(h.totalCount) + 0.5)
+	if countAtPercentile < 1 {
+		countAtPercentile = 1
+	}
+
+	var cumulative int64
+	for i, count := range h.counts {
+		cumulative += count
+		if cumulative >= countAtPercentile {
+			v := h.highestEquivalentValue(h.valueFromIndex(i))
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// Bucket is a range of equivalent values and the number of values recorded
+// in it.
+type Bucket struct {
+	LowUs  int64 `json:"low_us"`
+	HighUs int64 `json:"high_us"`
+	Count  int64 `json:"count"`
+}
+
+// Buckets returns the non-empty buckets in increasing order of value.
+func (h *Histogram) Buckets() []Bucket {
+	var buckets []Bucket
+	for i, count := range h.counts {
+		if count == 0 {
+			continue
+		}
+		v := h.valueFromIndex(i)
+		buckets = append(buckets, Bucket{LowUs: h.lowestEquivalentValue(v), HighUs: h.highestEquivalentValue(v), Count: count})
+	}
+	return buckets
+}
+
+// countsIndexFor returns the index into counts for the given value.
+func (h *Histogram) countsIndexFor(v int64) int {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	return h.countsIndex(bucketIndex, subBucketIndex)
+}
+
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - h.unitMagnitude - (h.subBucketHalfCountMagnitude + 1)
+}
+
+func (h *Histogram) subBucketIndex(v int64, bucketIndex int) int {
+	return int(v >> uint(bucketIndex+h.unitMagnitude))
+}
+
+func (h *Histogram) countsIndex(bucketIndex, subBucketIndex int) int {
+	bucketBaseIndex := (bucketIndex + 1) << uint(h.subBucketHalfCountMagnitude)
+	offsetInBucket := subBucketIndex - h.subBucketHalfCount
+	return bucketBaseIndex + offsetInBucket
+}
+
+// valueFromIndex returns the lowest value that maps to the given counts index.
+func (h *Histogram) valueFromIndex(index int) int64 {
+	bucketIndex := (index >> uint(h.subBucketHalfCountMagnitude)) - 1
+	subBucketIndex := (index & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucketIndex < 0 {
+		subBucketIndex -= h.subBucketHalfCount
+		bucketIndex = 0
+	}
+	return int64(subBucketIndex) << uint(bucketIndex+h.unitMagnitude)
+}
+
+// sizeOfEquivalentValueRange returns the width of the bucket containing v.
+func (h *Histogram) sizeOfEquivalentValueRange(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	adjustedBucket := bucketIndex
+	if subBucketIndex >= h.subBucketCount {
+		adjustedBucket++
+	}
+	return int64(1) << uint(h.unitMagnitude+adjustedBucket)
+}
+
+func (h *Histogram) lowestEquivalentValue(v int64) int64 {
+	bucketIndex := h.bucketIndex(v)
+	subBucketIndex := h.subBucketIndex(v, bucketIndex)
+	return int64(subBucketIndex) << uint(bucketIndex+h.unitMagnitude)
+}
+
+func (h *Histogram) highestEquivalentValue(v int64) int64 {
+	return h.lowestEquivalentValue(v) + h.sizeOfEquivalentValueRange(v) - 1
+}
+
+func (h *Histogram) medianEquivalentValue(v int64) int64 {
+	return h.lowestEquivalentValue(v) + h.sizeOfEquivalentValueRange(v)>>1
+}
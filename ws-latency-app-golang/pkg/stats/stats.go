@@ -0,0 +1,198 @@
+// Package stats provides latency statistics calculation for WebSocket latency testing
+package stats
+
+import (
+	"log"
+	"sync"
+)
+
+// LatencyStats collects latency samples in microseconds and summarizes them.
+// Samples are stored in a fixed-memory histogram, so memory use does not grow
+// with the number of messages sent. It is safe to add samples from one
+// goroutine while another calculates, snapshots or resets.
+type LatencyStats struct {
+	Name    string
+	Count   int64
+	Min     int64
+	Max     int64
+	P10     int64
+	P50     int64
+	P90     int64
+	P99     int64
+	P999    int64
+	Mean    float64
+	StdDev  float64
+	Clamped int64
+
+	mu   sync.Mutex
+	hist *Histogram
+}
+
+// NewLatencyStats creates latency statistics with the given name, recording
+// values with the given number of significant figures (1 to 5). Out-of-range
+// precision falls back to DefaultSignificantFigures.
+func NewLatencyStats(name string, significantFigures int) *LatencyStats {
+	hist, err := NewHistogram(DefaultLowestTrackableValue, DefaultHighestTrackableValue, significantFigures)
+	if err != nil {
+		log.Printf("Invalid histogram precision %d, using %d: %v", significantFigures, DefaultSignificantFigures, err)
+		hist, _ = NewHistogram(DefaultLowestTrackableValue, DefaultHighestTrackableValue, DefaultSignificantFigures)
+	}
+	return &LatencyStats{
+		Name: name,
+		hist: hist,
+	}
+}
+
+// AddSample records a latency sample in microseconds. Samples outside the
+// histogram range are clamped to its bounds and counted in Clamped.
+func (s *LatencyStats) AddSample(latencyUs int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if latencyUs < 0 {
+		latencyUs = 0
+		s.Clamped++
+	} else if latencyUs > s.hist.highestTrackableValue {
+		latencyUs = s.hist.highestTrackableValue
+		s.Clamped++
+	}
+	s.hist.RecordValue(latencyUs)
+}
+
+// Percentile returns the latency at the given percentile (0-100).
+func (s *LatencyStats) Percentile(percentile float64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hist.ValueAtPercentile(percentile)
+}
+
+// Calculate updates the summary fields from the recorded samples.
+func (s *LatencyStats) Calculate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calculate()
+}
+
+func (s *LatencyStats) calculate() {
+	s.Count = s.hist.TotalCount()
+	s.Min = s.hist.Min()
+	s.Max = s.hist.Max()
+	s.P10 = s.hist.ValueAtPercentile(10)
+	s.P50 = s.hist.ValueAtPercentile(50)
+	s.P90 = s.hist.ValueAtPercentile(90)
+	s.P99 = s.hist.ValueAtPercentile(99)
+	s.P999 = s.hist.ValueAtPercentile(99.9)
+	s.Mean = s.hist.Mean()
+	s.StdDev = s.hist.StdDev()
+}
+
+// Reset clears all recorded samples and calculated values.
+func (s *LatencyStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+func (s *LatencyStats) reset() {
+	s.hist.Reset()
+	s.Count, s.Min, s.Max = 0, 0, 0
+	s.P10, s.P50, s.P90, s.P99, s.P999 = 0, 0, 0, 0, 0
+	s.Mean, s.StdDev = 0, 0
+	s.Clamped = 0
+}
+
+// Snapshot returns a calculated copy of the statistics. If reset is true, the
+// recorded samples are cleared in the same step, so no sample is lost or
+// counted twice between consecutive snapshots.
+func (s *LatencyStats) Snapshot(reset bool) *LatencyStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &LatencyStats{
+		Name:    s.Name,
+		Clamped: s.Clamped,
+		hist:    s.hist.Copy(),
+	}
+	snap.calculate()
+	if reset {
+		s.reset()
+	}
+	return snap
+}
+
+// Merge adds all samples recorded in other to these statistics.
+func (s *LatencyStats) Merge(other *LatencyStats) {
+	other.mu.Lock()
+	hist := other.hist.Copy()
+	clamped := other.Clamped
+	other.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hist.Merge(hist)
+	s.Clamped += clamped
+}
+
+// SummaryPercentiles are the percentiles listed in a Summary.
+var SummaryPercentiles = []float64{10, 25, 50, 75, 90, 95, 99, 99.9, 99.99, 100}
+
+// Percentile is the latency at one percentile.
+type Percentile struct {
+	Percentile float64 `json:"percentile"`
+	ValueUs    int64   `json:"value_us"`
+}
+
+// Summary is a machine-readable copy of the statistics, in microseconds,
+// with a percentile table and the non-empty histogram buckets.
+type Summary struct {
+	Name        string       `json:"name"`
+	Count       int64        `json:"count"`
+	MinUs       int64        `json:"min_us"`
+	MaxUs       int64        `json:"max_us"`
+	MeanUs      float64      `json:"mean_us"`
+	StdDevUs    float64      `json:"stddev_us"`
+	Clamped     int64        `json:"clamped"`
+	Percentiles []Percentile `json:"percentiles"`
+	Buckets     []Bucket     `json:"buckets"`
+}
+
+// Summary calculates the statistics and returns them as a Summary.
+func (s *LatencyStats) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calculate()
+	summary := Summary{
+		Name:     s.Name,
+		Count:    s.Count,
+		MinUs:    s.Min,
+		MaxUs:    s.Max,
+		MeanUs:   s.Mean,
+		StdDevUs: s.StdDev,
+		Clamped:  s.Clamped,
+		Buckets:  s.hist.Buckets(),
+	}
+	for _, p := range SummaryPercentiles {
+		summary.Percentiles = append(summary.Percentiles, Percentile{Percentile: p, ValueUs: s.hist.ValueAtPercentile(p)})
+	}
+	return summary
+}
+
+// PrintResults prints the calculated statistics.
+func (s *LatencyStats) PrintResults() {
+	log.Printf("===== %s Latency Statistics (µs) =====\n", s.Name)
+	if s.Count == 0 {
+		log.Println("No samples collected")
+		return
+	}
+	log.Printf("Samples: %d\n", s.Count)
+	log.Printf("Min:     %d\n", s.Min)
+	log.Printf("P10:     %d\n", s.P10)
+	log.Printf("P50:     %d\n", s.P50)
+	log.Printf("P90:     %d\n", s.P90)
+	log.Printf("P99:     %d\n", s.P99)
+	log.Printf("P99.9:   %d\n", s.P999)
+	log.Printf("Max:     %d\n", s.Max)
+	log.Printf("Mean:    %.2f\n", s.Mean)
+	log.Printf("StdDev:  %.2f\n", s.StdDev)
+	if s.Clamped > 0 {
+		log.Printf("Note: %d samples were outside the histogram range and clamped\n", s.Clamped)
+	}
+}
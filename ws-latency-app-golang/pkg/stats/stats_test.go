@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func newTestHistogram(t *testing.T, significantFigures int) *Histogram {
+	t.Helper()
+	h, err := NewHistogram(DefaultLowestTrackableValue, DefaultHighestTrackableValue, significantFigures)
+	if err != nil {
+		t.Fatalf("NewHistogram: %v", err)
+	}
+	return h
+}
+
+func TestNewHistogramRejectsInvalidConfig(t *testing.T) {
+	cases := []struct {
+		lowest, highest int64
+		sigFigs         int
+	}{
+		{0, 1000, 3},
+		{10, 15, 3},
+		{1, 1000, 0},
+		{1, 1000, 6},
+	}
+	for _, c := range cases {
+		if _, err := NewHistogram(c.lowest, c.highest, c.sigFigs); err == nil {
+			t.Errorf("NewHistogram(%d, %d, %d) succeeded, want error", c.lowest, c.highest, c.sigFigs)
+		}
+	}
+}
+
+func TestPercentilesExactBelowResolution(t *testing.T) {
+	h := newTestHistogram(t, 3)
+	for v := int64(1); v <= 1000; v++ {
+		if err := h.RecordValue(v); err != nil {
+			t.Fatalf("RecordValue(%d): %v", v, err)
+		}
+	}
+
+	cases := []struct {
+		percentile float64
+		want       int64
+	}{
+		{0, 1},
+		{10, 100},
+		{50, 500},
+		{90, 900},
+		{99, 990},
+		{99.9, 999},
+		{100, 1000},
+	}
+	for _, c := range cases {
+		if got := h.ValueAtPercentile(c.percentile); got != c.want {
+			t.Errorf("ValueAtPercentile(%v) = %d, want %d", c.percentile, got, c.want)
+		}
+	}
+	if h.Min() != 1 || h.Max() != 1000 {
+		t.Errorf("Min/Max = %d/%d, want 1/1000", h.Min(), h.Max())
+	}
+}
+
+func TestPercentilesWithinRelativeError(t *testing.T) {
+	for _, sigFigs := range []int{2, 3, 4} {
+		h := newTestHistogram(t, sigFigs)
+		const n = 100000
+		for i := int64(1); i <= n; i++ {
+			// Spread values across several orders of magnitude
+			h.RecordValue(i * 37)
+		}
+
+		tolerance := math.Pow10(-sigFigs)
+		for _, p := range []float64{1, 25, 50, 75, 90, 99, 99.9, 99.99} {
+			want := float64(int64(p/100*n+0.5) * 37)
+			got := float64(h.ValueAtPercentile(p))
+			if math.Abs(got-want)/want > tolerance {
+				t.Errorf("sigFigs=%d: ValueAtPercentile(%v) = %v, want %v within %v", sigFigs, p, got, want, tolerance)
+			}
+		}
+	}
+}
+
+func TestMeanAndStdDev(t *testing.T) {
+	h := newTestHistogram(t, 3)
+	for _, v := range []int64{2, 4, 4, 4, 5, 5, 7, 9} {
+		h.RecordValue(v)
+	}
+	if got := h.Mean(); got != 5 {
+		t.Errorf("Mean() = %v, want 5", got)
+	}
+	if got := h.StdDev(); got != 2 {
+		t.Errorf("StdDev() = %v, want 2", got)
+	}
+}
+
+func TestRecordValueOutOfRange(t *testing.T) {
+	h := newTestHistogram(t, 3)
+	if err := h.RecordValue(-1); err == nil {
+		t.Error("RecordValue(-1) succeeded, want error")
+	}
+	if err := h.RecordValue(DefaultHighestTrackableValue + 1); err == nil {
+		t.Error("RecordValue above highest trackable value succeeded, want error")
+	}
+	if h.TotalCount() != 0 {
+		t.Errorf("TotalCount() = %d, want 0", h.TotalCount())
+	}
+}
+
+func TestEmptyHistogram(t *testing.T) {
+	h := newTestHistogram(t, 3)
+	if h.ValueAtPercentile(99) != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 || h.StdDev() != 0 {
+		t.Error("empty histogram should report zero for all statistics")
+	}
+}
+
+func TestLatencyStatsCalculate(t *testing.T) {
+	s := NewLatencyStats("RTT", 3)
+	for v := int64(1); v <= 100; v++ {
+		s.AddSample(v)
+	}
+	s.AddSample(-5)
+	s.Calculate()
+
+	if s.Count != 101 {
+		t.Errorf("Count = %d, want 101", s.Count)
+	}
+	if s.Clamped != 1 {
+		t.Errorf("Clamped = %d, want 1", s.Clamped)
+	}
+	if s.Min != 0 || s.Max != 100 {
+		t.Errorf("Min/Max = %d/%d, want 0/100", s.Min, s.Max)
+	}
+	if s.P50 != 50 || s.P90 != 90 || s.P99 != 99 {
+		t.Errorf("P50/P90/P99 = %d/%d/%d, want 50/90/99", s.P50, s.P90, s.P99)
+	}
+}
+
+func TestNewLatencyStatsFallsBackOnInvalidPrecision(t *testing.T) {
+	s := NewLatencyStats("RTT", 0)
+	if s.hist.significantFigures != DefaultSignificantFigures {
+		t.Errorf("significantFigures = %d, want %d", s.hist.significantFigures, DefaultSignificantFigures)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := NewLatencyStats("RTT", 3)
+	for v := int64(1); v <= 10000; v++ {
+		s.AddSample(v)
+	}
+	summary := s.Summary()
+	if summary.Count != 10000 || summary.MinUs != 1 || summary.MaxUs != 10000 {
+		t.Errorf("count=%d min=%d max=%d, want 10000, 1, 10000", summary.Count, summary.MinUs, summary.MaxUs)
+	}
+	if len(summary.Percentiles) != len(SummaryPercentiles) {
+		t.Fatalf("%d percentiles, want %d", len(summary.Percentiles), len(SummaryPercentiles))
+	}
+	if p50 := summary.Percentiles[2]; p50.Percentile != 50 || math.Abs(float64(p50.ValueUs-5000)) > 5 {
+		t.Errorf("p50 = %+v, want about 5000", p50)
+	}
+
+	// Buckets are ordered, disjoint and hold every sample
+	var total int64
+	for i, b := range summary.Buckets {
+		total += b.Count
+		if b.HighUs < b.LowUs || (i > 0 && b.LowUs <= summary.Buckets[i-1].HighUs) {
+			t.Fatalf("bucket %d = %+v after %+v", i, b, summary.Buckets[i-1])
+		}
+	}
+	if total != 10000 {
+		t.Errorf("buckets hold %d samples, want 10000", total)
+	}
+}